@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// profile is a set of flag values, keyed by the long flag name e.g. scan-type. Values may be lists for flags which
+// can be given more than once.
+type profile map[string]interface{}
+
+// config is the contents of a --config file. Top level flag values are defaults, a profile may be selected, and
+// further profiles may be defined.
+type config struct {
+	Profile  string             `yaml:"profile"`
+	Profiles map[string]profile `yaml:"profiles"`
+	Values   profile            `yaml:",inline"`
+}
+
+// broadcastExclusions are multicast and broadcast addresses, which are never worth probing in a sweep
+var broadcastExclusions = []interface{}{"224.0.0.0/4", "255.255.255.255"}
+
+// builtinProfiles are the profiles available without a config file. Timing is left to the timing templates, so that
+// probe timeouts adapt to the network.
+var builtinProfiles = map[string]profile{
+	"quick": {
+		"scan-type": "connect",
+		"ports":     "21,22,23,25,53,80,110,139,143,443,445,3306,3389,5900,8080,8443",
+		"timing":    4,
+		"retries":   0,
+		"output":    []interface{}{"text"},
+	},
+	"full": {
+		"scan-type":      "stealth",
+		"ports":          "1-65535",
+		"timing":         4,
+		"service-detect": true,
+		"os-detect":      true,
+		"output":         []interface{}{"text", "xml:furious-full.xml"},
+		"exclude":        broadcastExclusions,
+	},
+	"stealth-slow": {
+		"scan-type": "stealth",
+		"timing":    2,
+		"output":    []interface{}{"text"},
+	},
+	"iot": {
+		"scan-type":      "connect",
+		"ports":          "23,80,81,443,554,1883,1900,5000,5683,8000,8080,8081,8443,8883,49152",
+		"timing":         3,
+		"retries":        1,
+		"service-detect": true,
+		"snmp":           true,
+		"output":         []interface{}{"text"},
+		"exclude":        broadcastExclusions,
+	},
+}
+
+// profileNames returns the names of the built in profiles and of the profiles defined in c
+func profileNames(c *config) []string {
+	names := []string{}
+	for name := range builtinProfiles {
+		names = append(names, name)
+	}
+	for name := range c.Profiles {
+		if _, ok := builtinProfiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadConfig reads a YAML config file, or a TOML config file if the file name ends in .toml
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		// TOML is converted to YAML, so that both formats are checked by the same strict decoding
+		values := map[string]interface{}{}
+		if _, err := toml.Decode(string(data), &values); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %s", path, err)
+		}
+		if data, err = yaml.Marshal(values); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %s", path, err)
+		}
+	}
+	c := &config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+	return c, nil
+}
+
+// applyConfig sets flags from the config file at path (if any) and the named profile (if any). Values from the
+// profile take precedence over the top level values in the config file, and flags given on the command line take
+// precedence over both. The profile may be defined in the config file, or be one of the built in profiles.
+func applyConfig(flags *pflag.FlagSet, path string, profileName string) error {
+
+	c := &config{}
+	if path != "" {
+		loaded, err := loadConfig(path)
+		if err != nil {
+			return err
+		}
+		c = loaded
+	}
+
+	values := profile{}
+	for name, value := range c.Values {
+		values[name] = value
+	}
+
+	if profileName == "" {
+		profileName = c.Profile
+	}
+	if profileName != "" {
+		selected, ok := c.Profiles[profileName]
+		if !ok {
+			selected, ok = builtinProfiles[profileName]
+		}
+		if !ok {
+			return fmt.Errorf("unknown profile '%s'. Profiles are: %s", profileName, strings.Join(profileNames(c), ", "))
+		}
+		for name, value := range selected {
+			values[name] = value
+		}
+	}
+
+	// flags given on the command line are left alone. Changed is checked up front, since setting a flag marks it
+	// as changed.
+	given := map[string]bool{}
+	flags.Visit(func(flag *pflag.Flag) {
+		given[flag.Name] = true
+	})
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		flag := flags.Lookup(name)
+		if flag == nil || name == "config" || name == "profile" {
+			return fmt.Errorf("unknown setting '%s' in config: settings are named after flags e.g. scan-type", name)
+		}
+		if given[name] {
+			continue
+		}
+		configured := configValues(values[name])
+		if _, isList := values[name].([]interface{}); isList && !repeatable(flag) {
+			return fmt.Errorf("invalid value for '%s' in config: a list was given, but the setting takes a single value", name)
+		}
+		for _, value := range configured {
+			if err := flags.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value for '%s' in config: %s", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// repeatable reports whether a flag collects a value each time it is given, rather than keeping the last one
+func repeatable(flag *pflag.Flag) bool {
+	kind := flag.Value.Type()
+	return strings.HasSuffix(kind, "Slice") || strings.HasSuffix(kind, "Array")
+}
+
+// configValues converts a config value to the flag values it represents: one for each item of a list, otherwise one
+func configValues(value interface{}) []string {
+	if list, ok := value.([]interface{}); ok {
+		values := []string{}
+		for _, item := range list {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	}
+	if value == nil {
+		return nil
+	}
+	return []string{fmt.Sprint(value)}
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/liamg/furious/scan"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchControlSignals pauses the scan on SIGUSR1 and resumes it on SIGUSR2, calling notice to report each change.
+func watchControlSignals(control *scan.Control, notice func(string)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				control.Pause()
+				notice("Scan paused. Send SIGUSR2 to resume.")
+			} else {
+				control.Resume()
+				notice("Scan resumed.")
+			}
+		}
+	}()
+}
+
+// serveControl listens on a UNIX socket at path for commands which control the scan, one per line. The returned
+// function stops listening and removes the socket.
+func serveControl(control *scan.Control, path string, notice func(string)) (func(), error) {
+
+	// remove a socket left behind by a previous scan, but never anything else
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s already exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					line := strings.TrimSpace(scanner.Text())
+					if line == "" {
+						continue
+					}
+					response, err := runControlCommand(control, line)
+					if err != nil {
+						fmt.Fprintf(conn, "error: %s\n", err)
+						continue
+					}
+					log.Debugf("Control command: %s", line)
+					if line != "status" {
+						notice(response)
+					}
+					fmt.Fprintln(conn, response)
+				}
+			}(conn)
+		}
+	}()
+
+	return func() {
+		_ = listener.Close()
+		_ = os.Remove(path)
+	}, nil
+}
+
+// runControlCommand applies a control command, returning a description of the result.
+func runControlCommand(control *scan.Control, line string) (string, error) {
+	fields := strings.Fields(line)
+	command, args := strings.ToLower(fields[0]), fields[1:]
+
+	switch command {
+	case "pause":
+		control.Pause()
+		return "Scan paused.", nil
+	case "resume":
+		control.Resume()
+		return "Scan resumed.", nil
+	case "status":
+		return controlStatus(control), nil
+	}
+
+	if len(args) != 1 {
+		return "", fmt.Errorf("unknown command '%s'. Commands are: pause, resume, status, rate N, workers N, timeout MS", line)
+	}
+
+	switch command {
+	case "rate":
+		rate, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", fmt.Errorf("invalid rate '%s'", args[0])
+		}
+		control.SetRate(rate)
+		if rate <= 0 {
+			return "Rate is no longer limited.", nil
+		}
+		return fmt.Sprintf("Rate limited to %d probes/s.", rate), nil
+	case "workers":
+		workers, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", fmt.Errorf("invalid worker count '%s'", args[0])
+		}
+		control.SetWorkers(workers)
+		if workers <= 0 {
+			return "Workers are no longer limited.", nil
+		}
+		return fmt.Sprintf("Workers limited to %d.", workers), nil
+	case "timeout":
+		timeout, err := parseTimeout(args[0])
+		if err != nil {
+			return "", err
+		}
+		control.SetTimeout(timeout)
+		if timeout <= 0 {
+			return "Timeout reset to the value given at start.", nil
+		}
+		return fmt.Sprintf("Timeout set to %s.", timeout), nil
+	}
+
+	return "", fmt.Errorf("unknown command '%s'. Commands are: pause, resume, status, rate N, workers N, timeout MS", line)
+}
+
+// parseTimeout parses a timeout given in milliseconds (as with --timeout-ms), or as a duration e.g. 1.5s
+func parseTimeout(value string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(value); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout '%s'", value)
+	}
+	return timeout, nil
+}
+
+// controlStatus describes the current state of the control
+func controlStatus(control *scan.Control) string {
+	state := "running"
+	if control.Paused() {
+		state = "paused"
+	}
+	rate := "unlimited"
+	if limit := control.Rate(); limit > 0 {
+		rate = fmt.Sprintf("%d/s", limit)
+	}
+	workers := fmt.Sprintf("%d active", control.Active())
+	if limit := control.Workers(); limit > 0 {
+		workers = fmt.Sprintf("%d/%d active", control.Active(), limit)
+	}
+	timeout := "default"
+	if override := control.Timeout(); override > 0 {
+		timeout = override.String()
+	}
+	return fmt.Sprintf("state=%s rate=%s workers=%s timeout=%s", state, rate, workers, timeout)
+}
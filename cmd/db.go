@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/liamg/furious/output"
+	"github.com/liamg/furious/scan"
+	"github.com/liamg/furious/store"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	dbCmd.AddCommand(dbListCmd)
+	dbCmd.AddCommand(dbShowCmd)
+	dbCmd.AddCommand(dbQueryCmd)
+	rootCmd.AddCommand(dbCmd)
+}
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Inspect scan history recorded with --db",
+}
+
+var dbListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recorded scans",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openStore(store.Open)
+		defer db.Close()
+
+		scans, err := db.Scans()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTARTED\tDURATION\tTYPE\tTARGETS\tHOSTS UP\tOPEN PORTS")
+		for _, record := range scans {
+			duration := "incomplete"
+			if record.Info.End != nil {
+				duration = record.Info.End.Sub(record.Info.Start).Round(time.Millisecond).String()
+				if record.Info.Incomplete {
+					duration += " (cancelled)"
+				}
+			}
+			fmt.Fprintf(
+				w,
+				"%d\t%s\t%s\t%s\t%s\t%d/%d\t%d\n",
+				record.ID,
+				record.Info.Start.Local().Format("2006-01-02 15:04:05"),
+				duration,
+				record.Info.ScanType,
+				strings.Join(record.Info.Targets, " "),
+				record.HostsUp,
+				record.Hosts,
+				record.OpenPorts,
+			)
+		}
+		_ = w.Flush()
+	},
+}
+
+var dbShowCmd = &cobra.Command{
+	Use:   "show [ID]",
+	Short: "Show the results of a recorded scan",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid scan ID: '%s'\n", args[0])
+			os.Exit(1)
+		}
+
+		db := openStore(store.Open)
+		defer db.Close()
+
+		record, err := db.Scan(id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		hosts, err := db.Hosts(id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Scan %d: furious %s\n", record.ID, strings.Join(record.Info.Arguments, " "))
+
+		writer, err := output.New("text", os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := writer.Begin(record.Info); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, host := range hosts {
+			if hideUnavailableHosts && host.State != scan.HostUp {
+				continue
+			}
+			if err := writer.WriteResult(host.Result()); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		// a scan which never finished has no end time to report
+		if record.Info.End != nil {
+			if err := writer.End(record.Info); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+var dbQueryCmd = &cobra.Command{
+	Use:   "query [SQL]",
+	Short: "Run an SQL query against the recorded scan history",
+	Long: `Run an SQL query against the recorded scan history, e.g. to find when port 3389 was first seen open on a host:
+
+furious db query --db scans.db "SELECT MIN(s.started_at) FROM ports p JOIN hosts h ON h.id = p.host_id JOIN scans s ON s.id = h.scan_id WHERE h.address = '10.0.0.5' AND p.port = 3389 AND p.state = 'open'"`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// queries are given by the user, so the database is opened read only to keep them from changing it
+		db := openStore(store.OpenReadOnly)
+		defer db.Close()
+
+		columns, rows, err := db.Query(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		_ = w.Flush()
+	},
+}
+
+func openStore(open func(path string) (*store.Store, error)) *store.Store {
+	if dbFile == "" {
+		fmt.Fprintln(os.Stderr, "Please specify a database with --db")
+		os.Exit(1)
+	}
+	if _, err := os.Stat(dbFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	db, err := open(dbFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return db
+}
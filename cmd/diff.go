@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/liamg/furious/diff"
+	"github.com/liamg/furious/scan"
+	"github.com/liamg/furious/store"
+	"github.com/spf13/cobra"
+)
+
+var diffJSON bool
+
+func init() {
+	diffCmd.Flags().BoolVarP(&diffJSON, "json", "", diffJSON, "Output changes as JSON")
+	rootCmd.AddCommand(diffCmd)
+}
+
+// scanResults is a scan loaded from a results file or the result store
+type scanResults struct {
+	Info  scan.ScanInfo
+	Hosts []scan.HostReport
+	Name  string
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [OLD NEW]",
+	Short: "Report changes between two scans",
+	Long: `Report hosts which came up or went down, ports which were opened or closed, and service, version and MAC address changes between two scans. Hosts which are only in one of the scans are listed separately, since they may not have been scanned. If a scan was cancelled, hosts missing from it are left out.
+
+Scans can be given as files written with -o json or -o ndjson:
+
+  furious diff old.json new.json
+
+Or as IDs of scans recorded with --db. If only one ID is given, it is compared with the scan recorded before it. If no scans are given, the two most recent scans are compared:
+
+  furious diff --db scans.db
+  furious diff --db scans.db 7
+  furious diff --db scans.db 3 7`,
+	Args: cobra.MaximumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		old, new, err := loadScansToCompare(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		changes := diff.Compare(old.Hosts, new.Hosts)
+
+		// a cancelled scan is missing every host it didn't reach, which says nothing about those hosts
+		for _, results := range []*scanResults{old, new} {
+			if results.Info.Incomplete {
+				fmt.Fprintf(os.Stderr, "Warning: %s was cancelled before it was complete. Hosts missing from it are not compared.\n", results.Name)
+			}
+		}
+		changes = diff.WithoutMissing(changes, old.Info.Incomplete, new.Info.Incomplete)
+
+		if diffJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(struct {
+				Old     scan.ScanInfo `json:"old"`
+				New     scan.ScanInfo `json:"new"`
+				Changes []diff.Change `json:"changes"`
+			}{
+				Old:     old.Info,
+				New:     new.Info,
+				Changes: changes,
+			}); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		fmt.Printf("Comparing %s (%s) with %s (%s)\n\n", old.Name, old.Info.Start.Local().String(), new.Name, new.Info.Start.Local().String())
+
+		if len(changes) == 0 {
+			fmt.Println("No changes.")
+			return
+		}
+
+		host := ""
+		for _, change := range changes {
+			if change.Host != host {
+				if host != "" {
+					fmt.Println("")
+				}
+				host = change.Host
+				fmt.Printf("Changes for host %s\n", host)
+			}
+			fmt.Printf("\t%s\n", change.String())
+		}
+		fmt.Println("")
+	},
+}
+
+func loadScansToCompare(args []string) (*scanResults, *scanResults, error) {
+
+	if dbFile == "" {
+		if len(args) != 2 {
+			return nil, nil, fmt.Errorf("Please specify two results files to compare, or a database with --db")
+		}
+		old, err := loadResultsFile(args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		new, err := loadResultsFile(args[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		return old, new, nil
+	}
+
+	ids := []int64{}
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid scan ID: '%s'", arg)
+		}
+		ids = append(ids, id)
+	}
+
+	db := openStore(store.Open)
+	defer db.Close()
+
+	// scans are listed most recent first
+	records, err := db.Scans()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ids) < 2 {
+		newIndex := 0
+		if len(ids) == 1 {
+			newIndex = -1
+			for i, record := range records {
+				if record.ID == ids[0] {
+					newIndex = i
+				}
+			}
+			if newIndex < 0 {
+				return nil, nil, fmt.Errorf("scan %d not found", ids[0])
+			}
+		}
+		if newIndex+1 >= len(records) {
+			return nil, nil, fmt.Errorf("There is no earlier scan to compare with")
+		}
+		ids = []int64{records[newIndex+1].ID, records[newIndex].ID}
+	}
+
+	loaded := []*scanResults{}
+	for _, id := range ids {
+		record, err := db.Scan(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		hosts, err := db.Hosts(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		loaded = append(loaded, &scanResults{Info: record.Info, Hosts: hosts, Name: fmt.Sprintf("scan %d", id)})
+	}
+	return loaded[0], loaded[1], nil
+}
+
+// loadResultsFile reads results written by the json or ndjson output formats
+func loadResultsFile(path string) (*scanResults, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &scanResults{Name: path}
+
+	document := struct {
+		Scan  *scan.ScanInfo    `json:"scan"`
+		Hosts []scan.HostReport `json:"hosts"`
+	}{}
+	if err := json.Unmarshal(data, &document); err == nil && document.Scan != nil {
+		results.Info = *document.Scan
+		results.Hosts = document.Hosts
+		return results, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		object := struct {
+			Type string `json:"type"`
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+			return nil, fmt.Errorf("%s is not a JSON or NDJSON results file: line %d: %s", path, line, err)
+		}
+		switch object.Type {
+		case "scan":
+			if err := json.Unmarshal(scanner.Bytes(), &results.Info); err != nil {
+				return nil, fmt.Errorf("%s: line %d: %s", path, line, err)
+			}
+		case "host":
+			host := scan.HostReport{}
+			if err := json.Unmarshal(scanner.Bytes(), &host); err != nil {
+				return nil, fmt.Errorf("%s: line %d: %s", path, line, err)
+			}
+			results.Hosts = append(results.Hosts, host)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/liamg/furious/output"
+)
+
+// createOutputWriter creates a writer for each destination, given in the form FORMAT or FORMAT:FILE. Destinations
+// without a file are written to stdout. If no destination writes to stdout, human readable text is written there.
+// Any extra writers are also written to. The returned function closes any files which were opened.
+func createOutputWriter(stdout io.Writer, destinations []string, extra ...output.Writer) (output.Writer, func(), error) {
+
+	writers := append([]output.Writer{}, extra...)
+	files := []*os.File{}
+	closeFiles := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+
+	toStdout := false
+	for _, destination := range destinations {
+		format, path := destination, ""
+		if index := strings.Index(destination, ":"); index > -1 {
+			format, path = destination[:index], destination[index+1:]
+		}
+
+		w := stdout
+		if path == "" || path == "-" {
+			toStdout = true
+		} else {
+			f, err := os.Create(path)
+			if err != nil {
+				closeFiles()
+				return nil, nil, err
+			}
+			files = append(files, f)
+			w = f
+		}
+
+		writer, err := output.New(format, w)
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+		writers = append(writers, writer)
+	}
+
+	if !toStdout {
+		writer, err := output.New("text", stdout)
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+		writers = append([]output.Writer{writer}, writers...)
+	}
+
+	return output.Multi(writers...), closeFiles, nil
+}
@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/liamg/furious/scan"
+)
+
+// progress tracks how far through a scan we are, and periodically writes a summary line. On a terminal the line is
+// redrawn in place, otherwise a new line is written each time.
+type progress struct {
+	mutex       sync.Mutex
+	w           io.Writer
+	interactive bool
+	interval    time.Duration
+	// hostReplies counts host-up events as replies, for scan types which don't probe individual ports
+	hostReplies bool
+	total       uint64
+	hostsDone   uint64
+	probes      uint64
+	replies     uint64
+	openPorts   uint64
+	start       time.Time
+	last        time.Time
+	lastProbes  uint64
+	lastReplies uint64
+	probeRate   float64
+	drawn       bool
+	stop        chan struct{}
+	stopped     chan struct{}
+}
+
+// progressInterval is how often the progress line is redrawn on a terminal, unless --stats-every is given
+const progressInterval = time.Second
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func newProgress(w io.Writer, interactive bool, interval time.Duration, total uint64, hostReplies bool) *progress {
+	if interval <= 0 {
+		interval = progressInterval
+	}
+	return &progress{
+		w:           w,
+		interactive: interactive,
+		interval:    interval,
+		hostReplies: hostReplies,
+		total:       total,
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// Begin records the start of the scan, which elapsed time and rates are measured from.
+func (p *progress) Begin() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.start = time.Now()
+	p.last = p.start
+}
+
+// Start begins writing progress every interval until Stop is called.
+func (p *progress) Start() {
+	p.Begin()
+
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		defer close(p.stopped)
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.mutex.Lock()
+				p.draw(time.Now())
+				p.mutex.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop stops writing progress, and removes the progress line from the terminal.
+func (p *progress) Stop() {
+	close(p.stop)
+	<-p.stopped
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.clear()
+}
+
+// Event updates the probe, reply and open port counts from a scanner event.
+func (p *progress) Event(event scan.Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	switch event.Type {
+	case scan.EventProbeSent, scan.EventRetransmit:
+		p.probes++
+	case scan.EventPortState:
+		p.replies++
+		if event.State == scan.PortOpen {
+			p.openPorts++
+		}
+	case scan.EventHostUp:
+		if p.hostReplies {
+			p.replies++
+		}
+	}
+}
+
+// HostDone records a completed host.
+func (p *progress) HostDone() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.hostsDone++
+}
+
+// Pause removes the progress line from the terminal so that other output can be written, and holds the progress
+// line back until the returned function is called.
+func (p *progress) Pause() func() {
+	p.mutex.Lock()
+	p.clear()
+	return func() {
+		if p.interactive && p.drawn {
+			p.draw(time.Now())
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// Line describes the progress of the scan at now.
+func (p *progress) Line(now time.Time) string {
+	elapsed := now.Sub(p.start)
+	since := now.Sub(p.last).Seconds()
+	if since <= 0 {
+		since = 1
+	}
+
+	done := fmt.Sprintf("%d hosts done", p.hostsDone)
+	eta := "unknown"
+	if p.total > 0 {
+		done = fmt.Sprintf("%d/%d hosts done (%.1f%%)", p.hostsDone, p.total, float64(p.hostsDone)*100/float64(p.total))
+		if p.hostsDone > 0 && p.hostsDone <= p.total {
+			remaining := time.Duration(float64(elapsed) / float64(p.hostsDone) * float64(p.total-p.hostsDone))
+			eta = remaining.Round(time.Second).String()
+		}
+	}
+
+	return fmt.Sprintf(
+		"Stats: %s elapsed; %s; %.0f probes/s; %.0f replies/s; %d open ports; ETA %s",
+		elapsed.Round(time.Second),
+		done,
+		float64(p.probes-p.lastProbes)/since,
+		float64(p.replies-p.lastReplies)/since,
+		p.openPorts,
+		eta,
+	)
+}
+
+// Sample describes the progress of the scan at now, and resets the rate counters so the next sample reports rates
+// since this one.
+func (p *progress) Sample(now time.Time) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.sample(now)
+}
+
+// ProbeRate returns the probes sent per second at the last sample.
+func (p *progress) ProbeRate() float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.probeRate
+}
+
+// sample is Sample with the mutex held.
+func (p *progress) sample(now time.Time) string {
+	line := p.Line(now)
+	if since := now.Sub(p.last).Seconds(); since > 0 {
+		p.probeRate = float64(p.probes-p.lastProbes) / since
+	}
+	p.last = now
+	p.lastProbes = p.probes
+	p.lastReplies = p.replies
+	return line
+}
+
+// draw writes the progress line. The mutex must be held.
+func (p *progress) draw(now time.Time) {
+	line := p.sample(now)
+
+	if p.interactive {
+		fmt.Fprintf(p.w, "\r\033[K%s", line)
+		p.drawn = true
+		return
+	}
+	fmt.Fprintln(p.w, line)
+}
+
+// clear removes the progress line from the terminal. The mutex must be held.
+func (p *progress) clear() {
+	if p.interactive && p.drawn {
+		fmt.Fprint(p.w, "\r\033[K")
+	}
+}
@@ -1,15 +1,21 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/liamg/furious/output"
 	"github.com/liamg/furious/scan"
+	"github.com/liamg/furious/store"
 	"github.com/liamg/furious/version"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -18,43 +24,107 @@ import (
 var debug bool
 var timeoutMS int = 2000
 var parallelism int = 500
+var retries int
+var timingLevel = 3
+var scanDelay time.Duration
+var hostGroupSize int
+var rate int
+var networkInterface string
+var sourcePort int
+var excluded []string
+var configFile string
+var profileName string
 var portSelection string
 var scanType = "stealth"
 var hideUnavailableHosts bool
 var versionRequested bool
+var osDetection bool
+var serviceDetection bool
+var serviceModules []string
+var snmpEnabled bool
+var snmpCommunities = scan.DefaultSNMPCommunities
+var snmpEngineDiscovery bool
+var outputDestinations []string
+var xmlOutputFile string
+var grepableOutputFile string
+var csvOutputFile string
+var htmlOutputFile string
+var dbFile string
+var statsEvery time.Duration
+var noProgress bool
+var tuiEnabled bool
+var controlSocket string
+
+// exitPartialFailure is the exit code used when the scan completed, but some hosts could not be scanned
+const exitPartialFailure = 2
+
+// exitCancelled is the exit code used when the scan was cancelled, as is conventional for processes ended by SIGINT
+const exitCancelled = 130
+
+// exitCode is set by commands which complete without a fatal error, but still need a non-zero exit code
+var exitCode int
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&hideUnavailableHosts, "up-only", "u", hideUnavailableHosts, "Omit output for hosts which are not up")
 	rootCmd.PersistentFlags().BoolVarP(&versionRequested, "version", "", versionRequested, "Output version information and exit")
-	rootCmd.PersistentFlags().StringVarP(&scanType, "scan-type", "s", scanType, "Scan type. Must be one of stealth, connect")
+	rootCmd.PersistentFlags().StringVarP(&scanType, "scan-type", "s", scanType, "Scan type. Must be one of stealth, connect, device, upnp")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "verbose", "v", debug, "Enable verbose logging")
 	rootCmd.PersistentFlags().IntVarP(&timeoutMS, "timeout-ms", "t", timeoutMS, "Scan timeout in MS")
 	rootCmd.PersistentFlags().IntVarP(&parallelism, "workers", "w", parallelism, "Parallel routines to scan on")
+	rootCmd.PersistentFlags().IntVarP(&retries, "retries", "", retries, "Number of times to resend a probe which gets no reply")
+	rootCmd.PersistentFlags().IntVarP(&timingLevel, "timing", "T", timingLevel, "Timing template from 0 (paranoid) to 5 (insane), setting workers, timeouts, retries, scan delay and host group size together. Flags for each of these take precedence")
+	rootCmd.PersistentFlags().DurationVarP(&scanDelay, "scan-delay", "", scanDelay, "Minimum time between probes e.g. 500ms")
+	rootCmd.PersistentFlags().IntVarP(&hostGroupSize, "host-group-size", "", hostGroupSize, "Number of hosts to scan at once (not used by upnp scans). Defaults to 256")
+	rootCmd.PersistentFlags().IntVarP(&rate, "rate", "", rate, "Maximum number of probes to send per second. Unlimited by default")
+	rootCmd.PersistentFlags().StringVarP(&networkInterface, "interface", "e", networkInterface, "Network interface to send probes from (stealth scans only). Chosen from the routing table by default")
+	rootCmd.PersistentFlags().IntVarP(&sourcePort, "source-port", "g", sourcePort, "TCP source port for probes (stealth scans only). A free port is chosen for each host by default")
+	rootCmd.PersistentFlags().BoolVarP(&osDetection, "os-detect", "O", osDetection, "Enable active OS detection (stealth scans only)")
+	rootCmd.PersistentFlags().BoolVarP(&serviceDetection, "service-detect", "V", serviceDetection, "Run service modules against open ports to identify services")
+	rootCmd.PersistentFlags().StringSliceVarP(&serviceModules, "service-modules", "", serviceModules, "Service modules to run when service detection is enabled. Defaults to all of: "+strings.Join(scan.DefaultServiceModuleNames(), ", ")+". Intrusive modules, which only run when named: mysql-empty-password")
+	rootCmd.PersistentFlags().BoolVarP(&snmpEnabled, "snmp", "", snmpEnabled, "Query SNMP agents (UDP/161) for system information, checking which communities are accepted")
+	rootCmd.PersistentFlags().StringSliceVarP(&snmpCommunities, "snmp-communities", "", snmpCommunities, "SNMP community strings to try")
+	rootCmd.PersistentFlags().BoolVarP(&snmpEngineDiscovery, "snmp-v3", "", snmpEngineDiscovery, "Also perform SNMPv3 engine discovery")
+	rootCmd.PersistentFlags().StringArrayVarP(&outputDestinations, "output", "o", outputDestinations, "Output format, optionally followed by a file to write to e.g. json:results.json. Can be specified multiple times. Format must be one of "+strings.Join(output.Formats(), ", "))
+	rootCmd.PersistentFlags().StringVarP(&xmlOutputFile, "xml", "", xmlOutputFile, "Also write results to the given file in nmap's XML format. Can also be specified as -oX")
+	rootCmd.PersistentFlags().StringVarP(&grepableOutputFile, "grepable", "", grepableOutputFile, "Also write results to the given file in nmap's grepable format. Can also be specified as -oG")
+	rootCmd.PersistentFlags().StringVarP(&csvOutputFile, "csv", "", csvOutputFile, "Also write results to the given file as CSV, with one row per host/port")
+	rootCmd.PersistentFlags().StringVarP(&htmlOutputFile, "html", "", htmlOutputFile, "Also write a self-contained HTML report to the given file. Can also be specified as -oH")
+	rootCmd.PersistentFlags().StringVarP(&dbFile, "db", "", dbFile, "SQLite database to record scan history in (created if it does not exist)")
+	rootCmd.PersistentFlags().DurationVarP(&statsEvery, "stats-every", "", statsEvery, "Write scan progress to stderr at this interval e.g. 30s. Progress is shown every second by default when stderr is a terminal")
+	rootCmd.PersistentFlags().BoolVarP(&tuiEnabled, "tui", "", tuiEnabled, "Show a full-screen live view of the scan, which can be paused, throttled and exported while it runs")
+	rootCmd.PersistentFlags().StringVarP(&controlSocket, "control-socket", "", controlSocket, "Listen on a UNIX socket at this path for commands to pause, resume and throttle the scan while it runs")
+	rootCmd.PersistentFlags().BoolVarP(&noProgress, "no-progress", "", noProgress, "Don't write scan progress to stderr")
 	rootCmd.PersistentFlags().StringVarP(&portSelection, "ports", "p", portSelection, "Port to scan. Comma separated, can sue hyphens e.g. 22,80,443,8080-8090")
+	rootCmd.PersistentFlags().StringSliceVarP(&excluded, "exclude", "", excluded, "IPs, CIDRs or hostnames to leave out of the scan. Comma separated")
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "", configFile, "YAML or TOML (.toml) file to read settings from. Settings are named after flags, and flags given on the command line take precedence")
+	rootCmd.PersistentFlags().StringVarP(&profileName, "profile", "", profileName, "Named profile of settings to use. Must be one of "+strings.Join(profileNames(&config{}), ", ")+", or a profile defined in the config file")
 }
 
-func createScanner(ti *scan.TargetIterator, scanTypeStr string, timeout time.Duration, routines int) (scan.Scanner, error) {
-	switch strings.ToLower(scanTypeStr) {
+func createScanner(options scan.Options) (scan.Scanner, error) {
+	switch strings.ToLower(options.Type) {
 	case "stealth", "syn", "fast":
 		if os.Geteuid() > 0 {
 			return nil, fmt.Errorf("Access Denied: You must be a priviliged user to run this type of scan.")
 		}
-		return scan.NewSynScanner(ti, timeout, routines), nil
-	case "connect":
-		return scan.NewConnectScanner(ti, timeout, routines), nil
-	case "device":
-		return scan.NewDeviceScanner(ti, timeout), nil
+	case "connect", "device", "upnp":
+	default:
+		return nil, fmt.Errorf("Unknown scan type '%s'", options.Type)
 	}
-
-	return nil, fmt.Errorf("Unknown scan type '%s'", scanTypeStr)
+	return scan.New(options)
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "furious",
 	Short: "Furious is a IP/port scanner",
 	Long:  `An IP/port scanner for identifying hosts/services remotely.`,
+	Args:  cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 
+		if err := applyConfig(cmd.Flags(), configFile, profileName); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
 		if versionRequested {
 			v := version.Version
 			if v == "" {
@@ -69,72 +139,341 @@ var rootCmd = &cobra.Command{
 		}
 
 		if len(args) == 0 {
-			fmt.Println("Please specify a target")
+			fmt.Fprintln(os.Stderr, "Please specify a target")
 			os.Exit(1)
 		}
 
 		ports, err := getPorts(portSelection)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
-
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		go func() {
-			<-c
-			fmt.Println("Scan cancelled. Requesting stop...")
-			cancel()
-		}()
+		exclusions, err := scan.NewExclusions(excluded...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 
-		startTime := time.Now()
-		fmt.Printf("\nStarting scan at %s\n\n", startTime.String())
+		timing, err := scan.TimingTemplate(timingLevel)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 
-		for _, target := range args {
+		var modules []scan.ServiceModule
+		if serviceDetection {
+			modules, err = scan.GetServiceModules(serviceModules...)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
 
-			targetIterator := scan.NewTargetIterator(target)
+		destinations := outputDestinations
+		for _, file := range []struct {
+			format string
+			path   string
+		}{
+			{"xml", xmlOutputFile},
+			{"grepable", grepableOutputFile},
+			{"csv", csvOutputFile},
+			{"html", htmlOutputFile},
+		} {
+			if file.path != "" {
+				destinations = append(destinations, file.format+":"+file.path)
+			}
+		}
 
-			// creating scanner
-			scanner, err := createScanner(targetIterator, scanType, time.Millisecond*time.Duration(timeoutMS), parallelism)
+		var extraWriters []output.Writer
+		if dbFile != "" {
+			db, err := store.Open(dbFile)
 			if err != nil {
-				fmt.Println(err)
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer db.Close()
+			extraWriters = append(extraWriters, store.NewWriter(db))
+		}
+
+		// output which would normally go to stdout is held back until the TUI exits
+		var stdout io.Writer = os.Stdout
+		var heldOutput *bytes.Buffer
+		if tuiEnabled {
+			if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+				fmt.Fprintln(os.Stderr, "--tui requires an interactive terminal")
 				os.Exit(1)
 			}
+			heldOutput = &bytes.Buffer{}
+			stdout = heldOutput
+		}
+
+		writer, closeOutput, err := createOutputWriter(stdout, destinations, extraWriters...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer closeOutput()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		info := scan.ScanInfo{
+			Version:   version.Version,
+			Arguments: os.Args[1:],
+			ScanType:  strings.ToLower(scanType),
+			Targets:   args,
+			Ports:     ports,
+			Start:     time.Now(),
+		}
+		if err := writer.Begin(info); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		control := scan.NewControl()
+		hostReplies := info.ScanType == "device" || info.ScanType == "upnp"
 
-			log.Debugf("Starting scanner...")
-			if err := scanner.Start(); err != nil {
-				fmt.Println(err)
+		var ui *tui
+		var status *progress
+		if tuiEnabled {
+			status = newProgress(ioutil.Discard, false, 0, scan.NewTargets(args...).Size(), hostReplies)
+			ui = newTUI(info, control, status, cancel)
+			if err := ui.Start(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
+		} else if interactive := isTerminal(os.Stderr); !noProgress && (interactive || statsEvery > 0) {
+			status = newProgress(os.Stderr, interactive, statsEvery, scan.NewTargets(args...).Size(), hostReplies)
+			status.Start()
+		}
 
-			log.Debugf("Scanning target %s...", target)
+		// the scan can be paused, resumed and throttled with signals or the control socket while it runs
+		notice := func(message string) {
+			if ui != nil {
+				ui.Notice(message)
+				return
+			}
+			if status != nil {
+				defer status.Pause()()
+			}
+			fmt.Fprintln(os.Stderr, message)
+		}
+		watchControlSignals(control, notice)
 
-			results, err := scanner.Scan(ctx, ports)
+		// the first interrupt stops new probes and outputs what has been found so far, the second quits immediately
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		go func() {
+			<-interrupts
+			notice("Scan cancelled. Finishing in-flight probes, press Ctrl+C again to quit immediately.")
+			cancel()
+			<-interrupts
+			if ui != nil {
+				ui.Stop()
+			}
+			fmt.Fprintln(os.Stderr, "\nQuitting.")
+			os.Exit(exitCancelled)
+		}()
+		if controlSocket != "" {
+			stopControl, err := serveControl(control, controlSocket, notice)
 			if err != nil {
-				fmt.Println(err)
+				if ui != nil {
+					ui.Stop()
+				}
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
+			defer stopControl()
+		}
 
-			for _, result := range results {
-				if !hideUnavailableHosts || result.IsHostUp() {
-					scanner.OutputResult(result)
+		options := timing.Apply(scan.Options{
+			Type:        scanType,
+			Rate:        rate,
+			Interface:   networkInterface,
+			SourcePort:  sourcePort,
+			OSDetection: osDetection,
+			Control:     control,
+		})
+
+		// flags given explicitly (or set by the config file) override the timing template
+		flags := cmd.Flags()
+		if flags.Changed("timeout-ms") {
+			timeout := time.Millisecond * time.Duration(timeoutMS)
+			options.Timeout, options.MinRTTTimeout, options.MaxRTTTimeout = timeout, timeout, timeout
+		}
+		if flags.Changed("workers") {
+			options.Parallelism = parallelism
+		}
+		if flags.Changed("retries") {
+			options.Retries = retries
+		}
+		if flags.Changed("scan-delay") {
+			options.ScanDelay = scanDelay
+		}
+		if flags.Changed("host-group-size") {
+			options.HostGroupSize = hostGroupSize
+		}
+		// service detection and SNMP allow as long for each probe as the longest probe timeout
+		serviceTimeout := options.MaxRTTTimeout
+		log.Debugf("Timing: %s, %d workers, timeout %s (%s-%s), %d retries, scan delay %s, host group size %d", timing.Name, options.Parallelism, options.Timeout, options.MinRTTTimeout, options.MaxRTTTimeout, options.Retries, options.ScanDelay, options.HostGroupSize)
+		if debug || status != nil {
+			options.EventSink = func(event scan.Event) {
+				if debug {
+					log.Debugf("Event: %s", event)
+				}
+				if status != nil {
+					status.Event(event)
 				}
 			}
+		}
+
+		log.Debugf("Starting scanner...")
+		scanner, err := createScanner(options)
+		if err != nil {
+			if ui != nil {
+				ui.Stop()
+			}
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer scanner.Stop()
 
+		writeResult := func(result scan.Result) {
+			if status != nil {
+				status.HostDone()
+			}
+			if !hideUnavailableHosts || result.IsHostUp() {
+				if ui != nil {
+					ui.AddResult(result)
+				} else if status != nil {
+					resume := status.Pause()
+					defer resume()
+				}
+				if err := writer.WriteResult(result); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
 		}
 
-		fmt.Printf("Scan complete in %s.\n", time.Since(startTime).String())
+		// service detection and SNMP run for up to a host group of hosts at once, so slow or silent hosts don't hold
+		// up the rest. Results are still written one at a time.
+		results := make(chan scan.Result)
+		written := make(chan struct{})
+		go func() {
+			for result := range results {
+				writeResult(result)
+			}
+			close(written)
+		}()
+		detectionSlots := make(chan struct{}, options.HostGroupSize)
+		detecting := &sync.WaitGroup{}
+
+		for _, target := range args {
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			log.Debugf("Scanning target %s...", target)
+
+			// results are written as each host completes, rather than held until the whole target is scanned
+			if err := scanner.Scan(ctx, exclusions.Filter(scan.NewTargetIterator(target)), ports, func(result scan.Result) {
+				if result.Error != nil {
+					log.Debugf("Host %s could not be scanned: %s", result.Host, result.Error)
+					exitCode = exitPartialFailure
+				}
+				if !serviceDetection && !snmpEnabled {
+					results <- result
+					return
+				}
+				detectionSlots <- struct{}{}
+				detecting.Add(1)
+				go func(result scan.Result) {
+					defer detecting.Done()
+					if serviceDetection {
+						scan.IdentifyServices(ctx, &result, modules, serviceTimeout)
+					}
+					if snmpEnabled {
+						scan.CheckSNMP(ctx, &result, snmpCommunities, snmpEngineDiscovery, serviceTimeout)
+					}
+					if ctx.Err() != nil {
+						// service detection was cut short
+						result.Incomplete = true
+					}
+					<-detectionSlots
+					results <- result
+				}(result)
+			}); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+		}
+
+		detecting.Wait()
+		close(results)
+		<-written
+
+		end := time.Now()
+		info.End = &end
+		if ctx.Err() != nil {
+			info.Incomplete = true
+			exitCode = exitCancelled
+		}
+
+		if ui != nil {
+			ui.Finish(info.Incomplete)
+			<-ui.Closed()
+			ui.Stop()
+		} else if status != nil {
+			status.Stop()
+		}
+
+		if err := writer.End(info); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if heldOutput != nil {
+			_, _ = heldOutput.WriteTo(os.Stdout)
+		}
 
 	},
 }
 
+// nmapOutputFlags maps nmap style output flags, which cannot be parsed as shorthand flags, to their long equivalents
+var nmapOutputFlags = map[string]string{
+	"-oX": "--xml",
+	"-oG": "--grepable",
+	"-oH": "--html",
+}
+
+// normaliseArgs rewrites nmap style output flags (e.g. -oX FILE or -oXFILE) to their long equivalents
+func normaliseArgs(args []string) []string {
+	normalised := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) >= 3 {
+			if long, ok := nmapOutputFlags[arg[:3]]; ok {
+				if len(arg) == 3 {
+					arg = long
+				} else {
+					arg = long + "=" + strings.TrimPrefix(arg[3:], "=")
+				}
+			}
+		}
+		normalised = append(normalised, arg)
+	}
+	return normalised
+}
+
 func Execute() {
+	rootCmd.SetArgs(normaliseArgs(os.Args[1:]))
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	os.Exit(exitCode)
 }
 
 func getPorts(selection string) ([]int, error) {
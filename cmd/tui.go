@@ -0,0 +1,443 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/liamg/furious/output"
+	"github.com/liamg/furious/scan"
+)
+
+// tuiRefreshInterval is how often the TUI is redrawn while nothing else is happening
+const tuiRefreshInterval = 500 * time.Millisecond
+
+// Keys read by the TUI. Arrow keys are translated to the equivalent vi keys.
+const (
+	keyCtrlC     = 3
+	keyEnter     = '\r'
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// tui is a full-screen live view of a scan. Hosts are listed as results arrive, and can be opened to show their
+// ports and services. The scan can be paused, resumed and throttled, and the results so far exported.
+type tui struct {
+	mutex    sync.Mutex
+	in       *os.File
+	out      *os.File
+	info     scan.ScanInfo
+	control  *scan.Control
+	status   *progress
+	quit     func()
+	restore  func()
+	results  []scan.Result
+	selected int
+	offset   int
+	detail   bool
+	finished bool
+	quitting bool
+	message  string
+	// stats is the progress line, which is sampled at a regular interval so that rates are stable
+	stats    string
+	closed   chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newTUI(info scan.ScanInfo, control *scan.Control, status *progress, quit func()) *tui {
+	return &tui{
+		in:      os.Stdin,
+		out:     os.Stdout,
+		info:    info,
+		control: control,
+		status:  status,
+		quit:    quit,
+		closed:  make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start switches the terminal to a full-screen view, and starts handling key presses.
+func (t *tui) Start() error {
+	restore, err := makeRaw(int(t.in.Fd()))
+	if err != nil {
+		return fmt.Errorf("--tui requires an interactive terminal: %s", err)
+	}
+	t.restore = restore
+
+	// switch to the alternate screen and hide the cursor
+	fmt.Fprint(t.out, "\033[?1049h\033[?25l")
+
+	t.status.Begin()
+	t.stats = t.status.Sample(time.Now())
+	t.redraw()
+
+	go t.readKeys()
+	go func() {
+		ticker := time.NewTicker(tuiRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.mutex.Lock()
+				if !t.finished {
+					t.stats = t.status.Sample(time.Now())
+				}
+				t.mutex.Unlock()
+				t.redraw()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop restores the terminal. It is safe to call more than once.
+func (t *tui) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		fmt.Fprint(t.out, "\033[?25h\033[?1049l")
+		if t.restore != nil {
+			t.restore()
+		}
+	})
+}
+
+// Closed is closed when the user asks to quit.
+func (t *tui) Closed() <-chan struct{} {
+	return t.closed
+}
+
+// AddResult adds a completed host to the view.
+func (t *tui) AddResult(result scan.Result) {
+	t.mutex.Lock()
+	t.results = append(t.results, result)
+	t.mutex.Unlock()
+	t.redraw()
+}
+
+// Notice shows a message in the footer.
+func (t *tui) Notice(message string) {
+	t.mutex.Lock()
+	t.message = message
+	t.mutex.Unlock()
+	t.redraw()
+}
+
+// Finish marks the scan as complete, or as cancelled if incomplete is set. The view stays open until the user quits.
+func (t *tui) Finish(incomplete bool) {
+	t.mutex.Lock()
+	t.finished = true
+	t.message = "Scan complete. Press q to exit."
+	if incomplete {
+		t.message = "Scan cancelled, results are incomplete. Press q to exit."
+	}
+	t.mutex.Unlock()
+	t.redraw()
+}
+
+func (t *tui) readKeys() {
+	buffer := make([]byte, 16)
+	for {
+		n, err := t.in.Read(buffer)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buffer[:n]) {
+			if !t.handleKey(key) {
+				return
+			}
+		}
+	}
+}
+
+// parseKeys splits input into key presses, translating arrow key escape sequences to vi keys
+func parseKeys(input []byte) []byte {
+	keys := []byte{}
+	for i := 0; i < len(input); i++ {
+		if input[i] == keyEscape && i+2 < len(input) && input[i+1] == '[' {
+			switch input[i+2] {
+			case 'A':
+				keys = append(keys, 'k')
+			case 'B':
+				keys = append(keys, 'j')
+			case 'C':
+				keys = append(keys, 'l')
+			case 'D':
+				keys = append(keys, 'h')
+			}
+			i += 2
+			continue
+		}
+		keys = append(keys, input[i])
+	}
+	return keys
+}
+
+// handleKey acts on a key press, returning false once the user has quit.
+func (t *tui) handleKey(key byte) bool {
+	t.mutex.Lock()
+
+	switch key {
+	case 'q', keyCtrlC:
+		if t.finished {
+			quitting := t.quitting
+			t.mutex.Unlock()
+			if !quitting {
+				t.quit()
+				close(t.closed)
+			}
+			return false
+		}
+		if t.quitting {
+			// the scan is still finishing in-flight probes, but the user doesn't want to wait
+			t.mutex.Unlock()
+			t.Stop()
+			os.Exit(exitCancelled)
+		}
+		// keep reading keys, so that a second press can quit without waiting for the scan to stop
+		t.quitting = true
+		t.message = "Stopping scan... press q again to quit immediately"
+		t.mutex.Unlock()
+		t.redraw()
+		t.quit()
+		close(t.closed)
+		return true
+	case 'j':
+		if t.selected < len(t.results)-1 {
+			t.selected++
+		}
+	case 'k':
+		if t.selected > 0 {
+			t.selected--
+		}
+	case 'l', keyEnter:
+		if len(t.results) > 0 {
+			t.detail = true
+		}
+	case 'h', keyEscape, keyBackspace:
+		t.detail = false
+	case 'p', ' ':
+		if t.finished || t.quitting {
+			break
+		}
+		if t.control.Paused() {
+			t.control.Resume()
+			t.message = "Resumed."
+		} else {
+			t.control.Pause()
+			t.message = "Paused. Press p to resume."
+		}
+	case '-':
+		rate := t.control.Rate()
+		if rate == 0 {
+			rate = int(t.status.ProbeRate())
+		}
+		rate /= 2
+		if rate < 1 {
+			rate = 1
+		}
+		t.control.SetRate(rate)
+		t.message = fmt.Sprintf("Rate limited to %d probes/s.", rate)
+	case '+', '=':
+		if rate := t.control.Rate(); rate > 0 {
+			t.control.SetRate(rate * 2)
+			t.message = fmt.Sprintf("Rate limited to %d probes/s.", rate*2)
+		}
+	case '0':
+		t.control.SetRate(0)
+		t.message = "Rate is no longer limited."
+	case 'e':
+		if path, err := t.export(); err != nil {
+			t.message = fmt.Sprintf("Export failed: %s", err)
+		} else {
+			t.message = fmt.Sprintf("Exported %d hosts to %s.", len(t.results), path)
+		}
+	}
+
+	t.mutex.Unlock()
+	t.redraw()
+	return true
+}
+
+// export writes the results so far to a JSON file in the working directory. The mutex must be held.
+func (t *tui) export() (string, error) {
+	now := time.Now()
+	path := fmt.Sprintf("furious-%s.json", now.Format("20060102-150405"))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	writer, err := output.New("json", f)
+	if err != nil {
+		return "", err
+	}
+	info := t.info
+	if err := writer.Begin(info); err != nil {
+		return "", err
+	}
+	for _, result := range t.results {
+		if err := writer.WriteResult(result); err != nil {
+			return "", err
+		}
+	}
+	info.End = &now
+	return path, writer.End(info)
+}
+
+func (t *tui) redraw() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+
+	width, height := terminalSize(int(t.out.Fd()))
+
+	lines := t.header(width)
+	body := height - len(lines) - 2
+	if t.detail && t.selected < len(t.results) {
+		lines = append(lines, t.hostDetail(t.results[t.selected], body)...)
+	} else {
+		lines = append(lines, t.hostTable(width, body)...)
+	}
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, "", t.footer())
+
+	screen := &strings.Builder{}
+	screen.WriteString("\033[H")
+	for i, line := range lines {
+		if len(line) > width && !strings.HasPrefix(line, "\033[") {
+			line = line[:width]
+		}
+		screen.WriteString(line)
+		screen.WriteString("\033[K")
+		if i < len(lines)-1 {
+			screen.WriteString("\r\n")
+		}
+	}
+	screen.WriteString("\033[J")
+	fmt.Fprint(t.out, screen.String())
+}
+
+func (t *tui) header(width int) []string {
+	state := "SCANNING"
+	switch {
+	case t.finished:
+		state = "COMPLETE"
+	case t.control.Paused():
+		state = "PAUSED"
+	}
+	rate := "unlimited"
+	if limit := t.control.Rate(); limit > 0 {
+		rate = fmt.Sprintf("%d probes/s", limit)
+	}
+
+	title := fmt.Sprintf("furious %s scan of %s", t.info.ScanType, strings.Join(t.info.Targets, " "))
+	right := fmt.Sprintf("[%s] rate: %s", state, rate)
+	if padding := width - len(title) - len(right); padding > 0 {
+		title += strings.Repeat(" ", padding)
+	} else {
+		title += "  "
+	}
+
+	return []string{
+		title + right,
+		t.stats,
+		"",
+	}
+}
+
+func (t *tui) hostTable(width int, height int) []string {
+	if len(t.results) == 0 {
+		return []string{"Waiting for results..."}
+	}
+
+	// keep the selected host on screen
+	rows := height - 1
+	if rows < 1 {
+		rows = 1
+	}
+	if t.selected < t.offset {
+		t.offset = t.selected
+	} else if t.selected >= t.offset+rows {
+		t.offset = t.selected - rows + 1
+	}
+
+	w := &strings.Builder{}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  HOST\tSTATE\tLATENCY\tOPEN\tNAME\tMAC\t")
+	for i := t.offset; i < len(t.results) && i < t.offset+rows; i++ {
+		result := t.results[i]
+		cursor := " "
+		if i == t.selected {
+			cursor = ">"
+		}
+		latency := ""
+		if result.IsHostUp() {
+			latency = result.Latency.Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(
+			tw,
+			"%s %s\t%s\t%s\t%d\t%s\t%s\t\n",
+			cursor,
+			result.Host,
+			result.Status(),
+			latency,
+			len(result.Open),
+			result.Name,
+			result.MAC,
+		)
+	}
+	_ = tw.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(w.String(), "\n"), "\n")
+	for i, line := range lines {
+		if len(line) > width {
+			line = line[:width]
+			lines[i] = line
+		}
+		if i-1 == t.selected-t.offset {
+			// highlight the selected host
+			lines[i] = "\033[7m" + line + "\033[0m"
+		}
+	}
+	return lines
+}
+
+func (t *tui) hostDetail(result scan.Result, height int) []string {
+	if height < 2 {
+		height = 2
+	}
+	lines := strings.Split(strings.TrimRight(output.Text(t.info.ScanType, result), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Replace(line, "\t", "    ", -1)
+	}
+	if len(lines) > height {
+		lines = append(lines[:height-1], "...")
+	}
+	return lines
+}
+
+func (t *tui) footer() string {
+	keys := "up/down select  enter details  esc back  p pause/resume  +/- rate  0 unlimited  e export  q quit"
+	if t.message != "" {
+		return t.message + "  |  " + keys
+	}
+	return keys
+}
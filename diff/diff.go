@@ -0,0 +1,235 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/liamg/furious/scan"
+)
+
+// Change types.
+const (
+	HostUp         = "host-up"
+	HostDown       = "host-down"
+	PortOpened     = "port-opened"
+	PortClosed     = "port-closed"
+	ServiceChanged = "service-changed"
+	VersionChanged = "version-changed"
+	MACChanged     = "mac-changed"
+	// HostOnlyInOld and HostOnlyInNew are hosts which are missing from one of the scans, e.g. because the scans
+	// covered different ranges or one was cancelled, so there is nothing to compare them with.
+	HostOnlyInOld = "host-only-in-old"
+	HostOnlyInNew = "host-only-in-new"
+)
+
+// Change is a single difference between two scans of the same host.
+type Change struct {
+	Host     string `json:"host"`
+	Type     string `json:"type"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+}
+
+// Compare reports the changes between two sets of hosts. Hosts are matched by address. A host which is missing from one
+// set is reported as missing, rather than as down, since it may not have been scanned.
+func Compare(old []scan.HostReport, new []scan.HostReport) []Change {
+
+	oldHosts := indexHosts(old)
+	newHosts := indexHosts(new)
+
+	addresses := []string{}
+	for address := range oldHosts {
+		addresses = append(addresses, address)
+	}
+	for address := range newHosts {
+		if _, ok := oldHosts[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+	sortAddresses(addresses)
+
+	changes := []Change{}
+	for _, address := range addresses {
+		oldHost, inOld := oldHosts[address]
+		newHost, inNew := newHosts[address]
+		switch {
+		case !inOld:
+			changes = append(changes, Change{Host: address, Type: HostOnlyInNew, New: newHost.State})
+		case !inNew:
+			changes = append(changes, Change{Host: address, Type: HostOnlyInOld, Old: oldHost.State})
+		default:
+			changes = append(changes, compareHost(address, oldHost, newHost)...)
+		}
+	}
+	return changes
+}
+
+// WithoutMissing returns the changes without hosts which are missing from the old scan (if old is true) or the new
+// scan (if new is true). A cancelled scan is missing every host it didn't reach, so these are usually left out.
+func WithoutMissing(changes []Change, old bool, new bool) []Change {
+	kept := []Change{}
+	for _, change := range changes {
+		if (old && change.Type == HostOnlyInNew) || (new && change.Type == HostOnlyInOld) {
+			continue
+		}
+		kept = append(kept, change)
+	}
+	return kept
+}
+
+func compareHost(address string, old scan.HostReport, new scan.HostReport) []Change {
+	changes := []Change{}
+
+	oldUp := old.State == scan.HostUp
+	newUp := new.State == scan.HostUp
+	if !oldUp && newUp {
+		changes = append(changes, Change{Host: address, Type: HostUp})
+	} else if oldUp && !newUp {
+		changes = append(changes, Change{Host: address, Type: HostDown})
+	}
+
+	if old.MAC != "" && new.MAC != "" && old.MAC != new.MAC {
+		changes = append(changes, Change{Host: address, Type: MACChanged, Old: old.MAC, New: new.MAC})
+	}
+
+	oldPorts := openPorts(old)
+	newPorts := openPorts(new)
+	oldScanned := scannedPorts(old)
+	newScanned := scannedPorts(new)
+
+	keys := []portKey{}
+	for key := range oldPorts {
+		keys = append(keys, key)
+	}
+	for key := range newPorts {
+		if _, ok := oldPorts[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].port == keys[j].port {
+			return keys[i].protocol < keys[j].protocol
+		}
+		return keys[i].port < keys[j].port
+	})
+
+	for _, key := range keys {
+		oldPort, wasOpen := oldPorts[key]
+		newPort, isOpen := newPorts[key]
+		change := Change{Host: address, Port: key.port, Protocol: key.protocol}
+		switch {
+		case (!wasOpen && old.Incomplete && !oldScanned[key]) || (!isOpen && new.Incomplete && !newScanned[key]):
+			// a host interrupted by cancellation may not have had every port scanned
+			continue
+		case !wasOpen:
+			change.Type = PortOpened
+			change.New = newPort.Service
+			changes = append(changes, change)
+		case !isOpen:
+			change.Type = PortClosed
+			change.Old = oldPort.Service
+			changes = append(changes, change)
+		default:
+			if oldPort.Service != newPort.Service {
+				change.Type = ServiceChanged
+				change.Old = oldPort.Service
+				change.New = newPort.Service
+				changes = append(changes, change)
+			}
+			if oldPort.Version != newPort.Version {
+				change.Type = VersionChanged
+				change.Old = oldPort.Version
+				change.New = newPort.Version
+				changes = append(changes, change)
+			}
+		}
+	}
+
+	return changes
+}
+
+// String describes the change for display.
+func (c Change) String() string {
+	port := fmt.Sprintf("%d/%s", c.Port, c.Protocol)
+	switch c.Type {
+	case HostUp:
+		return "+ host is up"
+	case HostDown:
+		return "- host is down"
+	case HostOnlyInOld:
+		return fmt.Sprintf("? host is only in the old scan (%s)", c.Old)
+	case HostOnlyInNew:
+		return fmt.Sprintf("? host is only in the new scan (%s)", c.New)
+	case MACChanged:
+		return fmt.Sprintf("~ MAC changed: %s -> %s", c.Old, c.New)
+	case PortOpened:
+		return fmt.Sprintf("+ %s opened%s", port, describe(c.New))
+	case PortClosed:
+		return fmt.Sprintf("- %s closed%s", port, describe(c.Old))
+	case ServiceChanged:
+		return fmt.Sprintf("~ %s service changed: %s -> %s", port, orNone(c.Old), orNone(c.New))
+	case VersionChanged:
+		return fmt.Sprintf("~ %s version changed: %s -> %s", port, orNone(c.Old), orNone(c.New))
+	}
+	return c.Type
+}
+
+func describe(service string) string {
+	if service == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", service)
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+type portKey struct {
+	port     int
+	protocol string
+}
+
+func openPorts(host scan.HostReport) map[portKey]scan.PortReport {
+	ports := map[portKey]scan.PortReport{}
+	for _, port := range host.Ports {
+		if port.State == scan.PortOpen.String() {
+			ports[portKey{port: port.Port, protocol: port.Protocol}] = port
+		}
+	}
+	return ports
+}
+
+func scannedPorts(host scan.HostReport) map[portKey]bool {
+	ports := map[portKey]bool{}
+	for _, port := range host.Ports {
+		ports[portKey{port: port.Port, protocol: port.Protocol}] = true
+	}
+	return ports
+}
+
+func indexHosts(hosts []scan.HostReport) map[string]scan.HostReport {
+	index := map[string]scan.HostReport{}
+	for _, host := range hosts {
+		index[host.Host] = host
+	}
+	return index
+}
+
+// sortAddresses sorts IP addresses numerically, falling back to string comparison for anything else
+func sortAddresses(addresses []string) {
+	sort.Slice(addresses, func(i, j int) bool {
+		a, b := net.ParseIP(addresses[i]), net.ParseIP(addresses[j])
+		if a == nil || b == nil {
+			return addresses[i] < addresses[j]
+		}
+		return bytes.Compare(a.To16(), b.To16()) < 0
+	})
+}
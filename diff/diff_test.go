@@ -0,0 +1,98 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/liamg/furious/scan"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	old := []scan.HostReport{
+		{
+			Host:  "10.0.0.10",
+			State: scan.HostUp,
+			MAC:   "00:11:22:33:44:55",
+			Ports: []scan.PortReport{
+				{Port: 22, Protocol: "tcp", State: "open", Service: "ssh", Version: "OpenSSH_7.4"},
+				{Port: 80, Protocol: "tcp", State: "open", Service: "http"},
+				{Port: 443, Protocol: "tcp", State: "closed"},
+			},
+		},
+		{Host: "10.0.0.9", State: scan.HostUp},
+		{Host: "10.0.0.2", State: scan.HostDown},
+	}
+	new := []scan.HostReport{
+		{
+			Host:  "10.0.0.10",
+			State: scan.HostUp,
+			MAC:   "66:77:88:99:aa:bb",
+			Ports: []scan.PortReport{
+				{Port: 22, Protocol: "tcp", State: "open", Service: "ssh", Version: "OpenSSH_8.9p1"},
+				{Port: 80, Protocol: "tcp", State: "closed"},
+				{Port: 443, Protocol: "tcp", State: "open", Service: "https"},
+			},
+		},
+		{Host: "10.0.0.9", State: scan.HostDown},
+		{
+			Host:  "10.0.0.2",
+			State: scan.HostUp,
+			Ports: []scan.PortReport{{Port: 3389, Protocol: "tcp", State: "open", Service: "ms-wbt-server"}},
+		},
+	}
+
+	assert.Equal(t, []Change{
+		{Host: "10.0.0.2", Type: HostUp},
+		{Host: "10.0.0.2", Type: PortOpened, Port: 3389, Protocol: "tcp", New: "ms-wbt-server"},
+		{Host: "10.0.0.9", Type: HostDown},
+		{Host: "10.0.0.10", Type: MACChanged, Old: "00:11:22:33:44:55", New: "66:77:88:99:aa:bb"},
+		{Host: "10.0.0.10", Type: VersionChanged, Port: 22, Protocol: "tcp", Old: "OpenSSH_7.4", New: "OpenSSH_8.9p1"},
+		{Host: "10.0.0.10", Type: PortClosed, Port: 80, Protocol: "tcp", Old: "http"},
+		{Host: "10.0.0.10", Type: PortOpened, Port: 443, Protocol: "tcp", New: "https"},
+	}, Compare(old, new))
+}
+
+func TestCompareMissingHosts(t *testing.T) {
+	old := []scan.HostReport{
+		{Host: "10.0.0.1", State: scan.HostUp, Ports: []scan.PortReport{{Port: 22, Protocol: "tcp", State: "open"}}},
+		{Host: "10.0.0.2", State: scan.HostUp, Ports: []scan.PortReport{{Port: 80, Protocol: "tcp", State: "open"}}},
+	}
+	new := []scan.HostReport{
+		{Host: "10.0.0.1", State: scan.HostUp, Ports: []scan.PortReport{{Port: 22, Protocol: "tcp", State: "open"}}},
+		{Host: "10.0.0.3", State: scan.HostUp, Ports: []scan.PortReport{{Port: 443, Protocol: "tcp", State: "open"}}},
+	}
+
+	// hosts missing from a scan are not reported as down, and their ports are not compared
+	changes := Compare(old, new)
+	assert.Equal(t, []Change{
+		{Host: "10.0.0.2", Type: HostOnlyInOld, Old: scan.HostUp},
+		{Host: "10.0.0.3", Type: HostOnlyInNew, New: scan.HostUp},
+	}, changes)
+
+	assert.Equal(t, []Change{{Host: "10.0.0.3", Type: HostOnlyInNew, New: scan.HostUp}}, WithoutMissing(changes, false, true))
+	assert.Empty(t, WithoutMissing(changes, true, true))
+}
+
+func TestCompareIncompleteHost(t *testing.T) {
+	old := []scan.HostReport{{Host: "10.0.0.1", State: scan.HostUp, Ports: []scan.PortReport{
+		{Port: 22, Protocol: "tcp", State: "open"},
+		{Port: 80, Protocol: "tcp", State: "open"},
+	}}}
+	// the host was interrupted after port 22 was found closed, before port 80 was scanned
+	new := []scan.HostReport{{Host: "10.0.0.1", State: scan.HostUp, Incomplete: true, Ports: []scan.PortReport{
+		{Port: 22, Protocol: "tcp", State: "closed"},
+	}}}
+
+	assert.Equal(t, []Change{{Host: "10.0.0.1", Type: PortClosed, Port: 22, Protocol: "tcp"}}, Compare(old, new))
+}
+
+func TestCompareNoChanges(t *testing.T) {
+	hosts := []scan.HostReport{{Host: "10.0.0.1", State: scan.HostUp, Ports: []scan.PortReport{{Port: 22, Protocol: "tcp", State: "open"}}}}
+	assert.Empty(t, Compare(hosts, hosts))
+}
+
+func TestChangeString(t *testing.T) {
+	assert.Equal(t, "+ 443/tcp opened (https)", Change{Type: PortOpened, Port: 443, Protocol: "tcp", New: "https"}.String())
+	assert.Equal(t, "~ 22/tcp version changed: none -> OpenSSH_8.9p1", Change{Type: VersionChanged, Port: 22, Protocol: "tcp", New: "OpenSSH_8.9p1"}.String())
+	assert.Equal(t, "? host is only in the new scan (up)", Change{Type: HostOnlyInNew, New: scan.HostUp}.String())
+}
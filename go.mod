@@ -3,10 +3,15 @@ module github.com/liamg/furious
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.4.1
 	github.com/google/gopacket v1.1.17
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mostlygeek/arp v0.0.0-20170424181311-541a2129847a
 	github.com/phayes/freeport v0.0.0-20180830031419-95f893ade6f2
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.5
+	github.com/spf13/pflag v1.0.3
 	github.com/stretchr/testify v1.3.0
+	golang.org/x/sys v0.0.0-20190422165155-953cdadca894
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,72 @@
+package output
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+
+	"github.com/liamg/furious/scan"
+)
+
+func init() {
+	Register("csv", func(w io.Writer) Writer { return newCSVWriter(w) })
+}
+
+// csvWriter writes one row per port for each host, or a single row without port details for hosts with no ports
+type csvWriter struct {
+	writer   *csv.Writer
+	scanType string
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{writer: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Begin(info scan.ScanInfo) error {
+	c.scanType = info.ScanType
+	return c.writeRow([]string{"host", "port", "proto", "state", "service", "reason", "latency_ms", "mac", "vendor"})
+}
+
+func (c *csvWriter) WriteResult(result scan.Result) error {
+	report := result.Report()
+
+	latency := ""
+	if result.IsHostUp() {
+		latency = fmt.Sprintf("%.3f", report.LatencyMS)
+	}
+
+	if len(report.Ports) == 0 {
+		// for hosts without ports, the reason column holds the error which prevented the host being scanned (if any)
+		return c.writeRow([]string{report.Host, "", "", report.State, "", report.Error, latency, report.MAC, report.Manufacturer})
+	}
+
+	for _, port := range report.Ports {
+		if err := c.writeRow([]string{
+			report.Host,
+			fmt.Sprintf("%d", port.Port),
+			port.Protocol,
+			port.State,
+			port.Service,
+			portReason(c.scanType, port.State),
+			latency,
+			report.MAC,
+			report.Manufacturer,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *csvWriter) End(info scan.ScanInfo) error {
+	return nil
+}
+
+// writeRow writes and flushes a row, so that rows are available as soon as each host is complete
+func (c *csvWriter) writeRow(row []string) error {
+	if err := c.writer.Write(row); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
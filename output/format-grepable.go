@@ -0,0 +1,116 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/liamg/furious/scan"
+)
+
+func init() {
+	Register("grepable", func(w io.Writer) Writer { return newGrepableWriter(w) })
+}
+
+// grepableWriter writes results in nmap's grepable format, with one line per host for its status and another for
+// its ports. Fields are separated by tabs, and ports by commas.
+type grepableWriter struct {
+	w    io.Writer
+	up   int
+	down int
+}
+
+func newGrepableWriter(w io.Writer) *grepableWriter {
+	return &grepableWriter{w: w}
+}
+
+func (g *grepableWriter) Begin(info scan.ScanInfo) error {
+	_, err := fmt.Fprintf(
+		g.w,
+		"# furious scan initiated %s as: %s\n",
+		info.Start.Format(time.ANSIC),
+		strings.Join(append([]string{"furious"}, info.Arguments...), " "),
+	)
+	return err
+}
+
+func (g *grepableWriter) WriteResult(result scan.Result) error {
+	report := result.Report()
+
+	host := fmt.Sprintf("Host: %s (%s)", report.Host, report.Name)
+
+	status := "Down"
+	if result.IsHostUp() {
+		status = "Up"
+		g.up++
+	} else {
+		g.down++
+	}
+	statusLine := fmt.Sprintf("%s\tStatus: %s", host, status)
+	if result.Error != nil {
+		// the status stays Down for compatibility with nmap parsers, with the reason the host could not be scanned
+		statusLine = fmt.Sprintf("%s\tError: %s (%s)", statusLine, result.Error.Status, grepableField(report.Error))
+	}
+	if _, err := fmt.Fprintln(g.w, statusLine); err != nil {
+		return err
+	}
+
+	if len(report.Ports) == 0 {
+		return nil
+	}
+
+	// as with nmap, the most common state other than open is summarised rather than listed
+	ignored := scan.PortClosed
+	ignoredCount := len(result.Closed)
+	if len(result.Filtered) > ignoredCount {
+		ignored = scan.PortFiltered
+		ignoredCount = len(result.Filtered)
+	}
+
+	fields := []string{}
+	for _, port := range report.Ports {
+		if ignoredCount > 0 && port.State == ignored.String() {
+			continue
+		}
+		fields = append(fields, fmt.Sprintf(
+			"%d/%s/%s//%s//%s/",
+			port.Port,
+			port.State,
+			port.Protocol,
+			grepableField(port.Service),
+			grepableField(port.Version),
+		))
+	}
+
+	line := fmt.Sprintf("%s\tPorts: %s", host, strings.Join(fields, ", "))
+	if ignoredCount > 0 {
+		line = fmt.Sprintf("%s\tIgnored State: %s (%d)", line, ignored, ignoredCount)
+	}
+	if result.OS != nil && len(result.OS.Matches) > 0 {
+		line = fmt.Sprintf("%s\tOS: %s", line, grepableField(result.OS.Matches[0].Name))
+	}
+	_, err := fmt.Fprintln(g.w, line)
+	return err
+}
+
+func (g *grepableWriter) End(info scan.ScanInfo) error {
+	end := endTime(info)
+	_, err := fmt.Fprintf(
+		g.w,
+		"# furious done at %s -- %d IP addresses (%d hosts up) scanned in %.2f seconds\n",
+		end.Format(time.ANSIC),
+		g.up+g.down,
+		g.up,
+		end.Sub(info.Start).Seconds(),
+	)
+	if err == nil && info.Incomplete {
+		_, err = fmt.Fprintln(g.w, "# scan cancelled -- results are incomplete")
+	}
+	return err
+}
+
+// grepableField removes characters which separate fields in the grepable format
+func grepableField(input string) string {
+	return strings.NewReplacer("/", "|", ",", " ", "\t", " ", "\n", " ").Replace(input)
+}
@@ -0,0 +1,232 @@
+package output
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liamg/furious/scan"
+)
+
+func init() {
+	Register("html", func(w io.Writer) Writer { return &htmlWriter{w: w} })
+}
+
+// htmlWriter collects every host and writes a self-contained HTML report once the scan is complete
+type htmlWriter struct {
+	w     io.Writer
+	hosts []scan.HostReport
+}
+
+type htmlServiceCount struct {
+	Name  string
+	Count int
+}
+
+type htmlReport struct {
+	Info        scan.ScanInfo
+	Command     string
+	Elapsed     time.Duration
+	Hosts       []scan.HostReport
+	HostsUp     int
+	HostsFailed int
+	OpenPorts   int
+	TopServices []htmlServiceCount
+}
+
+// maxTopServices is the number of services listed in the report summary
+const maxTopServices = 10
+
+func (h *htmlWriter) Begin(info scan.ScanInfo) error {
+	h.hosts = []scan.HostReport{}
+	return nil
+}
+
+func (h *htmlWriter) WriteResult(result scan.Result) error {
+	h.hosts = append(h.hosts, result.Report())
+	return nil
+}
+
+func (h *htmlWriter) End(info scan.ScanInfo) error {
+	report := htmlReport{
+		Info:    info,
+		Command: strings.Join(append([]string{"furious"}, info.Arguments...), " "),
+		Elapsed: endTime(info).Sub(info.Start).Round(time.Millisecond),
+		Hosts:   h.hosts,
+	}
+
+	services := map[string]int{}
+	for _, host := range h.hosts {
+		if host.State == scan.HostUp {
+			report.HostsUp++
+		}
+		if host.Error != "" {
+			report.HostsFailed++
+		}
+		for _, port := range host.Ports {
+			if port.State != scan.PortOpen.String() {
+				continue
+			}
+			report.OpenPorts++
+			name := port.Service
+			if name == "" {
+				name = "unknown"
+			}
+			services[name]++
+		}
+	}
+	for name, count := range services {
+		report.TopServices = append(report.TopServices, htmlServiceCount{Name: name, Count: count})
+	}
+	sort.Slice(report.TopServices, func(i, j int) bool {
+		if report.TopServices[i].Count == report.TopServices[j].Count {
+			return report.TopServices[i].Name < report.TopServices[j].Name
+		}
+		return report.TopServices[i].Count > report.TopServices[j].Count
+	})
+	if len(report.TopServices) > maxTopServices {
+		report.TopServices = report.TopServices[:maxTopServices]
+	}
+
+	return htmlTemplate.Execute(h.w, report)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"latency": func(ms float64) string {
+		return (time.Duration(ms * float64(time.Millisecond))).Round(time.Microsecond).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>furious scan report - {{.Info.Start.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0.2em; }
+code { background: #f3f3f3; padding: 0.1em 0.3em; }
+.summary { display: flex; gap: 1em; flex-wrap: wrap; margin: 1em 0; }
+.card { border: 1px solid #ddd; border-radius: 4px; padding: 0.8em 1.2em; min-width: 8em; }
+.card .value { font-size: 1.8em; font-weight: bold; }
+.controls { margin: 1.5em 0; }
+.controls input[type=text] { padding: 0.4em; width: 24em; }
+.host { border: 1px solid #ddd; border-radius: 4px; margin: 1em 0; padding: 0.5em 1em; }
+.host h2 { font-size: 1.2em; margin: 0.4em 0; }
+.state-up { color: #1a7f37; }
+.state-down { color: #999; }
+.state-unreachable, .state-arp-failed, .state-error { color: #c0392b; }
+.meta { color: #555; margin: 0.2em 0 0.6em 0; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 0.5em; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #eee; vertical-align: top; }
+th.sortable { cursor: pointer; user-select: none; }
+th.sortable:after { content: " \2195"; color: #aaa; }
+tr.open td.state { color: #1a7f37; font-weight: bold; }
+tr.filtered td.state { color: #9a6700; }
+tr.closed td.state { color: #999; }
+.details { font-family: monospace; font-size: 0.9em; white-space: pre-wrap; color: #444; }
+.hidden { display: none; }
+</style>
+</head>
+<body>
+<h1>furious scan report</h1>
+<p class="meta">Started {{.Info.Start.Format "2006-01-02 15:04:05 MST"}}, {{if .Info.Incomplete}}cancelled after{{else}}completed in{{end}} {{.Elapsed}}{{if .Info.Version}} by furious {{.Info.Version}}{{end}}<br><code>{{.Command}}</code></p>
+
+{{if .Info.Incomplete}}<p class="state-error"><strong>The scan was cancelled before it finished, so these results are incomplete.</strong></p>{{end}}
+
+<div class="summary">
+<div class="card"><div class="value">{{len .Hosts}}</div>hosts scanned</div>
+<div class="card"><div class="value">{{.HostsUp}}</div>hosts up</div>
+{{if .HostsFailed}}<div class="card"><div class="value state-error">{{.HostsFailed}}</div>hosts not scanned</div>{{end}}
+<div class="card"><div class="value">{{.OpenPorts}}</div>open ports</div>
+{{if .TopServices}}<div class="card"><strong>Top services</strong>
+<table>
+{{range .TopServices}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table></div>{{end}}
+</div>
+
+<div class="controls">
+<input type="text" id="filter" placeholder="Filter by host, port, service or details...">
+<label><input type="checkbox" id="up-only"> Only show hosts which are up</label>
+<label><input type="checkbox" id="open-only"> Only show open ports</label>
+</div>
+
+{{range .Hosts}}
+<div class="host" data-state="{{.State}}">
+<h2>{{.Host}}{{if .Name}} ({{.Name}}){{end}} <span class="state-{{.State}}">{{.State}}</span></h2>
+<div class="meta">
+{{if .Error}}Error: {{.Error}}<br>{{end}}
+{{if .Incomplete}}Scan of this host was interrupted, results are incomplete<br>{{end}}
+{{if .LatencyMS}}Latency: {{latency .LatencyMS}}<br>{{end}}
+{{if .MAC}}MAC: {{.MAC}}{{if .Manufacturer}} ({{.Manufacturer}}){{end}}<br>{{end}}
+{{range .OS}}OS guess: {{.Name}} ({{.Accuracy}}%)<br>{{end}}
+{{range .Advertised}}Advertises: {{.Instance}} ({{.Type}}{{if .Port}} port {{.Port}}{{end}})<br>{{end}}
+{{if .Details}}<div class="details">{{join .Details "\n"}}</div>{{end}}
+</div>
+{{if .Ports}}
+<table class="ports">
+<thead><tr><th class="sortable" data-type="number">Port</th><th class="sortable">State</th><th class="sortable">Service</th><th class="sortable">Version</th><th>Details</th></tr></thead>
+<tbody>
+{{range .Ports}}<tr class="{{.State}}"><td>{{.Port}}/{{.Protocol}}</td><td class="state">{{.State}}</td><td>{{.Service}}</td><td>{{.Version}}</td><td class="details">{{join .Details "\n"}}{{if .Banner}}{{if .Details}}
+{{end}}{{.Banner}}{{end}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+</div>
+{{end}}
+
+<script>
+(function () {
+	var filter = document.getElementById("filter");
+	var upOnly = document.getElementById("up-only");
+	var openOnly = document.getElementById("open-only");
+
+	function apply() {
+		var term = filter.value.toLowerCase();
+		document.querySelectorAll(".host").forEach(function (host) {
+			var hostMatches = host.querySelector("h2").textContent.toLowerCase().indexOf(term) > -1 ||
+				host.querySelector(".meta").textContent.toLowerCase().indexOf(term) > -1;
+			var visibleRows = 0;
+			host.querySelectorAll("tbody tr").forEach(function (row) {
+				var show = (!openOnly.checked || row.classList.contains("open")) &&
+					(hostMatches || row.textContent.toLowerCase().indexOf(term) > -1);
+				row.classList.toggle("hidden", !show);
+				if (show) {
+					visibleRows++;
+				}
+			});
+			var show = (!upOnly.checked || host.dataset.state === "up") && (hostMatches || visibleRows > 0);
+			host.classList.toggle("hidden", !show);
+		});
+	}
+
+	filter.addEventListener("input", apply);
+	upOnly.addEventListener("change", apply);
+	openOnly.addEventListener("change", apply);
+
+	document.querySelectorAll("th.sortable").forEach(function (header) {
+		header.addEventListener("click", function () {
+			var table = header.closest("table");
+			var body = table.querySelector("tbody");
+			var index = Array.prototype.indexOf.call(header.parentNode.children, header);
+			var numeric = header.dataset.type === "number";
+			var ascending = header.dataset.order !== "asc";
+			header.dataset.order = ascending ? "asc" : "desc";
+			var rows = Array.prototype.slice.call(body.querySelectorAll("tr"));
+			rows.sort(function (a, b) {
+				var x = a.children[index].textContent.trim();
+				var y = b.children[index].textContent.trim();
+				var result = numeric ? parseInt(x, 10) - parseInt(y, 10) : x.localeCompare(y);
+				return ascending ? result : -result;
+			});
+			rows.forEach(function (row) {
+				body.appendChild(row);
+			});
+		});
+	});
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,93 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/liamg/furious/scan"
+)
+
+func init() {
+	Register("json", func(w io.Writer) Writer { return &jsonWriter{w: w} })
+	Register("ndjson", func(w io.Writer) Writer { return &ndjsonWriter{encoder: json.NewEncoder(w)} })
+}
+
+// jsonWriter collects every host and writes a single JSON document once the scan is complete
+type jsonWriter struct {
+	w     io.Writer
+	hosts []scan.HostReport
+}
+
+func (j *jsonWriter) Begin(info scan.ScanInfo) error {
+	j.hosts = []scan.HostReport{}
+	return nil
+}
+
+func (j *jsonWriter) WriteResult(result scan.Result) error {
+	j.hosts = append(j.hosts, result.Report())
+	return nil
+}
+
+func (j *jsonWriter) End(info scan.ScanInfo) error {
+	encoder := json.NewEncoder(j.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Scan  scan.ScanInfo     `json:"scan"`
+		Hosts []scan.HostReport `json:"hosts"`
+	}{
+		Scan:  info,
+		Hosts: j.hosts,
+	})
+}
+
+// ndjsonWriter writes one JSON object per line: a scan header, then each host as it is completed, then a summary.
+// Each object has a type field of "scan", "host" or "summary" respectively.
+type ndjsonWriter struct {
+	encoder *json.Encoder
+	up      int
+	total   int
+}
+
+func (n *ndjsonWriter) Begin(info scan.ScanInfo) error {
+	return n.encoder.Encode(struct {
+		Type string `json:"type"`
+		scan.ScanInfo
+	}{
+		Type:     "scan",
+		ScanInfo: info,
+	})
+}
+
+func (n *ndjsonWriter) WriteResult(result scan.Result) error {
+	n.total++
+	if result.IsHostUp() {
+		n.up++
+	}
+	return n.encoder.Encode(struct {
+		Type string `json:"type"`
+		scan.HostReport
+	}{
+		Type:       "host",
+		HostReport: result.Report(),
+	})
+}
+
+func (n *ndjsonWriter) End(info scan.ScanInfo) error {
+	end := endTime(info)
+	return n.encoder.Encode(struct {
+		Type       string    `json:"type"`
+		End        time.Time `json:"end"`
+		ElapsedMS  int64     `json:"elapsed_ms"`
+		HostsUp    int       `json:"hosts_up"`
+		Hosts      int       `json:"hosts"`
+		Incomplete bool      `json:"incomplete,omitempty"`
+	}{
+		Type:       "summary",
+		End:        end,
+		ElapsedMS:  int64(end.Sub(info.Start) / time.Millisecond),
+		HostsUp:    n.up,
+		Hosts:      n.total,
+		Incomplete: info.Incomplete,
+	})
+}
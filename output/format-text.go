@@ -0,0 +1,194 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/liamg/furious/scan"
+)
+
+func init() {
+	Register("text", func(w io.Writer) Writer { return &textWriter{w: w} })
+}
+
+// textWriter produces human readable output. Device and UPnP scans are laid out as a list of host attributes, and
+// port scans as a table of ports.
+type textWriter struct {
+	w        io.Writer
+	scanType string
+}
+
+func (t *textWriter) Begin(info scan.ScanInfo) error {
+	t.scanType = info.ScanType
+	_, err := fmt.Fprintf(t.w, "\nStarting scan at %s\n\n", info.Start.String())
+	return err
+}
+
+func (t *textWriter) WriteResult(result scan.Result) error {
+	_, err := fmt.Fprintln(t.w, Text(t.scanType, result))
+	return err
+}
+
+func (t *textWriter) End(info scan.ScanInfo) error {
+	if info.Incomplete {
+		_, err := fmt.Fprintf(t.w, "Scan cancelled after %s. Results are incomplete.\n", endTime(info).Sub(info.Start).String())
+		return err
+	}
+	_, err := fmt.Fprintf(t.w, "Scan complete in %s.\n", endTime(info).Sub(info.Start).String())
+	return err
+}
+
+// Text formats a single result as the text output does for the given type of scan.
+func Text(scanType string, result scan.Result) string {
+	switch scanType {
+	case "device":
+		return deviceText(result)
+	case "upnp":
+		return upnpText(result)
+	}
+	return result.String()
+}
+
+func field(label string, value interface{}) string {
+	return fmt.Sprintf("\t%s %v\n", pad(label, 24), value)
+}
+
+func hostText(result scan.Result) string {
+	text := fmt.Sprintf("Scan results for host %s\n", result.Host.String())
+
+	text += field("Status:", strings.ToUpper(result.Status()))
+	if result.Error != nil {
+		text += field("Error:", result.Error.Err)
+	}
+	if result.Incomplete {
+		text += field("Note:", "scan of this host was interrupted, results are incomplete")
+	}
+	return text
+}
+
+func deviceText(result scan.Result) string {
+
+	text := hostText(result)
+
+	if result.IsHostUp() {
+		text += field("Latency:", result.Latency.String())
+	}
+	if result.MAC != "" {
+		text += field("MAC:", result.MAC)
+	}
+	if result.Manufacturer != "" {
+		text += field("Manufacturer:", result.Manufacturer)
+	}
+	if result.Name != "" {
+		text += field("Name:", result.Name)
+	}
+
+	for _, advertised := range result.Advertised {
+		description := fmt.Sprintf("%s (%s)", advertised.Instance, advertised.Type)
+		if advertised.Port > 0 {
+			description = fmt.Sprintf("%s (%s port %d)", advertised.Instance, advertised.Type, advertised.Port)
+		}
+		text += field("Advertises:", description)
+	}
+
+	service := result.Service(445)
+	if service == nil || service.SMB == nil {
+		service = result.Service(139)
+	}
+	if service != nil && service.SMB != nil {
+		smb := service.SMB
+		if smb.DNSDomainName != "" || smb.NetBIOSDomainName != "" {
+			domain := smb.DNSDomainName
+			if domain == "" {
+				domain = smb.NetBIOSDomainName
+			}
+			text += field("Domain:", domain)
+		}
+		if smb.OSVersion != "" {
+			text += field("OS Version:", smb.OSVersion)
+		}
+		text += field("SMB Dialects:", strings.Join(smb.Dialects, ", "))
+		if smb.SMB1 {
+			text += field("SMB1 Signing Required:", smb.SMB1SigningRequired)
+		}
+		if smb.SMB2() {
+			text += field("SMB2 Signing Required:", smb.SMB2SigningRequired)
+		}
+	}
+
+	return text + snmpText(result)
+}
+
+func upnpText(result scan.Result) string {
+
+	text := hostText(result)
+
+	if result.MAC != "" {
+		text += field("MAC:", result.MAC)
+	}
+
+	if result.UPnP != nil {
+		text += field("Server:", result.UPnP.Server)
+		text += field("Location:", result.UPnP.Location)
+		if result.UPnP.PortMapping {
+			text += field("Port Mapping:", "EXPOSED (WAN connection service available)")
+		}
+		for _, device := range result.UPnP.Devices {
+			text += upnpDeviceText(device, "\t")
+		}
+	}
+
+	return text + snmpText(result)
+}
+
+// snmpText lists what the host's SNMP agent reported, if anything, along with any other recorded details
+func snmpText(result scan.Result) string {
+	text := ""
+	if result.SNMP != nil {
+		for _, detail := range result.SNMP.Details() {
+			text += field("SNMP:", strings.TrimPrefix(detail, "snmp "))
+		}
+	}
+	for _, detail := range result.Details {
+		if strings.HasPrefix(detail, "snmp ") {
+			text += field("SNMP:", strings.TrimPrefix(detail, "snmp "))
+		} else {
+			text += field("Details:", detail)
+		}
+	}
+	return text
+}
+
+func upnpDeviceText(device scan.UPnPDevice, indent string) string {
+	text := ""
+	for _, field := range []struct {
+		label string
+		value string
+	}{
+		{"Device:", device.DeviceType},
+		{"Name:", device.FriendlyName},
+		{"Manufacturer:", device.Manufacturer},
+		{"Model:", strings.TrimSpace(device.ModelName + " " + device.ModelNumber)},
+		{"Firmware:", device.Firmware()},
+		{"Serial:", device.SerialNumber},
+	} {
+		if field.value != "" {
+			text += fmt.Sprintf("%s%s %s\n", indent, pad(field.label, 24), field.value)
+		}
+	}
+	for _, service := range device.Services {
+		text += fmt.Sprintf("%s%s %s\n", indent, pad("Service:", 24), service.ServiceType)
+	}
+	for _, embedded := range device.Devices {
+		text += upnpDeviceText(embedded, indent+"\t")
+	}
+	return text
+}
+
+func pad(input string, length int) string {
+	for len(input) < length {
+		input += " "
+	}
+	return input
+}
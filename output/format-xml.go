@@ -0,0 +1,359 @@
+package output
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liamg/furious/scan"
+)
+
+func init() {
+	Register("xml", func(w io.Writer) Writer { return newXMLWriter(w) })
+}
+
+// xmlWriter writes results using nmap's XML output schema, so that tools which import nmap scans can consume them.
+// Hosts are written as they are completed, in the same way as nmap.
+type xmlWriter struct {
+	w        io.Writer
+	encoder  *xml.Encoder
+	scanType string
+	up       int
+	down     int
+}
+
+func newXMLWriter(w io.Writer) *xmlWriter {
+	return &xmlWriter{
+		w:       w,
+		encoder: xml.NewEncoder(w),
+	}
+}
+
+type nmapScanInfo struct {
+	XMLName     xml.Name `xml:"scaninfo"`
+	Type        string   `xml:"type,attr"`
+	Protocol    string   `xml:"protocol,attr"`
+	NumServices int      `xml:"numservices,attr"`
+	Services    string   `xml:"services,attr"`
+}
+
+type nmapHost struct {
+	XMLName   xml.Name        `xml:"host"`
+	StartTime int64           `xml:"starttime,attr"`
+	EndTime   int64           `xml:"endtime,attr"`
+	Status    nmapStatus      `xml:"status"`
+	Addresses []nmapAddress   `xml:"address"`
+	Hostnames []nmapHostname  `xml:"hostnames>hostname"`
+	Ports     *nmapPorts      `xml:"ports,omitempty"`
+	OS        *nmapOS         `xml:"os,omitempty"`
+	Times     *nmapTimes      `xml:"times,omitempty"`
+	Script    *nmapHostScript `xml:"hostscript,omitempty"`
+}
+
+type nmapStatus struct {
+	State     string `xml:"state,attr"`
+	Reason    string `xml:"reason,attr"`
+	ReasonTTL int    `xml:"reason_ttl,attr"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+	Vendor   string `xml:"vendor,attr,omitempty"`
+}
+
+type nmapHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type nmapPorts struct {
+	ExtraPorts []nmapExtraPorts `xml:"extraports"`
+	Ports      []nmapPort       `xml:"port"`
+}
+
+type nmapExtraPorts struct {
+	State string `xml:"state,attr"`
+	Count int    `xml:"count,attr"`
+}
+
+type nmapPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortID   int          `xml:"portid,attr"`
+	State    nmapStatus   `xml:"state"`
+	Service  *nmapService `xml:"service,omitempty"`
+}
+
+type nmapService struct {
+	Name      string `xml:"name,attr"`
+	Product   string `xml:"product,attr,omitempty"`
+	Version   string `xml:"version,attr,omitempty"`
+	ExtraInfo string `xml:"extrainfo,attr,omitempty"`
+	Method    string `xml:"method,attr"`
+	Conf      int    `xml:"conf,attr"`
+}
+
+type nmapOS struct {
+	Matches []nmapOSMatch `xml:"osmatch"`
+}
+
+type nmapOSMatch struct {
+	Name     string `xml:"name,attr"`
+	Accuracy int    `xml:"accuracy,attr"`
+	Line     int    `xml:"line,attr"`
+}
+
+type nmapTimes struct {
+	SRTT   int64 `xml:"srtt,attr"`
+	RTTVar int64 `xml:"rttvar,attr"`
+	To     int64 `xml:"to,attr"`
+}
+
+type nmapHostScript struct {
+	Scripts []nmapScript `xml:"script"`
+}
+
+type nmapScript struct {
+	ID     string `xml:"id,attr"`
+	Output string `xml:"output,attr"`
+}
+
+type nmapRunStats struct {
+	XMLName  xml.Name `xml:"runstats"`
+	Finished struct {
+		Time     int64   `xml:"time,attr"`
+		TimeStr  string  `xml:"timestr,attr"`
+		Elapsed  float64 `xml:"elapsed,attr"`
+		Summary  string  `xml:"summary,attr"`
+		Exit     string  `xml:"exit,attr"`
+		ErrorMsg string  `xml:"errormsg,attr,omitempty"`
+	} `xml:"finished"`
+	Hosts struct {
+		Up    int `xml:"up,attr"`
+		Down  int `xml:"down,attr"`
+		Total int `xml:"total,attr"`
+	} `xml:"hosts"`
+}
+
+// nmapScanTypes maps furious scan types to nmap's; scan types without port scanning have no scaninfo element
+var nmapScanTypes = map[string]string{
+	"stealth": "syn",
+	"syn":     "syn",
+	"fast":    "syn",
+	"connect": "connect",
+}
+
+func (x *xmlWriter) Begin(info scan.ScanInfo) error {
+	x.scanType = info.ScanType
+	args := append([]string{"furious"}, info.Arguments...)
+	header := fmt.Sprintf(
+		"%s<!DOCTYPE nmaprun>\n<nmaprun scanner=\"furious\" args=\"%s\" start=\"%d\" startstr=\"%s\" version=\"%s\" xmloutputversion=\"1.05\">\n",
+		xml.Header,
+		escapeXML(strings.Join(args, " ")),
+		info.Start.Unix(),
+		info.Start.Format(time.ANSIC),
+		escapeXML(info.Version),
+	)
+	if _, err := io.WriteString(x.w, header); err != nil {
+		return err
+	}
+
+	if scanType, ok := nmapScanTypes[info.ScanType]; ok {
+		if err := x.encode(nmapScanInfo{
+			Type:        scanType,
+			Protocol:    "tcp",
+			NumServices: len(info.Ports),
+			Services:    compactPorts(info.Ports),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *xmlWriter) WriteResult(result scan.Result) error {
+	report := result.Report()
+	now := time.Now().Unix()
+
+	host := nmapHost{
+		StartTime: now,
+		EndTime:   now,
+		Status: nmapStatus{
+			State:  hostState(result),
+			Reason: hostReason(result),
+		},
+		Addresses: []nmapAddress{{Addr: report.Host, AddrType: "ipv4"}},
+	}
+	if result.Host.To4() == nil {
+		host.Addresses[0].AddrType = "ipv6"
+	}
+	if report.MAC != "" {
+		host.Addresses = append(host.Addresses, nmapAddress{
+			Addr:     strings.ToUpper(report.MAC),
+			AddrType: "mac",
+			Vendor:   report.Manufacturer,
+		})
+	}
+	if report.Name != "" {
+		host.Hostnames = append(host.Hostnames, nmapHostname{Name: report.Name, Type: "PTR"})
+	}
+
+	if result.IsHostUp() {
+		x.up++
+		srtt := int64(result.Latency / time.Microsecond)
+		to := srtt * 4
+		if to < 100000 {
+			to = 100000
+		}
+		host.Times = &nmapTimes{SRTT: srtt, To: to}
+	} else {
+		x.down++
+	}
+
+	if len(report.Ports) > 0 {
+		host.Ports = &nmapPorts{}
+		if len(result.Closed) > 0 {
+			host.Ports.ExtraPorts = append(host.Ports.ExtraPorts, nmapExtraPorts{State: "closed", Count: len(result.Closed)})
+		}
+		if len(result.Filtered) > 0 {
+			host.Ports.ExtraPorts = append(host.Ports.ExtraPorts, nmapExtraPorts{State: "filtered", Count: len(result.Filtered)})
+		}
+		for _, port := range report.Ports {
+			if port.State != scan.PortOpen.String() {
+				continue
+			}
+			nPort := nmapPort{
+				Protocol: port.Protocol,
+				PortID:   port.Port,
+				State:    nmapStatus{State: port.State, Reason: portReason(x.scanType, port.State)},
+			}
+			if port.Service != "" {
+				nPort.Service = &nmapService{
+					Name:   port.Service,
+					Method: "table",
+					Conf:   3,
+				}
+				if result.Service(port.Port) != nil {
+					nPort.Service.Method = "probed"
+					nPort.Service.Conf = 10
+					nPort.Service.Version = port.Version
+					nPort.Service.ExtraInfo = strings.Join(port.Details, "; ")
+				}
+			}
+			host.Ports.Ports = append(host.Ports.Ports, nPort)
+		}
+	}
+
+	if result.OS != nil {
+		host.OS = &nmapOS{}
+		for _, match := range result.OS.Matches {
+			host.OS.Matches = append(host.OS.Matches, nmapOSMatch{Name: match.Name, Accuracy: match.Accuracy})
+		}
+	}
+
+	if len(report.Details) > 0 {
+		host.Script = &nmapHostScript{
+			Scripts: []nmapScript{{ID: "furious-details", Output: strings.Join(report.Details, "\n")}},
+		}
+	}
+
+	return x.encode(host)
+}
+
+func (x *xmlWriter) End(info scan.ScanInfo) error {
+	end := endTime(info)
+	stats := nmapRunStats{}
+	stats.Finished.Time = end.Unix()
+	stats.Finished.TimeStr = end.Format(time.ANSIC)
+	stats.Finished.Elapsed = end.Sub(info.Start).Seconds()
+	stats.Finished.Exit = "success"
+	stats.Finished.Summary = fmt.Sprintf(
+		"furious done at %s; %d IP addresses (%d hosts up) scanned in %.2f seconds",
+		stats.Finished.TimeStr,
+		x.up+x.down,
+		x.up,
+		stats.Finished.Elapsed,
+	)
+	if info.Incomplete {
+		stats.Finished.Exit = "error"
+		stats.Finished.ErrorMsg = "Scan cancelled, results are incomplete"
+	}
+	stats.Hosts.Up = x.up
+	stats.Hosts.Down = x.down
+	stats.Hosts.Total = x.up + x.down
+
+	if err := x.encode(stats); err != nil {
+		return err
+	}
+	_, err := io.WriteString(x.w, "</nmaprun>\n")
+	return err
+}
+
+func (x *xmlWriter) encode(v interface{}) error {
+	if err := x.encoder.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(x.w, "\n")
+	return err
+}
+
+// hostState maps the status of a host to nmap's host states. Hosts which could not be scanned because of a local
+// problem are unknown rather than down.
+func hostState(result scan.Result) string {
+	switch result.Status() {
+	case scan.HostUp:
+		return "up"
+	case scan.HostARPFailed, scan.HostLocalError:
+		return "unknown"
+	}
+	return "down"
+}
+
+// hostReason describes why a host is considered up or down, using nmap's reason names
+func hostReason(result scan.Result) string {
+	switch {
+	case result.Error != nil && result.Error.Status == scan.HostUnreachable:
+		return "host-unreach"
+	case result.Error != nil:
+		return result.Error.Status
+	case !result.IsHostUp():
+		return "no-response"
+	case len(result.Open) > 0:
+		return "syn-ack"
+	case len(result.Closed) > 0:
+		return "reset"
+	case result.MAC != "":
+		return "arp-response"
+	}
+	return "user-set"
+}
+
+// compactPorts formats ports as a list of ranges e.g. 1-3,22,80
+func compactPorts(ports []int) string {
+	sorted := append([]int{}, ports...)
+	sort.Ints(sorted)
+
+	ranges := []string{}
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[i] == sorted[j] {
+			ranges = append(ranges, fmt.Sprintf("%d", sorted[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
+func escapeXML(input string) string {
+	buffer := &strings.Builder{}
+	_ = xml.EscapeText(buffer, []byte(input))
+	return buffer.String()
+}
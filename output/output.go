@@ -0,0 +1,109 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liamg/furious/scan"
+)
+
+// Writer writes scan results in a particular format. Begin is called once before any results are written, and End
+// once after all results have been written.
+type Writer interface {
+	Begin(info scan.ScanInfo) error
+	WriteResult(result scan.Result) error
+	End(info scan.ScanInfo) error
+}
+
+// Factory creates a Writer which writes to w.
+type Factory func(w io.Writer) Writer
+
+var formats = map[string]Factory{}
+
+// Register makes an output format available by name.
+func Register(name string, factory Factory) {
+	formats[strings.ToLower(name)] = factory
+}
+
+// Formats returns the names of all available output formats, sorted alphabetically.
+func Formats() []string {
+	names := []string{}
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates a Writer for the named format which writes to w.
+func New(format string, w io.Writer) (Writer, error) {
+	factory, ok := formats[strings.ToLower(strings.TrimSpace(format))]
+	if !ok {
+		return nil, fmt.Errorf("Unknown output format '%s'. Must be one of %s", format, strings.Join(Formats(), ", "))
+	}
+	return factory(w), nil
+}
+
+type multiWriter struct {
+	writers []Writer
+}
+
+// Multi creates a Writer which writes to each of the given writers in turn, stopping at the first error.
+func Multi(writers ...Writer) Writer {
+	return &multiWriter{writers: writers}
+}
+
+func (m *multiWriter) Begin(info scan.ScanInfo) error {
+	for _, writer := range m.writers {
+		if err := writer.Begin(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *multiWriter) WriteResult(result scan.Result) error {
+	for _, writer := range m.writers {
+		if err := writer.WriteResult(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *multiWriter) End(info scan.ScanInfo) error {
+	for _, writer := range m.writers {
+		if err := writer.End(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endTime returns when the scan ended. Callers may not set an end time, in which case the scan is taken to have
+// ended now.
+func endTime(info scan.ScanInfo) time.Time {
+	if info.End != nil {
+		return *info.End
+	}
+	return time.Now()
+}
+
+// portReason describes why a port was given its state, using nmap's reason names
+func portReason(scanType string, state string) string {
+	switch state {
+	case scan.PortOpen.String():
+		return scan.ReasonSynAck
+	case scan.PortClosed.String():
+		if scanType == "connect" {
+			return scan.ReasonConnRefused
+		}
+		return scan.ReasonReset
+	case scan.PortFiltered.String():
+		return scan.ReasonNoResponse
+	}
+	return ""
+}
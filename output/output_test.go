@@ -0,0 +1,262 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liamg/furious/scan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testScan() (scan.ScanInfo, []scan.Result) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(5 * time.Second)
+	info := scan.ScanInfo{
+		Arguments: []string{"-s", "connect", "192.168.1.0/31"},
+		ScanType:  "connect",
+		Targets:   []string{"192.168.1.0/31"},
+		Ports:     []int{22, 80, 443},
+		Start:     start,
+		End:       &end,
+	}
+
+	up := scan.NewResult(net.ParseIP("192.168.1.1"))
+	up.Latency = 2 * time.Millisecond
+	up.MAC = "00:11:22:33:44:55"
+	up.Manufacturer = "Acme"
+	up.Open = []int{22}
+	up.Closed = []int{80, 443}
+	up.Services = []scan.Service{{Port: 22, Name: "ssh", Version: "OpenSSH_8.9p1"}}
+
+	return info, []scan.Result{scan.NewResult(net.ParseIP("192.168.1.0")), up}
+}
+
+func writeAll(t *testing.T, writer Writer) {
+	info, results := testScan()
+	require.NoError(t, writer.Begin(info))
+	for _, result := range results {
+		require.NoError(t, writer.WriteResult(result))
+	}
+	require.NoError(t, writer.End(info))
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := New("yaml", &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestMultiWriter(t *testing.T) {
+	jsonBuffer := &bytes.Buffer{}
+	csvBuffer := &bytes.Buffer{}
+
+	jsonWriter, err := New("json", jsonBuffer)
+	require.NoError(t, err)
+	csvWriter, err := New("csv", csvBuffer)
+	require.NoError(t, err)
+
+	writeAll(t, Multi(jsonWriter, csvWriter))
+
+	document := struct {
+		Scan  scan.ScanInfo     `json:"scan"`
+		Hosts []scan.HostReport `json:"hosts"`
+	}{}
+	require.NoError(t, json.Unmarshal(jsonBuffer.Bytes(), &document))
+	assert.Equal(t, "connect", document.Scan.ScanType)
+	require.Len(t, document.Hosts, 2)
+	assert.Equal(t, scan.HostDown, document.Hosts[0].State)
+	assert.Equal(t, "ssh", document.Hosts[1].Ports[0].Service)
+
+	assert.Equal(t, strings.Join([]string{
+		"host,port,proto,state,service,reason,latency_ms,mac,vendor",
+		"192.168.1.0,,,down,,,,,",
+		"192.168.1.1,22,tcp,open,ssh,syn-ack,2.000,00:11:22:33:44:55,Acme",
+		"192.168.1.1,80,tcp,closed,,conn-refused,2.000,00:11:22:33:44:55,Acme",
+		"192.168.1.1,443,tcp,closed,,conn-refused,2.000,00:11:22:33:44:55,Acme",
+		"",
+	}, "\n"), csvBuffer.String())
+}
+
+func TestNDJSON(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer, err := New("ndjson", buffer)
+	require.NoError(t, err)
+	writeAll(t, writer)
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 4)
+	for i, expected := range []string{"scan", "host", "host", "summary"} {
+		object := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), &object))
+		assert.Equal(t, expected, object["type"])
+	}
+}
+
+func TestXML(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer, err := New("xml", buffer)
+	require.NoError(t, err)
+	writeAll(t, writer)
+
+	run := struct {
+		ScanInfo struct {
+			Type     string `xml:"type,attr"`
+			Services string `xml:"services,attr"`
+		} `xml:"scaninfo"`
+		Hosts []struct {
+			Status struct {
+				State string `xml:"state,attr"`
+			} `xml:"status"`
+			Addresses []nmapAddress `xml:"address"`
+			Ports     []nmapPort    `xml:"ports>port"`
+		} `xml:"host"`
+		Stats struct {
+			Hosts struct {
+				Up    int `xml:"up,attr"`
+				Total int `xml:"total,attr"`
+			} `xml:"hosts"`
+		} `xml:"runstats"`
+	}{}
+	require.NoError(t, xml.Unmarshal(buffer.Bytes(), &run))
+
+	assert.Equal(t, "connect", run.ScanInfo.Type)
+	assert.Equal(t, "22,80,443", run.ScanInfo.Services)
+	require.Len(t, run.Hosts, 2)
+	assert.Equal(t, "down", run.Hosts[0].Status.State)
+	assert.Equal(t, []nmapAddress{
+		{Addr: "192.168.1.1", AddrType: "ipv4"},
+		{Addr: "00:11:22:33:44:55", AddrType: "mac", Vendor: "Acme"},
+	}, run.Hosts[1].Addresses)
+	require.Len(t, run.Hosts[1].Ports, 1)
+	assert.Equal(t, 22, run.Hosts[1].Ports[0].PortID)
+	assert.Equal(t, "ssh", run.Hosts[1].Ports[0].Service.Name)
+	assert.Equal(t, 1, run.Stats.Hosts.Up)
+	assert.Equal(t, 2, run.Stats.Hosts.Total)
+}
+
+func TestGrepable(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer, err := New("grepable", buffer)
+	require.NoError(t, err)
+	writeAll(t, writer)
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, "Host: 192.168.1.0 ()\tStatus: Down", lines[1])
+	assert.Equal(t, "Host: 192.168.1.1 ()\tStatus: Up", lines[2])
+	assert.Equal(t, "Host: 192.168.1.1 ()\tPorts: 22/open/tcp//ssh//OpenSSH_8.9p1/\tIgnored State: closed (2)", lines[3])
+}
+
+func TestCompactPorts(t *testing.T) {
+	assert.Equal(t, "1-3,22,80-81", compactPorts([]int{81, 1, 2, 3, 22, 80}))
+}
+
+func TestHTML(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer, err := New("html", buffer)
+	require.NoError(t, err)
+	writeAll(t, writer)
+
+	report := buffer.String()
+	assert.True(t, strings.HasPrefix(report, "<!DOCTYPE html>"))
+	assert.Contains(t, report, `<div class="value">2</div>hosts scanned`)
+	assert.Contains(t, report, `<div class="value">1</div>hosts up`)
+	assert.Contains(t, report, "<tr><td>ssh</td><td>1</td></tr>")
+	assert.Contains(t, report, "MAC: 00:11:22:33:44:55 (Acme)")
+	assert.Contains(t, report, "<td>22/tcp</td>")
+}
+
+func TestHostError(t *testing.T) {
+	info, _ := testScan()
+	result := scan.NewResult(net.ParseIP("192.168.1.2"))
+	result.Error = &scan.HostError{Status: scan.HostUnreachable, Err: errors.New("no route")}
+
+	for format, expected := range map[string]string{
+		"text":     "Host could not be scanned: host is unreachable: no route",
+		"json":     `"error": "no route"`,
+		"xml":      `<status state="down" reason="host-unreach" reason_ttl="0"></status>`,
+		"grepable": "Status: Down\tError: unreachable (no route)",
+		"csv":      "192.168.1.2,,,unreachable,,no route,,,",
+		"html":     "Error: no route",
+	} {
+		buffer := &bytes.Buffer{}
+		writer, err := New(format, buffer)
+		require.NoError(t, err)
+		require.NoError(t, writer.Begin(info))
+		require.NoError(t, writer.WriteResult(result))
+		require.NoError(t, writer.End(info))
+		assert.Contains(t, buffer.String(), expected, format)
+	}
+}
+
+func TestIncompleteScan(t *testing.T) {
+	info, _ := testScan()
+	info.Incomplete = true
+	result := scan.NewResult(net.ParseIP("192.168.1.1"))
+	result.Latency = time.Millisecond
+	result.Open = []int{22}
+	result.Incomplete = true
+
+	for format, expected := range map[string]string{
+		"text":     "Scan cancelled after 5s. Results are incomplete.",
+		"json":     `"incomplete": true`,
+		"ndjson":   `"type":"summary","end":"2020-01-01T12:00:05Z","elapsed_ms":5000,"hosts_up":1,"hosts":1,"incomplete":true`,
+		"xml":      `exit="error" errormsg="Scan cancelled, results are incomplete"`,
+		"grepable": "# scan cancelled -- results are incomplete",
+		"html":     "these results are incomplete",
+	} {
+		buffer := &bytes.Buffer{}
+		writer, err := New(format, buffer)
+		require.NoError(t, err)
+		require.NoError(t, writer.Begin(info))
+		require.NoError(t, writer.WriteResult(result))
+		require.NoError(t, writer.End(info))
+		assert.Contains(t, buffer.String(), expected, format)
+	}
+}
+
+func TestTextSNMP(t *testing.T) {
+	for _, scanType := range []string{"device", "upnp"} {
+		info, _ := testScan()
+		info.ScanType = scanType
+		result := scan.NewResult(net.ParseIP("192.168.1.1"))
+		result.Latency = time.Millisecond
+		result.SNMP = &scan.SNMPInfo{
+			Communities: []scan.SNMPCommunity{{Community: "public", Version: "v2c"}},
+			SysName:     "core-switch",
+		}
+
+		buffer := &bytes.Buffer{}
+		writer, err := New("text", buffer)
+		require.NoError(t, err)
+		require.NoError(t, writer.Begin(info))
+		require.NoError(t, writer.WriteResult(result))
+		assert.Contains(t, buffer.String(), "community accepted: public (v2c)", scanType)
+		assert.Contains(t, buffer.String(), "sysName: core-switch", scanType)
+
+		// a recorded result is displayed the same way
+		assert.Equal(t, Text(scanType, result), Text(scanType, result.Report().Result()), scanType)
+	}
+}
+
+func TestNoEndTime(t *testing.T) {
+	for _, format := range Formats() {
+		info, results := testScan()
+		info.End = nil
+
+		buffer := &bytes.Buffer{}
+		writer, err := New(format, buffer)
+		require.NoError(t, err)
+		require.NoError(t, writer.Begin(info), format)
+		for _, result := range results {
+			require.NoError(t, writer.WriteResult(result), format)
+		}
+		assert.NotPanics(t, func() { assert.NoError(t, writer.End(info), format) }, format)
+	}
+}
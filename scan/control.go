@@ -0,0 +1,253 @@
+package scan
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Control lets a running scan be paused, resumed and throttled. Scanner workers acquire a slot from it before
+// starting each job, and wait on it before sending each probe, so pausing stops new probes without losing any
+// progress.
+type Control struct {
+	mutex   sync.Mutex
+	paused  bool
+	changed chan struct{}
+	// rateChanged is closed when the rate changes, so probes waiting for a slot reserve a new one
+	rateChanged chan struct{}
+	rate        int
+	next        time.Time
+	workers     int
+	active      int
+	timeout     time.Duration
+}
+
+// NewControl creates a control which is not paused, and does not limit the scan.
+func NewControl() *Control {
+	return &Control{
+		changed:     make(chan struct{}),
+		rateChanged: make(chan struct{}),
+	}
+}
+
+// notify wakes everything waiting on the control, so they can check whether they may continue. The mutex must be
+// held.
+func (c *Control) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Pause stops scanners starting new jobs or sending new probes until Resume is called.
+func (c *Control) Pause() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.paused = true
+}
+
+// Resume allows paused scanners to continue.
+func (c *Control) Resume() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.paused {
+		c.paused = false
+		c.notify()
+	}
+}
+
+// Paused reports whether the scan is paused.
+func (c *Control) Paused() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.paused
+}
+
+// SetRate limits the number of probes sent per second across all scanners using the control. A rate of zero or
+// less removes the limit. Slots already reserved at the previous rate are discarded, so probes waiting for them are
+// spread out at the new rate instead.
+func (c *Control) SetRate(rate int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if rate < 0 {
+		rate = 0
+	}
+	c.rate = rate
+	c.next = time.Now()
+	close(c.rateChanged)
+	c.rateChanged = make(chan struct{})
+}
+
+// Rate returns the maximum number of probes sent per second, or zero if the rate is not limited.
+func (c *Control) Rate() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.rate
+}
+
+// SetWorkers limits the number of scanner workers which are active at once. Scanners start a fixed number of
+// workers, so the limit can lower the number of active workers, or raise it back up to the number started. A limit
+// of zero or less removes the limit.
+func (c *Control) SetWorkers(workers int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if workers < 0 {
+		workers = 0
+	}
+	c.workers = workers
+	c.notify()
+}
+
+// Workers returns the maximum number of active workers, or zero if they are not limited.
+func (c *Control) Workers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.workers
+}
+
+// Active returns the number of workers which are currently active.
+func (c *Control) Active() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.active
+}
+
+// SetTimeout overrides the timeout scanners use for probes started from now on. A timeout of zero or less restores
+// the timeout each scanner was created with.
+func (c *Control) SetTimeout(timeout time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if timeout < 0 {
+		timeout = 0
+	}
+	c.timeout = timeout
+}
+
+// Timeout returns the timeout override, or zero if scanners should use their own timeout.
+func (c *Control) Timeout() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.timeout
+}
+
+// Acquire blocks until a worker may start a job: while the scan is paused, and while the worker limit is reached.
+// Every successful call must be followed by a call to Release once the job is complete. It returns early with an
+// error if ctx is cancelled.
+func (c *Control) Acquire(ctx context.Context) error {
+	for {
+		c.mutex.Lock()
+		if !c.paused && (c.workers == 0 || c.active < c.workers) {
+			c.active++
+			c.mutex.Unlock()
+			return nil
+		}
+		changed := c.changed
+		c.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Release marks a job started after Acquire as complete.
+func (c *Control) Release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.active--
+	c.notify()
+}
+
+// Wait blocks until a probe may be sent: while the scan is paused, and for as long as needed to keep to the rate
+// limit. If the rate changes while waiting, a new slot is reserved at the new rate. It returns early with an error if
+// ctx is cancelled.
+func (c *Control) Wait(ctx context.Context) error {
+	for {
+		c.mutex.Lock()
+		if c.paused {
+			changed := c.changed
+			c.mutex.Unlock()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-changed:
+			}
+			continue
+		}
+
+		if c.rate <= 0 {
+			c.mutex.Unlock()
+			return nil
+		}
+
+		// reserve the next slot, so concurrent callers are spread out at the configured rate
+		now := time.Now()
+		if c.next.Before(now) {
+			c.next = now
+		}
+		slot := c.next
+		c.next = c.next.Add(time.Second / time.Duration(c.rate))
+		rateChanged := c.rateChanged
+		c.mutex.Unlock()
+
+		delay := slot.Sub(now)
+		if delay <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-rateChanged:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		return nil
+	}
+}
+
+// controllable is embedded by scanners to let them be paused and throttled by an optional Control.
+type controllable struct {
+	control *Control
+}
+
+// SetControl sets the control used to pause and throttle the scanner. A nil control leaves the scanner
+// unrestricted.
+func (c *controllable) SetControl(control *Control) {
+	c.control = control
+}
+
+// wait blocks until the scanner may send a probe.
+func (c *controllable) wait(ctx context.Context) error {
+	if c.control == nil {
+		return nil
+	}
+	return c.control.Wait(ctx)
+}
+
+// acquire blocks until a scanner worker may start a job.
+func (c *controllable) acquire(ctx context.Context) error {
+	if c.control == nil {
+		return nil
+	}
+	return c.control.Acquire(ctx)
+}
+
+// release marks a job started after acquire as complete.
+func (c *controllable) release() {
+	if c.control != nil {
+		c.control.Release()
+	}
+}
+
+// currentTimeout returns the probe timeout to use now, which is fallback unless the control overrides it.
+func (c *controllable) currentTimeout(fallback time.Duration) time.Duration {
+	if c.control == nil {
+		return fallback
+	}
+	if timeout := c.control.Timeout(); timeout > 0 {
+		return timeout
+	}
+	return fallback
+}
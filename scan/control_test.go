@@ -0,0 +1,122 @@
+package scan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlPause(t *testing.T) {
+	control := NewControl()
+	control.Pause()
+	assert.True(t, control.Paused())
+
+	waited := make(chan error)
+	go func() {
+		waited <- control.Wait(context.Background())
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("wait returned while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	control.Resume()
+	select {
+	case err := <-waited:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("wait did not return after resume")
+	}
+}
+
+func TestControlPauseCancelled(t *testing.T) {
+	control := NewControl()
+	control.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, control.Wait(ctx))
+}
+
+func TestControlRate(t *testing.T) {
+	control := NewControl()
+	control.SetRate(100)
+	assert.Equal(t, 100, control.Rate())
+
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		require.NoError(t, control.Wait(context.Background()))
+	}
+	// the first probe is sent immediately, and the next 10 are spread over 100ms
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+}
+
+func TestControlRateRaised(t *testing.T) {
+	control := NewControl()
+	control.SetRate(1)
+
+	// at 1/s, 20 waiting probes would be reserved slots up to 20s away
+	done := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		go func() {
+			assert.NoError(t, control.Wait(context.Background()))
+			done <- struct{}{}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	control.SetRate(1000)
+	for i := 0; i < 20; i++ {
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("probes still waiting at the old rate")
+		}
+	}
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestControlWorkers(t *testing.T) {
+	control := NewControl()
+	control.SetWorkers(1)
+	require.NoError(t, control.Acquire(context.Background()))
+	assert.Equal(t, 1, control.Active())
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- control.Acquire(context.Background())
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired more workers than the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	control.Release()
+	select {
+	case err := <-acquired:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("worker was not released")
+	}
+	control.Release()
+	assert.Equal(t, 0, control.Active())
+}
+
+func TestControlTimeout(t *testing.T) {
+	scanner := newConnectScanner(Options{Timeout: time.Second})
+	assert.Equal(t, time.Second, scanner.currentTimeout(scanner.probeTimeout()))
+
+	control := NewControl()
+	scanner.SetControl(control)
+	control.SetTimeout(250 * time.Millisecond)
+	assert.Equal(t, 250*time.Millisecond, scanner.currentTimeout(scanner.probeTimeout()))
+	control.SetTimeout(0)
+	assert.Equal(t, time.Second, scanner.currentTimeout(scanner.probeTimeout()))
+}
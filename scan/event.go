@@ -0,0 +1,118 @@
+package scan
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened during a scan.
+type EventType string
+
+// Event types.
+const (
+	// EventProbeSent is emitted when a probe (a SYN packet, connection attempt or discovery request) is sent.
+	EventProbeSent EventType = "probe-sent"
+	// EventRetransmit is emitted when a probe which received no response is sent again.
+	EventRetransmit EventType = "retransmit"
+	// EventPortState is emitted when the state of a port is discovered.
+	EventPortState EventType = "port-state"
+	// EventHostUp is emitted when the first response is received from a host.
+	EventHostUp EventType = "host-up"
+	// EventHostTimeout is emitted when a host is complete without having responded to any probe.
+	EventHostTimeout EventType = "host-timeout"
+	// EventError is emitted when a host or probe could not be scanned.
+	EventError EventType = "error"
+)
+
+// Reasons given for a port state, matching those used by nmap.
+const (
+	ReasonSynAck      = "syn-ack"
+	ReasonReset       = "reset"
+	ReasonConnRefused = "conn-refused"
+	ReasonNoResponse  = "no-response"
+)
+
+// Event is something which happened during a scan. Fields which are not relevant to the type of event are left
+// empty.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Host     net.IP
+	Port     int
+	Protocol string
+	State    PortState
+	Reason   string
+	// TTL is the time-to-live of the response which revealed a port state, where the scanner can see it
+	TTL uint8
+	Err error
+}
+
+// String describes the event for logging.
+func (e Event) String() string {
+	switch e.Type {
+	case EventProbeSent, EventRetransmit:
+		if e.Port > 0 {
+			return fmt.Sprintf("%s %s:%d/%s", e.Type, e.Host, e.Port, e.Protocol)
+		}
+		return fmt.Sprintf("%s %s", e.Type, e.Host)
+	case EventPortState:
+		text := fmt.Sprintf("%s %s:%d/%s %s (%s", e.Type, e.Host, e.Port, e.Protocol, e.State, e.Reason)
+		if e.TTL > 0 {
+			text = fmt.Sprintf("%s, ttl %d", text, e.TTL)
+		}
+		return text + ")"
+	case EventError:
+		if e.Port > 0 {
+			return fmt.Sprintf("%s %s:%d/%s: %s", e.Type, e.Host, e.Port, e.Protocol, e.Err)
+		}
+		return fmt.Sprintf("%s %s: %s", e.Type, e.Host, e.Err)
+	}
+	return fmt.Sprintf("%s %s", e.Type, e.Host)
+}
+
+// EventSink receives events as they happen. Scanners never call a sink concurrently, but it is called from the
+// scanning goroutines, so it should return quickly.
+type EventSink func(event Event)
+
+// eventEmitter is embedded by scanners to give them an optional event sink.
+type eventEmitter struct {
+	mutex sync.Mutex
+	sink  EventSink
+}
+
+// SetEventSink sets the sink which receives events from the scanner. A nil sink disables events.
+func (e *eventEmitter) SetEventSink(sink EventSink) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.sink = sink
+}
+
+func (e *eventEmitter) emit(event Event) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.sink == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	e.sink(event)
+}
+
+// emitError emits an error event, ignoring nil errors.
+func (e *eventEmitter) emitError(host net.IP, port int, err error) {
+	if err == nil {
+		return
+	}
+	event := Event{Type: EventError, Host: host, Port: port, Err: err}
+	if port > 0 {
+		event.Protocol = "tcp"
+	}
+	e.emit(event)
+}
+
+func (e *eventEmitter) emitPortState(host net.IP, port int, state PortState, reason string, ttl uint8) {
+	e.emit(Event{Type: EventPortState, Host: host, Port: port, Protocol: "tcp", State: state, Reason: reason, TTL: ttl})
+}
@@ -0,0 +1,14 @@
+package scan
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventString(t *testing.T) {
+	host := net.ParseIP("10.0.0.1")
+	assert.Equal(t, "port-state 10.0.0.1:22/tcp open (syn-ack, ttl 64)", Event{Type: EventPortState, Host: host, Port: 22, Protocol: "tcp", State: PortOpen, Reason: ReasonSynAck, TTL: 64}.String())
+	assert.Equal(t, "host-timeout 10.0.0.1", Event{Type: EventHostTimeout, Host: host}.String())
+}
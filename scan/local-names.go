@@ -0,0 +1,282 @@
+package scan
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// AdvertisedService is a service instance a device advertises via DNS service discovery, e.g. a printer or a
+// Chromecast.
+type AdvertisedService struct {
+	Instance string `json:"instance"`
+	Type     string `json:"type"`
+	Port     int    `json:"port,omitempty"`
+}
+
+// LocalNames holds the names and services a LAN device reports about itself via link-local name resolution
+// protocols.
+type LocalNames struct {
+	NetBIOSName      string
+	NetBIOSWorkgroup string
+	NetBIOSMAC       string
+	MDNSName         string
+	LLMNRName        string
+	Services         []AdvertisedService
+}
+
+const (
+	netbiosPort = 137
+	mdnsPort    = 5353
+	llmnrPort   = 5355
+
+	netbiosTypeNBSTAT = 0x21
+	netbiosGroupFlag  = 0x8000
+
+	dnsServiceEnumeration = "_services._dns-sd._udp.local"
+)
+
+// ResolveLocalNames queries the host using NetBIOS node status, multicast DNS (reverse lookup and DNS-SD service
+// enumeration) and LLMNR, concurrently.
+func ResolveLocalNames(host net.IP, timeout time.Duration) LocalNames {
+	names := LocalNames{}
+	wg := &sync.WaitGroup{}
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+		names.NetBIOSName, names.NetBIOSWorkgroup, names.NetBIOSMAC, _ = queryNetBIOSNodeStatus(hostPort(host, netbiosPort), timeout)
+	}()
+
+	go func() {
+		defer wg.Done()
+		names.MDNSName, names.Services, _ = queryMDNS(host, hostPort(host, mdnsPort), timeout)
+	}()
+
+	go func() {
+		defer wg.Done()
+		names.LLMNRName, _ = queryLLMNR(host, hostPort(host, llmnrPort), timeout)
+	}()
+
+	wg.Wait()
+	return names
+}
+
+// Name returns the best available name, preferring fully qualified names.
+func (n LocalNames) Name() string {
+	for _, name := range []string{n.MDNSName, n.LLMNRName, n.NetBIOSName} {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func hostPort(host net.IP, port int) string {
+	return net.JoinHostPort(host.String(), fmt.Sprintf("%d", port))
+}
+
+// exchangeUDP sends a single datagram to addr and waits for a single reply
+func exchangeUDP(addr string, request []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, 9000)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return buffer[:n], nil
+}
+
+// queryNetBIOSNodeStatus returns the computer name, workgroup and MAC address from a NetBIOS node status response
+func queryNetBIOSNodeStatus(addr string, timeout time.Duration) (string, string, string, error) {
+
+	request := make([]byte, 12)
+	binary.BigEndian.PutUint16(request, uint16(rand.Intn(0xffff)))
+	binary.BigEndian.PutUint16(request[4:], 1)
+	request = append(request, encodeNetBIOSName("*", 0x00)...)
+	request = append(request, 0, netbiosTypeNBSTAT, 0, 1)
+
+	response, err := exchangeUDP(addr, request, timeout)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	malformed := errors.New("malformed NetBIOS node status response")
+
+	// skip header, then the (uncompressed) answer name, type, class, ttl and rdlength
+	offset := 12
+	for offset < len(response) && response[offset] != 0 {
+		offset += int(response[offset]) + 1
+	}
+	offset += 1 + 10
+	if offset >= len(response) {
+		return "", "", "", malformed
+	}
+
+	count := int(response[offset])
+	offset++
+	if len(response) < offset+count*18 {
+		return "", "", "", malformed
+	}
+
+	name, workgroup := "", ""
+	for i := 0; i < count; i++ {
+		entry := response[offset+i*18 : offset+(i+1)*18]
+		value := strings.TrimRight(string(entry[:15]), " \x00")
+		suffix := entry[15]
+		group := binary.BigEndian.Uint16(entry[16:])&netbiosGroupFlag > 0
+		if suffix != 0x00 {
+			continue
+		}
+		if group && workgroup == "" {
+			workgroup = value
+		} else if !group && name == "" {
+			name = value
+		}
+	}
+
+	mac := ""
+	offset += count * 18
+	if len(response) >= offset+6 {
+		hw := net.HardwareAddr(response[offset : offset+6])
+		if hw.String() != "00:00:00:00:00:00" {
+			mac = hw.String()
+		}
+	}
+
+	return name, workgroup, mac, nil
+}
+
+// reverseName returns the in-addr.arpa/ip6.arpa name for reverse lookups of the given address
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	nibbles := []string{}
+	for i := len(ip) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x.%x", ip[i]&0x0f, ip[i]>>4))
+	}
+	return strings.Join(nibbles, ".") + ".ip6.arpa"
+}
+
+// queryDNS sends a single question to addr, as used by both unicast mDNS and LLMNR, and returns all records in
+// the response
+func queryDNS(addr string, name string, recordType layers.DNSType, timeout time.Duration) ([]layers.DNSResourceRecord, error) {
+
+	query := &layers.DNS{
+		ID: uint16(rand.Intn(0xffff)),
+		Questions: []layers.DNSQuestion{
+			{
+				Name:  []byte(name),
+				Type:  recordType,
+				Class: layers.DNSClassIN,
+			},
+		},
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := query.SerializeTo(buffer, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		return nil, err
+	}
+
+	data, err := exchangeUDP(addr, buffer.Bytes(), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &layers.DNS{}
+	if err := response.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return nil, err
+	}
+	if !response.QR || response.ID != query.ID && response.ID != 0 {
+		return nil, errors.New("unexpected DNS response")
+	}
+
+	records := append([]layers.DNSResourceRecord{}, response.Answers...)
+	return append(records, response.Additionals...), nil
+}
+
+func firstPTR(records []layers.DNSResourceRecord, name string) string {
+	for _, record := range records {
+		if record.Type == layers.DNSTypePTR && strings.EqualFold(string(record.Name), name) {
+			return strings.TrimSuffix(string(record.PTR), ".")
+		}
+	}
+	return ""
+}
+
+// queryMDNS performs a unicast multicast-DNS reverse lookup of the host, and enumerates the services it advertises
+func queryMDNS(host net.IP, addr string, timeout time.Duration) (string, []AdvertisedService, error) {
+
+	records, err := queryDNS(addr, reverseName(host), layers.DNSTypePTR, timeout)
+	if err != nil {
+		return "", nil, err
+	}
+	name := firstPTR(records, reverseName(host))
+
+	records, err = queryDNS(addr, dnsServiceEnumeration, layers.DNSTypePTR, timeout)
+	if err != nil {
+		return name, nil, nil
+	}
+
+	types := []string{}
+	for _, record := range records {
+		if record.Type == layers.DNSTypePTR && strings.EqualFold(string(record.Name), dnsServiceEnumeration) {
+			types = append(types, strings.TrimSuffix(string(record.PTR), "."))
+		}
+	}
+	sort.Strings(types)
+
+	services := []AdvertisedService{}
+	for _, serviceType := range types {
+		records, err := queryDNS(addr, serviceType, layers.DNSTypePTR, timeout)
+		if err != nil {
+			continue
+		}
+		for _, record := range records {
+			if record.Type != layers.DNSTypePTR || !strings.EqualFold(string(record.Name), serviceType) {
+				continue
+			}
+			instance := strings.TrimSuffix(string(record.PTR), ".")
+			service := AdvertisedService{
+				Instance: strings.TrimSuffix(instance, "."+serviceType),
+				Type:     strings.TrimSuffix(serviceType, ".local"),
+			}
+			for _, srv := range records {
+				if srv.Type == layers.DNSTypeSRV && strings.EqualFold(strings.TrimSuffix(string(srv.Name), "."), instance) {
+					service.Port = int(srv.SRV.Port)
+				}
+			}
+			services = append(services, service)
+		}
+	}
+
+	return name, services, nil
+}
+
+// queryLLMNR performs a unicast LLMNR reverse lookup of the host
+func queryLLMNR(host net.IP, addr string, timeout time.Duration) (string, error) {
+	records, err := queryDNS(addr, reverseName(host), layers.DNSTypePTR, timeout)
+	if err != nil {
+		return "", err
+	}
+	return firstPTR(records, reverseName(host)), nil
+}
@@ -0,0 +1,140 @@
+package scan
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveUDP answers each datagram received with whatever respond returns
+func serveUDP(t *testing.T, respond func(request []byte) []byte) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		buffer := make([]byte, 9000)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if response := respond(buffer[:n]); response != nil {
+				_, _ = conn.WriteTo(response, addr)
+			}
+		}
+	}()
+
+	return conn
+}
+
+func TestNetBIOSNodeStatus(t *testing.T) {
+	conn := serveUDP(t, func(request []byte) []byte {
+		response := append([]byte{}, request[:2]...)
+		response = append(response, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0)
+		response = append(response, encodeNetBIOSName("*", 0x00)...)
+		response = append(response, 0, netbiosTypeNBSTAT, 0, 1, 0, 0, 0, 0)
+
+		entries := []byte{3}
+		for _, entry := range []struct {
+			name   string
+			suffix byte
+			flags  uint16
+		}{
+			{"OFFICE-PC", 0x00, 0x0400},
+			{"WORKGROUP", 0x00, 0x8400},
+			{"OFFICE-PC", 0x20, 0x0400},
+		} {
+			name := []byte(entry.name)
+			for len(name) < 15 {
+				name = append(name, ' ')
+			}
+			entries = append(entries, name...)
+			entries = append(entries, entry.suffix, byte(entry.flags>>8), byte(entry.flags))
+		}
+		entries = append(entries, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55)
+
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(entries)))
+		return append(append(response, length...), entries...)
+	})
+	defer conn.Close()
+
+	name, workgroup, mac, err := queryNetBIOSNodeStatus(conn.LocalAddr().String(), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "OFFICE-PC", name)
+	assert.Equal(t, "WORKGROUP", workgroup)
+	assert.Equal(t, "00:11:22:33:44:55", mac)
+}
+
+func serveDNS(t *testing.T, records map[string][]layers.DNSResourceRecord) net.PacketConn {
+	return serveUDP(t, func(request []byte) []byte {
+		query := &layers.DNS{}
+		if err := query.DecodeFromBytes(request, gopacket.NilDecodeFeedback); err != nil || len(query.Questions) == 0 {
+			return nil
+		}
+		response := &layers.DNS{
+			ID:        query.ID,
+			QR:        true,
+			AA:        true,
+			Questions: query.Questions,
+			Answers:   records[string(query.Questions[0].Name)],
+		}
+		buffer := gopacket.NewSerializeBuffer()
+		if err := response.SerializeTo(buffer, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			return nil
+		}
+		return buffer.Bytes()
+	})
+}
+
+func ptr(name string, value string) layers.DNSResourceRecord {
+	return layers.DNSResourceRecord{Name: []byte(name), Type: layers.DNSTypePTR, Class: layers.DNSClassIN, PTR: []byte(value)}
+}
+
+func TestMDNSLookup(t *testing.T) {
+	host := net.ParseIP("192.168.1.20")
+	conn := serveDNS(t, map[string][]layers.DNSResourceRecord{
+		"20.1.168.192.in-addr.arpa": {ptr("20.1.168.192.in-addr.arpa", "Living-Room.local")},
+		dnsServiceEnumeration: {
+			ptr(dnsServiceEnumeration, "_googlecast._tcp.local"),
+			ptr(dnsServiceEnumeration, "_airplay._tcp.local"),
+		},
+		"_googlecast._tcp.local": {
+			ptr("_googlecast._tcp.local", "Living Room TV._googlecast._tcp.local"),
+			{
+				Name:  []byte("Living Room TV._googlecast._tcp.local"),
+				Type:  layers.DNSTypeSRV,
+				Class: layers.DNSClassIN,
+				SRV:   layers.DNSSRV{Port: 8009, Name: []byte("Living-Room.local")},
+			},
+		},
+		"_airplay._tcp.local": {ptr("_airplay._tcp.local", "Living Room._airplay._tcp.local")},
+	})
+	defer conn.Close()
+
+	name, services, err := queryMDNS(host, conn.LocalAddr().String(), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "Living-Room.local", name)
+	assert.Equal(t, []AdvertisedService{
+		{Instance: "Living Room", Type: "_airplay._tcp"},
+		{Instance: "Living Room TV", Type: "_googlecast._tcp", Port: 8009},
+	}, services)
+}
+
+func TestLLMNRLookup(t *testing.T) {
+	host := net.ParseIP("10.0.0.5")
+	conn := serveDNS(t, map[string][]layers.DNSResourceRecord{
+		"5.0.0.10.in-addr.arpa": {ptr("5.0.0.10.in-addr.arpa", "desktop-42")},
+	})
+	defer conn.Close()
+
+	name, err := queryLLMNR(host, conn.LocalAddr().String(), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "desktop-42", name)
+}
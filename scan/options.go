@@ -0,0 +1,133 @@
+package scan
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Defaults used for options which are left at their zero value.
+const (
+	DefaultTimeout     = 2 * time.Second
+	DefaultParallelism = 500
+)
+
+// Options configures a scanner created by New. Fields left at their zero value use a sensible default, so new
+// fields can be added without breaking callers.
+type Options struct {
+	// Type is the scan type: "syn" (or its aliases "stealth" and "fast"), "connect", "device" or "upnp".
+	Type string
+	// Timeout is how long to wait for a reply to each probe. Defaults to DefaultTimeout. If MinRTTTimeout or
+	// MaxRTTTimeout are set, this is the initial timeout, which then adapts to the round trip times measured.
+	Timeout time.Duration
+	// MinRTTTimeout and MaxRTTTimeout bound the timeout as it adapts. Each defaults to Timeout.
+	MinRTTTimeout time.Duration
+	MaxRTTTimeout time.Duration
+	// ScanDelay is the minimum time between probes.
+	ScanDelay time.Duration
+	// Parallelism is the number of workers used by syn and connect scans. Defaults to DefaultParallelism.
+	Parallelism int
+	// HostGroupSize is the number of hosts scanned at once by syn, connect and device scans. UPnP scans search every
+	// target at once. Defaults to 256.
+	HostGroupSize int
+	// Retries is the number of times a probe which gets no reply is sent again.
+	Retries int
+	// Rate limits the number of probes sent per second. Zero means no limit.
+	Rate int
+	// Interface is the name of the network interface syn probes are sent from, instead of the one chosen by the
+	// routing table.
+	Interface string
+	// SourcePort is the TCP source port of syn probes. Zero picks a free port for each host.
+	SourcePort int
+	// OSDetection enables active OS fingerprinting of hosts with an open port. Only supported by syn scans.
+	OSDetection bool
+	// EventSink optionally receives fine-grained events as each scan progresses.
+	EventSink EventSink
+	// Control optionally pauses, resumes and throttles scans while they run. If Rate is set, it is applied to the
+	// control.
+	Control *Control
+}
+
+// withDefaults returns a copy of the options with defaults filled in.
+func (o Options) withDefaults() Options {
+	o.Type = strings.ToLower(o.Type)
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.MinRTTTimeout <= 0 {
+		o.MinRTTTimeout = o.Timeout
+	}
+	if o.MaxRTTTimeout <= 0 {
+		o.MaxRTTTimeout = o.Timeout
+	}
+	if o.MaxRTTTimeout < o.MinRTTTimeout {
+		o.MaxRTTTimeout = o.MinRTTTimeout
+	}
+	if o.Parallelism <= 0 {
+		o.Parallelism = DefaultParallelism
+	}
+	if o.HostGroupSize <= 0 {
+		o.HostGroupSize = defaultHostGroupSize
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.Rate > 0 {
+		if o.Control == nil {
+			o.Control = NewControl()
+		}
+		o.Control.SetRate(o.Rate)
+	}
+	return o
+}
+
+// New creates a scanner of the type given in options. Scanners are started before being returned, can run Scan as
+// many times as needed, and should be stopped with Stop once they are no longer needed.
+func New(options Options) (Scanner, error) {
+
+	options = options.withDefaults()
+
+	if options.OSDetection && !isSynType(options.Type) {
+		return nil, fmt.Errorf("OS detection is only supported for syn scans")
+	}
+	if (options.Interface != "" || options.SourcePort != 0) && !isSynType(options.Type) {
+		return nil, fmt.Errorf("an interface and source port can only be set for syn scans")
+	}
+	if options.SourcePort < 0 || options.SourcePort > 65535 {
+		return nil, fmt.Errorf("invalid source port %d", options.SourcePort)
+	}
+
+	var scanner Scanner
+	switch {
+	case isSynType(options.Type):
+		scanner = newSynScanner(options)
+	case options.Type == "connect":
+		scanner = newConnectScanner(options)
+	case options.Type == "device":
+		scanner = newDeviceScanner(options)
+	case options.Type == "upnp":
+		scanner = newUPnPScanner(options)
+	default:
+		return nil, fmt.Errorf("unknown scan type '%s'", options.Type)
+	}
+
+	if err := scanner.Start(); err != nil {
+		return nil, err
+	}
+	return scanner, nil
+}
+
+// isSynType reports whether the scan type is a syn scan, or one of its aliases
+func isSynType(scanType string) bool {
+	switch strings.ToLower(scanType) {
+	case "syn", "stealth", "fast":
+		return true
+	}
+	return false
+}
+
+// configure applies the options shared by every scanner
+func (o Options) configure(emitter *eventEmitter, control *controllable) {
+	emitter.SetEventSink(o.EventSink)
+	control.SetControl(o.Control)
+}
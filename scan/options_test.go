@@ -0,0 +1,47 @@
+package scan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	scanner, err := New(Options{Type: "Connect"})
+	require.NoError(t, err)
+	defer scanner.Stop()
+	connect, ok := scanner.(*ConnectScanner)
+	require.True(t, ok)
+	assert.Equal(t, DefaultTimeout, connect.probeTimeout())
+	assert.Equal(t, DefaultParallelism, connect.maxRoutines)
+	assert.Equal(t, defaultHostGroupSize, connect.hostGroupSize)
+
+	syn := newSynScanner(Options{Type: "stealth", Timeout: time.Second, Retries: 2, SourcePort: 40000})
+	assert.Equal(t, time.Second, syn.probeTimeout())
+	assert.Equal(t, 2, syn.retries)
+	assert.Equal(t, 40000, syn.sourcePort)
+
+	for _, options := range []Options{
+		{Type: "ping"},
+		{Type: "connect", OSDetection: true},
+		{Type: "connect", SourcePort: 40000},
+		{Type: "upnp", Interface: "eth0"},
+		{Type: "syn", SourcePort: 70000},
+	} {
+		_, err := New(options)
+		assert.Error(t, err, options)
+	}
+}
+
+func TestNewAppliesRate(t *testing.T) {
+	scanner := newConnectScanner(Options{Rate: 100})
+	require.NotNil(t, scanner.control)
+	assert.Equal(t, 100, scanner.control.Rate())
+
+	control := NewControl()
+	scanner = newConnectScanner(Options{Rate: 50, Control: control})
+	assert.Equal(t, control, scanner.control)
+	assert.Equal(t, 50, control.Rate())
+}
@@ -0,0 +1,640 @@
+package scan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/phayes/freeport"
+)
+
+// OSFingerprint describes the behaviour of a host's TCP/IP stack as observed by active OS detection.
+type OSFingerprint struct {
+	IPIDSequence   string
+	ISNGCD         uint32
+	ISNRate        float64
+	ISNSequence    string
+	TimestampRate  int
+	InitialTTL     uint8
+	Window         uint16
+	Options        string
+	DontFragment   bool
+	ECN            bool
+	ICMPEchoCode   string
+	UDPUnreachable bool
+	Quirks         []string
+	Matches        []OSMatch
+}
+
+// OSMatch is a candidate operating system for a fingerprint, along with how closely it matched.
+type OSMatch struct {
+	Name     string `json:"name"`
+	Accuracy int    `json:"accuracy"`
+}
+
+// IP ID sequence classes.
+const (
+	IPIDZero             = "Z"
+	IPIDConstant         = "C"
+	IPIDIncremental      = "I"
+	IPIDBrokenIncrement  = "BI"
+	IPIDRandomIncrements = "RI"
+	IPIDRandom           = "RD"
+)
+
+// Initial sequence number classes.
+const (
+	ISNConstant    = "C"
+	ISNIncremental = "I"
+	ISNRandom      = "RD"
+)
+
+// isnRandomRate is the ISN rate per second above which sequence numbers are treated as random. Random ISNs from
+// probes osProbeInterval apart differ by around 1e10 per second, while clock based ISNs advance far slower.
+const isnRandomRate = 1e8
+
+// Timestamp rate values which do not represent a frequency.
+const (
+	TimestampUnsupported = -1
+	TimestampZero        = 0
+)
+
+const (
+	osProbeInterval = 100 * time.Millisecond
+	osICMPCode      = 9
+	osICMPSequence  = 295
+	osUDPIPID       = 0x1042
+)
+
+type osProbe struct {
+	name   string
+	closed bool
+	window uint16
+	flags  string
+	df     bool
+	urgent uint16
+	opts   []layers.TCPOption
+}
+
+func tcpMSS(mss uint16) layers.TCPOption {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, mss)
+	return layers.TCPOption{OptionType: layers.TCPOptionKindMSS, OptionData: data}
+}
+
+func tcpWindowScale(shift byte) layers.TCPOption {
+	return layers.TCPOption{OptionType: layers.TCPOptionKindWindowScale, OptionData: []byte{shift}}
+}
+
+func tcpTimestamp() layers.TCPOption {
+	return layers.TCPOption{OptionType: layers.TCPOptionKindTimestamps, OptionData: []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}}
+}
+
+var (
+	tcpNop           = layers.TCPOption{OptionType: layers.TCPOptionKindNop}
+	tcpEOL           = layers.TCPOption{OptionType: layers.TCPOptionKindEndList}
+	tcpSACKPermitted = layers.TCPOption{OptionType: layers.TCPOptionKindSACKPermitted}
+)
+
+// the SEQ probes are sent to an open port at fixed intervals so that sequence generation can be measured, and each
+// carries a different set of TCP options to see how the stack orders and echoes them
+var osSequenceProbes = []osProbe{
+	{name: "SEQ1", window: 1, flags: "S", opts: []layers.TCPOption{tcpWindowScale(10), tcpNop, tcpMSS(1460), tcpTimestamp(), tcpSACKPermitted}},
+	{name: "SEQ2", window: 63, flags: "S", opts: []layers.TCPOption{tcpMSS(1400), tcpWindowScale(0), tcpSACKPermitted, tcpTimestamp(), tcpEOL}},
+	{name: "SEQ3", window: 4, flags: "S", opts: []layers.TCPOption{tcpTimestamp(), tcpNop, tcpNop, tcpWindowScale(5), tcpNop, tcpMSS(640)}},
+	{name: "SEQ4", window: 4, flags: "S", opts: []layers.TCPOption{tcpSACKPermitted, tcpTimestamp(), tcpWindowScale(10), tcpEOL}},
+	{name: "SEQ5", window: 16, flags: "S", opts: []layers.TCPOption{tcpMSS(536), tcpSACKPermitted, tcpTimestamp(), tcpWindowScale(10), tcpEOL}},
+	{name: "SEQ6", window: 512, flags: "S", opts: []layers.TCPOption{tcpMSS(265), tcpSACKPermitted, tcpTimestamp()}},
+}
+
+// the remaining TCP probes use unusual flag combinations against both the open and closed port
+var osTCPProbes = []osProbe{
+	{name: "ECN", window: 3, flags: "SEC", urgent: 0xf7f5, opts: []layers.TCPOption{tcpWindowScale(10), tcpNop, tcpMSS(1460), tcpSACKPermitted, tcpNop, tcpNop}},
+	{name: "T2", window: 128, df: true, opts: []layers.TCPOption{tcpWindowScale(10), tcpNop, tcpMSS(265), tcpTimestamp(), tcpSACKPermitted}},
+	{name: "T3", window: 256, flags: "SFUP", opts: []layers.TCPOption{tcpWindowScale(10), tcpNop, tcpMSS(265), tcpTimestamp(), tcpSACKPermitted}},
+	{name: "T4", window: 1024, flags: "A", df: true, opts: []layers.TCPOption{tcpWindowScale(10), tcpNop, tcpMSS(265), tcpTimestamp(), tcpSACKPermitted}},
+	{name: "T5", closed: true, window: 31337, flags: "S", opts: []layers.TCPOption{tcpWindowScale(10), tcpNop, tcpMSS(265), tcpTimestamp(), tcpSACKPermitted}},
+	{name: "T6", closed: true, window: 32768, flags: "A", df: true, opts: []layers.TCPOption{tcpWindowScale(10), tcpNop, tcpMSS(265), tcpTimestamp(), tcpSACKPermitted}},
+	{name: "T7", closed: true, window: 65535, flags: "FPU", opts: []layers.TCPOption{tcpWindowScale(15), tcpNop, tcpMSS(265), tcpTimestamp(), tcpSACKPermitted}},
+}
+
+type osReply struct {
+	sent     time.Time
+	received time.Time
+	ip       layers.IPv4
+	tcp      *layers.TCP
+	icmp     *layers.ICMPv4
+}
+
+// fingerprintOS sends a series of crafted probes to an open and a closed port on the target and matches the
+// responses against the known fingerprint database. If closedPort is zero, a random high port is used.
+func (s *SynScanner) fingerprintOS(target net.IP, networkInterface *net.Interface, srcIP net.IP, hwaddr net.HardwareAddr, openPort int, closedPort int) (*OSFingerprint, error) {
+
+	if closedPort == 0 {
+		closedPort = 30000 + rand.Intn(30000)
+		if closedPort == openPort {
+			closedPort++
+		}
+	}
+
+	handle, err := pcap.OpenLive(networkInterface.Name, 65535, true, pcap.BlockForever)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	basePort, err := freeport.GetFreePort()
+	if err != nil {
+		return nil, err
+	}
+	if basePort > 65535-len(osSequenceProbes)-len(osTCPProbes) {
+		basePort -= len(osSequenceProbes) + len(osTCPProbes)
+	}
+
+	eth := layers.Ethernet{
+		SrcMAC:       networkInterface.HardwareAddr,
+		DstMAC:       hwaddr,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+
+	icmpID := uint16(rand.Intn(0xffff))
+
+	// probeNames maps the source port of each TCP probe to its name. It is filled before the listener starts and
+	// never written afterwards, so it can be read without the lock.
+	probeNames := map[layers.TCPPort]string{}
+	for i, probe := range osSequenceProbes {
+		probeNames[layers.TCPPort(basePort+i)] = probe.name
+	}
+	for i, probe := range osTCPProbes {
+		probeNames[layers.TCPPort(basePort+len(osSequenceProbes)+i)] = probe.name
+	}
+
+	replies := map[string]*osReply{}
+	lock := sync.Mutex{}
+
+	record := func(name string) {
+		lock.Lock()
+		defer lock.Unlock()
+		replies[name] = &osReply{sent: time.Now()}
+	}
+
+	listenChan := make(chan struct{})
+	ipFlow := gopacket.NewFlow(layers.EndpointIPv4, target, srcIP)
+
+	go func() {
+		defer close(listenChan)
+		for {
+			data, _, err := handle.ReadPacketData()
+			if err == pcap.NextErrorTimeoutExpired {
+				continue
+			} else if err != nil {
+				return
+			}
+
+			packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.NoCopy)
+			ipLayer, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+			if !ok || ipLayer.NetworkFlow() != ipFlow {
+				continue
+			}
+
+			name := ""
+			reply := osReply{received: time.Now(), ip: *ipLayer}
+
+			if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+				if name, ok = probeNames[tcp.DstPort]; !ok {
+					continue
+				}
+				reply.tcp = tcp
+			} else if icmp, ok := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4); ok {
+				switch icmp.TypeCode.Type() {
+				case layers.ICMPv4TypeEchoReply:
+					if icmp.Id != icmpID {
+						continue
+					}
+					name = "IE"
+				case layers.ICMPv4TypeDestinationUnreachable:
+					if icmp.TypeCode.Code() != layers.ICMPv4CodePort {
+						continue
+					}
+					name = "U1"
+				default:
+					continue
+				}
+				reply.icmp = icmp
+			} else {
+				continue
+			}
+
+			lock.Lock()
+			if existing, ok := replies[name]; ok && existing.received.IsZero() {
+				reply.sent = existing.sent
+				replies[name] = &reply
+			}
+			lock.Unlock()
+		}
+	}()
+
+	ip4 := layers.IPv4{
+		SrcIP:    srcIP,
+		DstIP:    target,
+		Version:  4,
+		TTL:      255,
+		Protocol: layers.IPProtocolTCP,
+	}
+
+	sendTCP := func(index int, probe osProbe) error {
+		port := openPort
+		if probe.closed {
+			port = closedPort
+		}
+		srcPort := layers.TCPPort(basePort + index)
+
+		ip := ip4
+		ip.Id = uint16(rand.Intn(0xffff))
+		if probe.df {
+			ip.Flags = layers.IPv4DontFragment
+		}
+		tcp := layers.TCP{
+			SrcPort: srcPort,
+			DstPort: layers.TCPPort(port),
+			Seq:     rand.Uint32(),
+			Window:  probe.window,
+			Urgent:  probe.urgent,
+			Options: probe.opts,
+		}
+		for _, flag := range probe.flags {
+			switch flag {
+			case 'S':
+				tcp.SYN = true
+			case 'A':
+				tcp.ACK = true
+			case 'F':
+				tcp.FIN = true
+			case 'P':
+				tcp.PSH = true
+			case 'U':
+				tcp.URG = true
+			case 'E':
+				tcp.ECE = true
+			case 'C':
+				tcp.CWR = true
+			}
+		}
+		tcp.SetNetworkLayerForChecksum(&ip)
+		record(probe.name)
+		return s.send(handle, &eth, &ip, &tcp)
+	}
+
+	for i, probe := range osSequenceProbes {
+		if err := sendTCP(i, probe); err != nil {
+			return nil, err
+		}
+		time.Sleep(osProbeInterval)
+	}
+
+	for i, probe := range osTCPProbes {
+		if err := sendTCP(len(osSequenceProbes)+i, probe); err != nil {
+			return nil, err
+		}
+	}
+
+	echoIP := ip4
+	echoIP.Protocol = layers.IPProtocolICMPv4
+	echoIP.Flags = layers.IPv4DontFragment
+	echoIP.Id = uint16(rand.Intn(0xffff))
+	echo := layers.ICMPv4{
+		TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, osICMPCode),
+		Id:       icmpID,
+		Seq:      osICMPSequence,
+	}
+	record("IE")
+	if err := s.send(handle, &eth, &echoIP, &echo, gopacket.Payload(make([]byte, 120))); err != nil {
+		return nil, err
+	}
+
+	udpIP := ip4
+	udpIP.Protocol = layers.IPProtocolUDP
+	udpIP.Id = osUDPIPID
+	udp := layers.UDP{
+		SrcPort: layers.UDPPort(basePort),
+		DstPort: layers.UDPPort(closedPort),
+	}
+	udp.SetNetworkLayerForChecksum(&udpIP)
+	payload := make([]byte, 300)
+	for i := range payload {
+		payload[i] = 'C'
+	}
+	record("U1")
+	if err := s.send(handle, &eth, &udpIP, &udp, gopacket.Payload(payload)); err != nil {
+		return nil, err
+	}
+
+	timer := time.AfterFunc(s.currentTimeout(s.probeTimeout()), func() { handle.Close() })
+	defer timer.Stop()
+
+	<-listenChan
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for name, reply := range replies {
+		if reply.received.IsZero() {
+			delete(replies, name)
+		}
+	}
+
+	if len(replies) == 0 {
+		return nil, fmt.Errorf("no responses received to OS detection probes")
+	}
+
+	fingerprint := analyseOSReplies(replies)
+	fingerprint.Matches = matchOSFingerprint(fingerprint)
+	return fingerprint, nil
+}
+
+// analyseOSReplies reduces the raw probe responses to a fingerprint.
+func analyseOSReplies(replies map[string]*osReply) *OSFingerprint {
+
+	fingerprint := &OSFingerprint{
+		TimestampRate: TimestampUnsupported,
+	}
+
+	sequence := []*osReply{}
+	for _, probe := range osSequenceProbes {
+		if reply, ok := replies[probe.name]; ok && reply.tcp != nil && reply.tcp.SYN && reply.tcp.ACK {
+			sequence = append(sequence, reply)
+		}
+	}
+
+	ids := []uint16{}
+	isns := []uint32{}
+	timestamps := []uint32{}
+	tsTimes := []time.Time{}
+	for _, reply := range sequence {
+		ids = append(ids, reply.ip.Id)
+		isns = append(isns, reply.tcp.Seq)
+		if ts, ok := tcpTimestampValue(reply.tcp); ok {
+			timestamps = append(timestamps, ts)
+			tsTimes = append(tsTimes, reply.sent)
+		}
+	}
+
+	fingerprint.IPIDSequence = classifyIPIDs(ids)
+	if len(sequence) > 1 {
+		fingerprint.ISNGCD, fingerprint.ISNRate = isnStatistics(isns, sequence[0].sent, sequence[len(sequence)-1].sent)
+		fingerprint.ISNSequence = classifyISNs(fingerprint.ISNGCD, fingerprint.ISNRate)
+	}
+	if len(timestamps) > 1 {
+		fingerprint.TimestampRate = timestampRate(timestamps, tsTimes[0], tsTimes[len(tsTimes)-1])
+	} else if len(timestamps) == 1 && timestamps[0] == 0 {
+		fingerprint.TimestampRate = TimestampZero
+	}
+
+	var first *osReply
+	if len(sequence) > 0 {
+		first = sequence[0]
+		fingerprint.Window = first.tcp.Window
+		fingerprint.Options = tcpOptionLayout(first.tcp)
+	} else {
+		for _, reply := range replies {
+			first = reply
+			break
+		}
+	}
+	fingerprint.InitialTTL = initialTTL(first.ip.TTL)
+	fingerprint.DontFragment = first.ip.Flags&layers.IPv4DontFragment > 0
+
+	if reply, ok := replies["ECN"]; ok && reply.tcp != nil {
+		fingerprint.ECN = reply.tcp.SYN && reply.tcp.ECE
+	}
+
+	if reply, ok := replies["IE"]; ok && reply.icmp != nil {
+		switch reply.icmp.TypeCode.Code() {
+		case 0:
+			fingerprint.ICMPEchoCode = "Z"
+		case osICMPCode:
+			fingerprint.ICMPEchoCode = "S"
+		default:
+			fingerprint.ICMPEchoCode = "O"
+		}
+	}
+
+	if reply, ok := replies["U1"]; ok && reply.icmp != nil {
+		fingerprint.UDPUnreachable = true
+		if len(reply.icmp.Payload) < 28 {
+			fingerprint.Quirks = append(fingerprint.Quirks, "udp-quote-truncated")
+		} else if binary.BigEndian.Uint16(reply.icmp.Payload[4:6]) != osUDPIPID {
+			fingerprint.Quirks = append(fingerprint.Quirks, "udp-quote-ipid-changed")
+		}
+	}
+
+	fingerprint.Quirks = append(fingerprint.Quirks, tcpQuirks(replies)...)
+
+	return fingerprint
+}
+
+func tcpQuirks(replies map[string]*osReply) []string {
+	quirks := []string{}
+	seen := map[string]bool{}
+	add := func(quirk string) {
+		if !seen[quirk] {
+			seen[quirk] = true
+			quirks = append(quirks, quirk)
+		}
+	}
+
+	names := []string{}
+	for name := range replies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tcp := replies[name].tcp
+		if tcp == nil {
+			continue
+		}
+		if !tcp.URG && tcp.Urgent != 0 {
+			add("urgent-pointer")
+		}
+		if tcp.NS {
+			add("reserved-bits")
+		}
+		if tcp.RST && len(tcp.Payload) > 0 {
+			add("rst-data")
+		}
+		if !tcp.ACK && tcp.Ack != 0 {
+			add("ack-without-flag")
+		}
+		switch name {
+		case "T2":
+			add("responds-null-flags")
+		case "T3":
+			if tcp.SYN && tcp.ACK {
+				add("synack-to-synfin")
+			}
+		case "T7":
+			if !tcp.RST {
+				add("no-rst-to-fin")
+			}
+		}
+	}
+	return quirks
+}
+
+func tcpTimestampValue(tcp *layers.TCP) (uint32, bool) {
+	for _, opt := range tcp.Options {
+		if opt.OptionType == layers.TCPOptionKindTimestamps && len(opt.OptionData) >= 8 {
+			return binary.BigEndian.Uint32(opt.OptionData[:4]), true
+		}
+	}
+	return 0, false
+}
+
+// tcpOptionLayout summarises the order of TCP options in a reply, e.g. "MSTNW"
+func tcpOptionLayout(tcp *layers.TCP) string {
+	layout := ""
+	for _, opt := range tcp.Options {
+		switch opt.OptionType {
+		case layers.TCPOptionKindEndList:
+			layout += "E"
+		case layers.TCPOptionKindNop:
+			layout += "N"
+		case layers.TCPOptionKindMSS:
+			layout += "M"
+		case layers.TCPOptionKindWindowScale:
+			layout += "W"
+		case layers.TCPOptionKindSACKPermitted:
+			layout += "S"
+		case layers.TCPOptionKindTimestamps:
+			layout += "T"
+		default:
+			layout += "?"
+		}
+	}
+	return layout
+}
+
+func classifyIPIDs(ids []uint16) string {
+	if len(ids) < 2 {
+		return ""
+	}
+
+	zero, constant, broken, small := true, true, true, true
+	for i, id := range ids {
+		if id != 0 {
+			zero = false
+		}
+		if i == 0 {
+			continue
+		}
+		diff := id - ids[i-1]
+		if diff > 20000 {
+			return IPIDRandom
+		}
+		if diff != 0 {
+			constant = false
+		}
+		if diff%256 != 0 || diff > 5120 {
+			broken = false
+		}
+		if diff >= 10 {
+			small = false
+		}
+	}
+
+	switch {
+	case zero:
+		return IPIDZero
+	case constant:
+		return IPIDConstant
+	case broken:
+		return IPIDBrokenIncrement
+	case small:
+		return IPIDIncremental
+	}
+	return IPIDRandomIncrements
+}
+
+// isnStatistics returns the greatest common divisor of the differences between initial sequence numbers, and the
+// average rate at which the sequence number increases per second
+func isnStatistics(isns []uint32, first time.Time, last time.Time) (uint32, float64) {
+	var gcd uint32
+	var total float64
+	for i := 1; i < len(isns); i++ {
+		diff := isns[i] - isns[i-1]
+		if isns[i-1]-isns[i] < diff {
+			diff = isns[i-1] - isns[i]
+		}
+		total += float64(diff)
+		gcd = greatestCommonDivisor(gcd, diff)
+	}
+	elapsed := last.Sub(first).Seconds()
+	if elapsed <= 0 {
+		return gcd, 0
+	}
+	return gcd, total / elapsed
+}
+
+// classifyISNs classifies the initial sequence numbers of replies from their GCD and rate
+func classifyISNs(gcd uint32, rate float64) string {
+	switch {
+	case gcd == 0:
+		return ISNConstant
+	case rate >= isnRandomRate:
+		return ISNRandom
+	default:
+		return ISNIncremental
+	}
+}
+
+func greatestCommonDivisor(a, b uint32) uint32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// timestampRate estimates the frequency of the remote TCP timestamp clock, rounded to a common kernel HZ value
+func timestampRate(values []uint32, first time.Time, last time.Time) int {
+	allZero := true
+	for _, value := range values {
+		if value != 0 {
+			allZero = false
+		}
+	}
+	if allZero {
+		return TimestampZero
+	}
+
+	elapsed := last.Sub(first).Seconds()
+	if elapsed <= 0 {
+		return TimestampUnsupported
+	}
+	rate := float64(values[len(values)-1]-values[0]) / elapsed
+
+	for _, hz := range []int{2, 100, 200, 250, 1000} {
+		if math.Abs(rate-float64(hz)) <= float64(hz)*0.3 {
+			return hz
+		}
+	}
+	return int(math.Round(rate))
+}
+
+// initialTTL rounds an observed TTL up to the most likely initial value
+func initialTTL(ttl uint8) uint8 {
+	for _, initial := range []uint8{32, 64, 128} {
+		if ttl <= initial {
+			return initial
+		}
+	}
+	return 255
+}
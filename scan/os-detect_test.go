@@ -0,0 +1,100 @@
+package scan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPIDClassification(t *testing.T) {
+	assert.Equal(t, IPIDZero, classifyIPIDs([]uint16{0, 0, 0, 0}))
+	assert.Equal(t, IPIDConstant, classifyIPIDs([]uint16{1234, 1234, 1234}))
+	assert.Equal(t, IPIDIncremental, classifyIPIDs([]uint16{100, 101, 103, 104}))
+	assert.Equal(t, IPIDBrokenIncrement, classifyIPIDs([]uint16{256, 512, 1024, 1280}))
+	assert.Equal(t, IPIDRandomIncrements, classifyIPIDs([]uint16{100, 1100, 3000, 4500}))
+	assert.Equal(t, IPIDRandom, classifyIPIDs([]uint16{100, 40000, 2000, 60000}))
+	assert.Equal(t, "", classifyIPIDs([]uint16{100}))
+}
+
+func TestTimestampRate(t *testing.T) {
+	start := time.Now()
+	assert.Equal(t, 1000, timestampRate([]uint32{5000, 5510}, start, start.Add(500*time.Millisecond)))
+	assert.Equal(t, 100, timestampRate([]uint32{5000, 5049}, start, start.Add(500*time.Millisecond)))
+	assert.Equal(t, TimestampZero, timestampRate([]uint32{0, 0}, start, start.Add(500*time.Millisecond)))
+}
+
+func TestOSMatching(t *testing.T) {
+	matches := matchOSFingerprint(&OSFingerprint{
+		IPIDSequence:   IPIDZero,
+		ISNSequence:    ISNRandom,
+		TimestampRate:  1000,
+		InitialTTL:     initialTTL(57),
+		Window:         64240,
+		Options:        "MSTNW",
+		ECN:            true,
+		ICMPEchoCode:   "S",
+		UDPUnreachable: true,
+	})
+	require.NotEmpty(t, matches)
+	assert.Equal(t, "Linux 3.x - 6.x", matches[0].Name)
+	assert.Equal(t, 100, matches[0].Accuracy)
+
+	matches = matchOSFingerprint(&OSFingerprint{
+		IPIDSequence:  IPIDIncremental,
+		TimestampRate: TimestampUnsupported,
+		InitialTTL:    initialTTL(113),
+		Window:        64240,
+		Options:       "MNWNNS",
+		ICMPEchoCode:  "Z",
+	})
+	require.NotEmpty(t, matches)
+	assert.Equal(t, "Microsoft Windows 10 / 11 / Server 2016+", matches[0].Name)
+}
+
+func TestISNClassification(t *testing.T) {
+	assert.Equal(t, ISNConstant, classifyISNs(0, 0))
+	assert.Equal(t, ISNIncremental, classifyISNs(64000, 640000))
+	assert.Equal(t, ISNRandom, classifyISNs(3, 9.8e9))
+}
+
+func TestOSMatchingISNAndQuirks(t *testing.T) {
+	// a TTL of 255 with only an MSS option fits both Cisco IOS and small embedded stacks
+	fingerprint := &OSFingerprint{
+		IPIDSequence:   IPIDIncremental,
+		TimestampRate:  TimestampUnsupported,
+		InitialTTL:     initialTTL(250),
+		Window:         4096,
+		Options:        "M",
+		UDPUnreachable: true,
+	}
+
+	fingerprint.ISNSequence = ISNRandom
+	matches := matchOSFingerprint(fingerprint)
+	require.NotEmpty(t, matches)
+	assert.Equal(t, "Cisco IOS", matches[0].Name)
+
+	fingerprint.ISNSequence = ISNIncremental
+	matches = matchOSFingerprint(fingerprint)
+	require.NotEmpty(t, matches)
+	assert.Equal(t, "Embedded TCP/IP stack (lwIP, uIP or similar)", matches[0].Name)
+
+	// Windows answers a probe with no flags set, where Linux doesn't
+	windows := &OSFingerprint{
+		IPIDSequence:  IPIDIncremental,
+		ISNSequence:   ISNRandom,
+		TimestampRate: TimestampUnsupported,
+		InitialTTL:    initialTTL(120),
+		Window:        8192,
+		Options:       "MNWNNS",
+		ICMPEchoCode:  "Z",
+	}
+	withoutQuirk := matchOSFingerprint(windows)
+	windows.Quirks = []string{"responds-null-flags"}
+	withQuirk := matchOSFingerprint(windows)
+	require.NotEmpty(t, withoutQuirk)
+	require.NotEmpty(t, withQuirk)
+	assert.True(t, withQuirk[0].Accuracy > withoutQuirk[0].Accuracy)
+	assert.Equal(t, 100, withQuirk[0].Accuracy)
+}
@@ -0,0 +1,234 @@
+package scan
+
+import "sort"
+
+// osSignature describes the expected fingerprint of an operating system. Empty fields match anything.
+type osSignature struct {
+	name          string
+	ttl           uint8
+	ipid          []string
+	windows       []uint16
+	options       []string
+	timestampRate []int
+	ecn           string
+	icmpEchoCode  string
+	isn           []string
+	// udpUnreachable is "Y" if a UDP probe of a closed port gets a port unreachable reply, or "N" if it does not
+	udpUnreachable string
+	// quirks the stack is known to show, each of which is scored
+	quirks []string
+}
+
+const minimumOSAccuracy = 60
+
+// signatures are approximate and based on default configurations - tuned stacks will not match well
+var osSignatures = []osSignature{
+	{
+		name:           "Linux 3.x - 6.x",
+		ttl:            64,
+		ipid:           []string{IPIDZero, IPIDIncremental},
+		windows:        []uint16{26847, 27760, 28960, 29200, 43690, 64240, 65160},
+		options:        []string{"MSTNW"},
+		timestampRate:  []int{1000, 250},
+		ecn:            "Y",
+		icmpEchoCode:   "S",
+		isn:            []string{ISNRandom},
+		udpUnreachable: "Y",
+	},
+	{
+		name:           "Linux 2.6.x",
+		ttl:            64,
+		ipid:           []string{IPIDZero, IPIDIncremental},
+		windows:        []uint16{5792, 5840, 14480},
+		options:        []string{"MSTNW"},
+		timestampRate:  []int{100, 250, 1000},
+		ecn:            "N",
+		icmpEchoCode:   "S",
+		isn:            []string{ISNRandom},
+		udpUnreachable: "Y",
+	},
+	{
+		name:          "Embedded Linux (router, NAS or IoT device)",
+		ttl:           64,
+		ipid:          []string{IPIDZero, IPIDIncremental},
+		windows:       []uint16{5792, 5840, 14600, 14480},
+		options:       []string{"MSTNW", "MNNS", "M"},
+		timestampRate: []int{100},
+		ecn:           "N",
+		isn:           []string{ISNRandom},
+	},
+	{
+		name:          "Microsoft Windows 10 / 11 / Server 2016+",
+		ttl:           128,
+		ipid:          []string{IPIDIncremental},
+		windows:       []uint16{64240, 65535, 8192},
+		options:       []string{"MNWNNS", "MNWST"},
+		timestampRate: []int{TimestampUnsupported, TimestampZero},
+		ecn:           "N",
+		icmpEchoCode:  "Z",
+		isn:           []string{ISNRandom},
+		quirks:        []string{"responds-null-flags"},
+	},
+	{
+		name:          "Microsoft Windows 7 / Server 2008",
+		ttl:           128,
+		ipid:          []string{IPIDIncremental},
+		windows:       []uint16{8192},
+		options:       []string{"MNWNNS", "MNWST"},
+		timestampRate: []int{TimestampUnsupported, TimestampZero, 100},
+		ecn:           "N",
+		icmpEchoCode:  "Z",
+		isn:           []string{ISNRandom},
+		quirks:        []string{"responds-null-flags"},
+	},
+	{
+		name:           "FreeBSD 11 - 14",
+		ttl:            64,
+		ipid:           []string{IPIDZero, IPIDIncremental, IPIDRandom},
+		windows:        []uint16{65535, 65228},
+		options:        []string{"MNWST", "MNWSTE"},
+		timestampRate:  []int{1000},
+		ecn:            "Y",
+		icmpEchoCode:   "S",
+		isn:            []string{ISNRandom},
+		udpUnreachable: "Y",
+	},
+	{
+		name:           "OpenBSD 6.x - 7.x",
+		ttl:            64,
+		ipid:           []string{IPIDRandom},
+		windows:        []uint16{16384},
+		options:        []string{"MNNSNWNNT"},
+		timestampRate:  []int{2},
+		ecn:            "N",
+		icmpEchoCode:   "S",
+		isn:            []string{ISNRandom},
+		udpUnreachable: "Y",
+	},
+	{
+		name:          "Apple macOS / iOS",
+		ttl:           64,
+		ipid:          []string{IPIDRandom, IPIDZero},
+		windows:       []uint16{65535},
+		options:       []string{"MNWNNTSE", "MNWNNTS"},
+		timestampRate: []int{1000},
+		ecn:           "Y",
+		icmpEchoCode:  "S",
+		isn:           []string{ISNRandom},
+	},
+	{
+		name:          "Oracle Solaris 11 / illumos",
+		ttl:           64,
+		ipid:          []string{IPIDIncremental, IPIDRandomIncrements},
+		windows:       []uint16{64240, 32806, 49232},
+		options:       []string{"NNTNWMNNS", "MNWNNTNNS"},
+		timestampRate: []int{100, 1000},
+		ecn:           "N",
+		icmpEchoCode:  "S",
+		isn:           []string{ISNRandom},
+	},
+	{
+		name:          "Cisco IOS",
+		ttl:           255,
+		ipid:          []string{IPIDRandomIncrements, IPIDIncremental},
+		windows:       []uint16{4128, 4096, 16384},
+		options:       []string{"M"},
+		timestampRate: []int{TimestampUnsupported},
+		ecn:           "N",
+		icmpEchoCode:  "Z",
+		isn:           []string{ISNRandom},
+	},
+	{
+		name:           "Embedded TCP/IP stack (lwIP, uIP or similar)",
+		ttl:            255,
+		ipid:           []string{IPIDIncremental},
+		windows:        []uint16{2144, 4096, 5840},
+		options:        []string{"M"},
+		timestampRate:  []int{TimestampUnsupported},
+		ecn:            "N",
+		isn:            []string{ISNIncremental, ISNConstant},
+		udpUnreachable: "Y",
+	},
+}
+
+// matchOSFingerprint scores the fingerprint against each known signature and returns the best candidates, most
+// likely first.
+func matchOSFingerprint(fingerprint *OSFingerprint) []OSMatch {
+
+	matches := []OSMatch{}
+
+	for _, signature := range osSignatures {
+		score, possible := 0, 0
+		check := func(weight int, specified bool, matched bool) {
+			if !specified {
+				return
+			}
+			possible += weight
+			if matched {
+				score += weight
+			}
+		}
+
+		check(25, signature.ttl > 0, signature.ttl == fingerprint.InitialTTL)
+		check(15, len(signature.ipid) > 0 && fingerprint.IPIDSequence != "", containsString(signature.ipid, fingerprint.IPIDSequence))
+		check(15, len(signature.windows) > 0 && fingerprint.Options != "", containsUint16(signature.windows, fingerprint.Window))
+		check(20, len(signature.options) > 0 && fingerprint.Options != "", containsString(signature.options, fingerprint.Options))
+		check(10, len(signature.timestampRate) > 0, containsInt(signature.timestampRate, fingerprint.TimestampRate))
+		check(5, signature.ecn != "", (signature.ecn == "Y") == fingerprint.ECN)
+		check(5, signature.icmpEchoCode != "" && fingerprint.ICMPEchoCode != "", signature.icmpEchoCode == fingerprint.ICMPEchoCode)
+		check(10, len(signature.isn) > 0 && fingerprint.ISNSequence != "", containsString(signature.isn, fingerprint.ISNSequence))
+		check(5, signature.udpUnreachable != "", (signature.udpUnreachable == "Y") == fingerprint.UDPUnreachable)
+		for _, quirk := range signature.quirks {
+			check(5, true, containsString(fingerprint.Quirks, quirk))
+		}
+
+		if possible == 0 {
+			continue
+		}
+
+		accuracy := score * 100 / possible
+		if accuracy >= minimumOSAccuracy {
+			matches = append(matches, OSMatch{
+				Name:     signature.name,
+				Accuracy: accuracy,
+			})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Accuracy > matches[j].Accuracy
+	})
+
+	if len(matches) > 3 {
+		matches = matches[:3]
+	}
+
+	return matches
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint16(haystack []uint16, needle uint16) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(haystack []int, needle int) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -9,6 +9,18 @@ const (
 	PortFiltered
 )
 
+func (s PortState) String() string {
+	switch s {
+	case PortOpen:
+		return "open"
+	case PortClosed:
+		return "closed"
+	case PortFiltered:
+		return "filtered"
+	}
+	return "unknown"
+}
+
 var DefaultPorts []int
 
 func init() {
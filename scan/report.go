@@ -0,0 +1,169 @@
+package scan
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"time"
+)
+
+// Host states. A host which could not be scanned has one of the error states rather than being reported as down.
+const (
+	HostUp   = "up"
+	HostDown = "down"
+	// HostUnreachable means there is no route to the host.
+	HostUnreachable = "unreachable"
+	// HostARPFailed means the MAC address of the host (or the gateway to it) could not be resolved.
+	HostARPFailed = "arp-failed"
+	// HostLocalError means the scan failed locally, e.g. a capture device could not be opened.
+	HostLocalError = "error"
+)
+
+// ScanInfo describes a scan as a whole, and is written as a header by structured output formats.
+type ScanInfo struct {
+	Version   string     `json:"version"`
+	Arguments []string   `json:"arguments"`
+	ScanType  string     `json:"scan_type"`
+	Targets   []string   `json:"targets"`
+	Ports     []int      `json:"ports"`
+	Start     time.Time  `json:"start"`
+	End       *time.Time `json:"end,omitempty"`
+	// Incomplete is set if the scan was cancelled before every target was scanned
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// HostReport is a flattened, serialisable view of a Result.
+type HostReport struct {
+	Host         string              `json:"host"`
+	State        string              `json:"state"`
+	LatencyMS    float64             `json:"latency_ms,omitempty"`
+	MAC          string              `json:"mac,omitempty"`
+	Manufacturer string              `json:"manufacturer,omitempty"`
+	Name         string              `json:"name,omitempty"`
+	Error        string              `json:"error,omitempty"`
+	Incomplete   bool                `json:"incomplete,omitempty"`
+	OS           []OSMatch           `json:"os,omitempty"`
+	Advertised   []AdvertisedService `json:"advertised,omitempty"`
+	Details      []string            `json:"details,omitempty"`
+	Ports        []PortReport        `json:"ports"`
+}
+
+// PortReport describes the state of a single port, and the service identified on it (if any).
+type PortReport struct {
+	Port     int      `json:"port"`
+	Protocol string   `json:"protocol"`
+	State    string   `json:"state"`
+	Service  string   `json:"service,omitempty"`
+	Version  string   `json:"version,omitempty"`
+	Banner   string   `json:"banner,omitempty"`
+	Details  []string `json:"details,omitempty"`
+}
+
+// Report creates a HostReport from the result. Ports are listed in ascending order.
+func (r Result) Report() HostReport {
+
+	report := HostReport{
+		Host:         r.Host.String(),
+		State:        r.Status(),
+		MAC:          r.MAC,
+		Manufacturer: r.Manufacturer,
+		Name:         r.Name,
+		Advertised:   r.Advertised,
+		Incomplete:   r.Incomplete,
+		Ports:        []PortReport{},
+	}
+
+	if r.Error != nil {
+		report.Error = r.Error.Err.Error()
+	}
+
+	if r.IsHostUp() {
+		report.LatencyMS = float64(r.Latency) / float64(time.Millisecond)
+	}
+
+	if r.OS != nil {
+		report.OS = r.OS.Matches
+	}
+	if r.SNMP != nil {
+		report.Details = append(report.Details, r.SNMP.Details()...)
+	}
+	report.Details = append(report.Details, r.Details...)
+
+	for _, group := range []struct {
+		state PortState
+		ports []int
+	}{
+		{PortOpen, r.Open},
+		{PortClosed, r.Closed},
+		{PortFiltered, r.Filtered},
+	} {
+		for _, port := range group.ports {
+			portReport := PortReport{
+				Port:     port,
+				Protocol: "tcp",
+				State:    group.state.String(),
+			}
+			if group.state == PortOpen {
+				portReport.Service = DescribePort(port)
+				if service := r.Service(port); service != nil {
+					if service.Name != "" {
+						portReport.Service = service.Name
+					}
+					portReport.Version = service.Version
+					portReport.Banner = service.Banner
+					portReport.Details = service.Details()
+				}
+			}
+			report.Ports = append(report.Ports, portReport)
+		}
+	}
+
+	sort.Slice(report.Ports, func(i, j int) bool {
+		return report.Ports[i].Port < report.Ports[j].Port
+	})
+
+	return report
+}
+
+// Result recreates a Result from the report, e.g. to display a recorded scan. Details gathered by service modules
+// and SNMP are restored as text, in Service.Recorded and Result.Details.
+func (h HostReport) Result() Result {
+
+	result := NewResult(net.ParseIP(h.Host))
+	result.MAC = h.MAC
+	result.Manufacturer = h.Manufacturer
+	result.Name = h.Name
+	result.Advertised = h.Advertised
+	result.Incomplete = h.Incomplete
+	result.Details = h.Details
+
+	if h.State == HostUp {
+		result.Latency = time.Duration(h.LatencyMS * float64(time.Millisecond))
+	} else if h.Error != "" {
+		result.Error = &HostError{Status: h.State, Err: errors.New(h.Error)}
+	}
+
+	if len(h.OS) > 0 {
+		result.OS = &OSFingerprint{Matches: h.OS}
+	}
+
+	for _, port := range h.Ports {
+		switch port.State {
+		case PortOpen.String():
+			result.Open = append(result.Open, port.Port)
+			result.Services = append(result.Services, Service{
+				Port:     port.Port,
+				Name:     port.Service,
+				Version:  port.Version,
+				Banner:   port.Banner,
+				Recorded: port.Details,
+			})
+		case PortClosed.String():
+			result.Closed = append(result.Closed, port.Port)
+		case PortFiltered.String():
+			result.Filtered = append(result.Filtered, port.Port)
+		}
+	}
+
+	return result
+}
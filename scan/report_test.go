@@ -0,0 +1,70 @@
+package scan
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultReport(t *testing.T) {
+	result := NewResult(net.ParseIP("192.168.1.10"))
+	result.Latency = 1500 * time.Microsecond
+	result.MAC = "00:11:22:33:44:55"
+	result.Open = []int{443, 22}
+	result.Closed = []int{80}
+	result.Services = []Service{{Port: 22, Name: "ssh", Version: "OpenSSH_8.9p1"}}
+
+	report := result.Report()
+	assert.Equal(t, "192.168.1.10", report.Host)
+	assert.Equal(t, HostUp, report.State)
+	assert.Equal(t, 1.5, report.LatencyMS)
+	assert.Equal(t, "00:11:22:33:44:55", report.MAC)
+	assert.Equal(t, []PortReport{
+		{Port: 22, Protocol: "tcp", State: "open", Service: "ssh", Version: "OpenSSH_8.9p1", Details: []string{}},
+		{Port: 80, Protocol: "tcp", State: "closed"},
+		{Port: 443, Protocol: "tcp", State: "open", Service: DescribePort(443)},
+	}, report.Ports)
+}
+
+func TestResultReportHostDown(t *testing.T) {
+	report := NewResult(net.ParseIP("10.0.0.1")).Report()
+	assert.Equal(t, HostDown, report.State)
+	assert.Zero(t, report.LatencyMS)
+	assert.Empty(t, report.Ports)
+}
+
+func TestResultReportHostError(t *testing.T) {
+	result := NewResult(net.ParseIP("10.0.0.1"))
+	result.Error = &HostError{Status: HostARPFailed, Err: errors.New("timeout getting ARP reply")}
+
+	report := result.Report()
+	assert.Equal(t, HostARPFailed, report.State)
+	assert.Equal(t, "timeout getting ARP reply", report.Error)
+	assert.Contains(t, result.String(), "Host could not be scanned: ARP resolution failed: timeout getting ARP reply")
+}
+
+func TestHostReportResult(t *testing.T) {
+	result := NewResult(net.ParseIP("192.168.1.10"))
+	result.Latency = 1500 * time.Microsecond
+	result.Open = []int{22, 443}
+	result.Closed = []int{80}
+	result.OS = &OSFingerprint{Matches: []OSMatch{{Name: "Linux 5.x", Accuracy: 100}}}
+	result.Services = []Service{
+		{Port: 22, Name: "ssh", Version: "OpenSSH_8.9p1", SSH: &SSHInfo{ProtocolVersion: "2.0", SoftwareVersion: "OpenSSH_8.9p1"}},
+		{Port: 443, Name: "https"},
+	}
+	result.SNMP = &SNMPInfo{SysName: "router", Communities: []SNMPCommunity{{Community: "public", Version: "v2c"}}}
+
+	// the report is all that is recorded, so a result read back from it is displayed the same way
+	recorded := result.Report().Result()
+	assert.Equal(t, result.String(), recorded.String())
+	assert.Equal(t, result.Report(), recorded.Report())
+
+	failed := NewResult(net.ParseIP("10.0.0.1"))
+	failed.Error = &HostError{Status: HostUnreachable, Err: errors.New("no route")}
+	assert.Equal(t, failed.String(), failed.Report().Result().String())
+	assert.Equal(t, HostDown, NewResult(net.ParseIP("10.0.0.2")).Report().Result().Status())
+}
@@ -15,6 +15,42 @@ type Result struct {
 	MAC          string
 	Latency      time.Duration
 	Name         string
+	OS           *OSFingerprint
+	Services     []Service
+	Advertised   []AdvertisedService
+	UPnP         *UPnPInfo
+	SNMP         *SNMPInfo
+	// Details holds further details of a host read back from a report, such as what its SNMP agent reported
+	Details []string
+	// Error is set if the host could not be scanned
+	Error *HostError
+	// Incomplete is set if the scan was cancelled while the host was being scanned
+	Incomplete bool
+}
+
+// HostError describes why a host could not be scanned.
+type HostError struct {
+	// Status is one of HostUnreachable, HostARPFailed or HostLocalError
+	Status string
+	Err    error
+}
+
+func (e *HostError) Error() string {
+	switch e.Status {
+	case HostUnreachable:
+		return fmt.Sprintf("host is unreachable: %s", e.Err)
+	case HostARPFailed:
+		return fmt.Sprintf("ARP resolution failed: %s", e.Err)
+	}
+	return fmt.Sprintf("local error: %s", e.Err)
+}
+
+// newHostError wraps err as a HostError with the given status, unless it already is one.
+func newHostError(status string, err error) *HostError {
+	if hostErr, ok := err.(*HostError); ok {
+		return hostErr
+	}
+	return &HostError{Status: status, Err: err}
 }
 
 func NewResult(host net.IP) Result {
@@ -31,16 +67,34 @@ func (r Result) IsHostUp() bool {
 	return r.Latency > -1
 }
 
+// Status returns the state of the host: HostUp, HostDown, or the status of the error which prevented it from being
+// scanned.
+func (r Result) Status() string {
+	switch {
+	case r.IsHostUp():
+		return HostUp
+	case r.Error != nil:
+		return r.Error.Status
+	}
+	return HostDown
+}
+
 func (r Result) String() string {
 
 	text := fmt.Sprintf("Scan results for host %s\n", r.Host.String())
 
 	if r.IsHostUp() {
 		text = fmt.Sprintf("%s\tHost is up with %s latency\n", text, r.Latency.String())
+	} else if r.Error != nil {
+		text = fmt.Sprintf("%s\tHost could not be scanned: %s\n", text, r.Error)
 	} else {
 		text = fmt.Sprintf("%s\t%s\n", text, "Host is down")
 	}
 
+	if r.Incomplete {
+		text = fmt.Sprintf("%s\t%s\n", text, "Scan of this host was interrupted, results are incomplete")
+	}
+
 	if len(r.Open) > 0 {
 		text = fmt.Sprintf(
 			"%s\t%s\t%s\t%s\n",
@@ -52,13 +106,41 @@ func (r Result) String() string {
 	}
 
 	for _, port := range r.Open {
+		description := DescribePort(port)
+		service := r.Service(port)
+		if service != nil {
+			description = service.Description()
+		}
 		text = fmt.Sprintf(
 			"%s\t%s\t%s\t%s\n",
 			text,
 			pad(fmt.Sprintf("%d/tcp", port), 10),
 			pad("OPEN", 10),
-			DescribePort(port),
+			description,
 		)
+		if service != nil {
+			for _, detail := range service.Details() {
+				text = fmt.Sprintf("%s\t%s\t%s\t  %s\n", text, pad("", 10), pad("", 10), detail)
+			}
+		}
+	}
+
+	if r.OS != nil {
+		if len(r.OS.Matches) == 0 {
+			text = fmt.Sprintf("%s\t%s\n", text, "No exact OS matches for host")
+		}
+		for _, match := range r.OS.Matches {
+			text = fmt.Sprintf("%s\tOS guess: %s (%d%%)\n", text, match.Name, match.Accuracy)
+		}
+	}
+
+	if r.SNMP != nil {
+		for _, detail := range r.SNMP.Details() {
+			text = fmt.Sprintf("%s\t%s\n", text, detail)
+		}
+	}
+	for _, detail := range r.Details {
+		text = fmt.Sprintf("%s\t%s\n", text, detail)
 	}
 
 	return text
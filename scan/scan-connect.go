@@ -11,67 +11,119 @@ import (
 )
 
 type ConnectScanner struct {
-	timeout     time.Duration
-	maxRoutines int
-	jobChan     chan portJob
-	ti          *TargetIterator
+	maxRoutines   int
+	hostGroupSize int
+	retries       int
+	jobChan       chan portJob
+	startOnce     sync.Once
+	stopOnce      sync.Once
+	eventEmitter
+	controllable
+	timing
+	defaultTargets
 }
 
+// NewConnectScanner creates a connect scanner of the targets in ti.
+//
+// Deprecated: use New, which takes Options and creates a scanner which can be reused for any targets. Scanners
+// created with NewConnectScanner scan ti when Scan is passed nil targets.
 func NewConnectScanner(ti *TargetIterator, timeout time.Duration, paralellism int) *ConnectScanner {
-	return &ConnectScanner{
-		timeout:     timeout,
-		maxRoutines: paralellism,
-		jobChan:     make(chan portJob, paralellism),
-		ti:          ti,
+	s := newConnectScanner(Options{Type: "connect", Timeout: timeout, Parallelism: paralellism})
+	s.setDefaultTargets(ti)
+	return s
+}
+
+func newConnectScanner(options Options) *ConnectScanner {
+	options = options.withDefaults()
+	s := &ConnectScanner{
+		maxRoutines:   options.Parallelism,
+		hostGroupSize: options.HostGroupSize,
+		retries:       options.Retries,
+		jobChan:       make(chan portJob, options.Parallelism),
 	}
+	options.configure(&s.eventEmitter, &s.controllable)
+	s.setTiming(options)
+	return s
 }
 
 func (s *ConnectScanner) Start() error {
+	s.startOnce.Do(s.startWorkers)
+	return nil
+}
+
+func (s *ConnectScanner) startWorkers() {
 
 	for i := 0; i < s.maxRoutines; i++ {
 		go func() {
 			for {
-				job := <-s.jobChan
-				if job.port == 0 {
+				job, ok := <-s.jobChan
+				if !ok {
 					break
 				}
 
-				select {
-				case <-job.ctx.Done():
+				// ports which haven't been probed when the scan is cancelled are skipped
+				if job.ctx.Err() != nil {
 					close(job.done)
-					return
-				default:
+					continue
 				}
 
-				if state, err := s.scanPort(job.ip, job.port); err == nil {
+				// block while the scan is paused or throttled, rather than starting another probe
+				if err := s.acquire(job.ctx); err != nil {
+					close(job.done)
+					continue
+				}
+				if s.wait(job.ctx) != nil || s.pace(job.ctx) != nil {
+					s.release()
+					close(job.done)
+					continue
+				}
+
+				state, err := s.probe(job)
+				s.release()
+				if job.drain.Err() != nil {
+					// the probe was abandoned after the scan was cancelled, so says nothing about the port
+				} else if err == nil {
 					switch state {
 					case PortOpen:
+						s.emitPortState(job.ip, job.port, state, ReasonSynAck, 0)
 						job.open <- job.port
 					case PortClosed:
+						s.emitPortState(job.ip, job.port, state, ReasonConnRefused, 0)
 						job.closed <- job.port
 					case PortFiltered:
 						job.filtered <- job.port
 					}
+				} else if !isTimeout(err) {
+					s.emitError(job.ip, job.port, err)
+					job.failed <- err
 				}
 				close(job.done)
 			}
 		}()
 	}
-
-	return nil
 }
 
 func (s *ConnectScanner) Stop() {
-
+	s.stopOnce.Do(func() {
+		close(s.jobChan)
+	})
 }
 
-func (s *ConnectScanner) Scan(ctx context.Context, ports []int) ([]Result, error) {
+func (s *ConnectScanner) Scan(ctx context.Context, targets Targets, ports []int, handler ResultHandler) error {
+
+	if len(ports) == 0 {
+		return fmt.Errorf("no ports to scan")
+	}
+	targets, err := s.targetsOr(targets)
+	if err != nil {
+		return err
+	}
 
 	wg := &sync.WaitGroup{}
 
 	resultChan := make(chan *Result)
-	results := []Result{}
 	doneChan := make(chan struct{})
+	hostSlots := make(chan struct{}, s.hostGroupSize)
 
 	go func() {
 		for {
@@ -80,37 +132,45 @@ func (s *ConnectScanner) Scan(ctx context.Context, ports []int) ([]Result, error
 				close(doneChan)
 				break
 			}
-			results = append(results, *result)
+			handler(*result)
 		}
 	}()
 
+	var targetErr error
+
 	for {
-		ip, err := s.ti.Next()
+		// stop starting hosts as soon as the scan is cancelled
+		if ctx.Err() != nil {
+			break
+		}
+
+		ip, err := targets.Next()
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				targetErr = err
 			}
-			return nil, err
+			break
 		}
 
+		hostSlots <- struct{}{}
 		wg.Add(1)
 		tIP := make([]byte, len(ip))
 		copy(tIP, ip)
 		go func(ip net.IP, ports []int, wg *sync.WaitGroup) {
 			r := s.scanHost(ctx, ip, ports)
-			resultChan <- &r
+			if reportable(r) {
+				resultChan <- &r
+			}
+			<-hostSlots
 			wg.Done()
 		}(tIP, ports, wg)
-
-		_ = ip
 	}
 
 	wg.Wait()
 	close(resultChan)
-	close(s.jobChan)
 	<-doneChan
 
-	return results, nil
+	return targetErr
 }
 
 func (s *ConnectScanner) scanHost(ctx context.Context, host net.IP, ports []int) Result {
@@ -122,10 +182,24 @@ func (s *ConnectScanner) scanHost(ctx context.Context, host net.IP, ports []int)
 	openChan := make(chan int)
 	closedChan := make(chan int)
 	filteredChan := make(chan int)
+	failedChan := make(chan error)
 	doneChan := make(chan struct{})
 
+	var failure error
+
+	// dials in flight when the scan is cancelled get a short grace period to complete
+	drain, stopDrain := afterCancel(ctx, gracePeriod(s.currentTimeout(s.probeTimeout())))
+	defer stopDrain()
+
 	startTime := time.Now()
 
+	markUp := func() {
+		if result.Latency < 0 {
+			result.Latency = time.Since(startTime)
+			s.emit(Event{Type: EventHostUp, Host: host})
+		}
+	}
+
 	go func() {
 		for {
 			select {
@@ -134,20 +208,18 @@ func (s *ConnectScanner) scanHost(ctx context.Context, host net.IP, ports []int)
 					close(doneChan)
 					return
 				}
-				if result.Latency < 0 {
-					result.Latency = time.Since(startTime)
-				}
+				markUp()
 				result.Open = append(result.Open, open)
 			case closed := <-closedChan:
-				if result.Latency < 0 {
-					result.Latency = time.Since(startTime)
-				}
+				markUp()
 				result.Closed = append(result.Closed, closed)
 			case filtered := <-filteredChan:
-				if result.Latency < 0 {
-					result.Latency = time.Since(startTime)
-				}
+				markUp()
 				result.Filtered = append(result.Filtered, filtered)
+			case err := <-failedChan:
+				if failure == nil {
+					failure = err
+				}
 			}
 		}
 	}()
@@ -162,10 +234,12 @@ func (s *ConnectScanner) scanHost(ctx context.Context, host net.IP, ports []int)
 				open:     openChan,
 				closed:   closedChan,
 				filtered: filteredChan,
+				failed:   failedChan,
 				ip:       host,
 				port:     p,
 				done:     done,
 				ctx:      ctx,
+				drain:    drain,
 			}
 
 			<-done
@@ -178,22 +252,69 @@ func (s *ConnectScanner) scanHost(ctx context.Context, host net.IP, ports []int)
 	close(openChan)
 	<-doneChan
 
+	if ctx.Err() != nil {
+		result.Incomplete = true
+		return result
+	}
+
+	if !result.IsHostUp() {
+		if failure != nil {
+			result.Error = dialError(failure)
+		} else {
+			s.emit(Event{Type: EventHostTimeout, Host: host})
+		}
+	}
+
 	return result
 }
 
-func (s *ConnectScanner) scanPort(target net.IP, port int) (PortState, error) {
+// probe dials the port in a job, dialling again up to the number of retries if the dial times out.
+func (s *ConnectScanner) probe(job portJob) (PortState, error) {
+	eventType := EventProbeSent
+	for attempt := 0; ; attempt++ {
+		s.emit(Event{Type: eventType, Host: job.ip, Port: job.port, Protocol: "tcp"})
+		state, err := s.scanPort(job.drain, job.ip, job.port)
+		if err == nil || !isTimeout(err) || attempt >= s.retries || job.ctx.Err() != nil {
+			return state, err
+		}
+		if s.wait(job.ctx) != nil || s.pace(job.ctx) != nil {
+			return state, err
+		}
+		eventType = EventRetransmit
+	}
+}
 
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", target.String(), port), s.timeout)
+func (s *ConnectScanner) scanPort(ctx context.Context, target net.IP, port int) (PortState, error) {
+
+	start := time.Now()
+	dialer := &net.Dialer{Timeout: s.currentTimeout(s.probeTimeout())}
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", target.String(), port))
 	if err != nil {
 		if strings.Contains(err.Error(), "refused") {
+			s.observeRTT(time.Since(start))
 			return PortClosed, nil
 		}
 		return PortUnknown, err
 	}
+	s.observeRTT(time.Since(start))
 	conn.Close()
 	return PortOpen, err
 }
 
-func (s *ConnectScanner) OutputResult(result Result) {
-	fmt.Println(result.String())
+// dialError describes a connection failure which was not a timeout or refusal
+func dialError(err error) *HostError {
+	message := err.Error()
+	if strings.Contains(message, "unreachable") || strings.Contains(message, "no route to host") {
+		return newHostError(HostUnreachable, err)
+	}
+	return newHostError(HostLocalError, err)
+}
+
+// isTimeout reports whether err is a network timeout, which is the expected outcome of probing a filtered port or a
+// host which is down rather than a failure.
+func isTimeout(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return strings.Contains(err.Error(), "timeout")
 }
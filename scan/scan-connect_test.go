@@ -0,0 +1,144 @@
+package scan
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectScanStreamsResults(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	scanner, err := New(Options{Type: "connect", Timeout: time.Second, Parallelism: 16})
+	require.NoError(t, err)
+	defer scanner.Stop()
+
+	// scanners can be reused
+	for i := 0; i < 2; i++ {
+		hosts := []string{}
+		err = scanner.Scan(context.Background(), NewTargetIterator("127.0.0.1/30"), []int{port}, func(result Result) {
+			hosts = append(hosts, result.Host.String())
+			if result.Host.String() == "127.0.0.1" {
+				assert.Equal(t, []int{port}, result.Open)
+			}
+		})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"127.0.0.0", "127.0.0.1", "127.0.0.2", "127.0.0.3"}, hosts)
+	}
+}
+
+func TestConnectScanNoPorts(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	scanner, err := New(Options{Type: "connect", Timeout: time.Second, Parallelism: 1})
+	require.NoError(t, err)
+	defer scanner.Stop()
+
+	_, err = Collect(context.Background(), scanner, NewTargetIterator("127.0.0.1"), []int{})
+	assert.Error(t, err)
+
+	// the only worker is still available
+	results, err := Collect(context.Background(), scanner, NewTargetIterator("127.0.0.1"), []int{port})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, []int{port}, results[0].Open)
+}
+
+func TestDeprecatedConnectScanner(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	scanner := NewConnectScanner(NewTargetIterator("127.0.0.1"), time.Second, 4)
+	require.NoError(t, scanner.Start())
+	defer scanner.Stop()
+
+	// nil targets scan the targets given to the constructor
+	results, err := Collect(context.Background(), scanner, nil, []int{port})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, []int{port}, results[0].Open)
+}
+
+func TestConnectScanEvents(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// a port which was just released is closed
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	defer listener.Close()
+
+	events := []Event{}
+	scanner, err := New(Options{Type: "connect", Timeout: time.Second, Parallelism: 4, EventSink: func(event Event) {
+		events = append(events, event)
+	}})
+	require.NoError(t, err)
+	defer scanner.Stop()
+
+	_, err = Collect(context.Background(), scanner, NewTargetIterator("127.0.0.1"), []int{port, closedPort})
+	require.NoError(t, err)
+
+	counts := map[EventType]int{}
+	states := map[int]Event{}
+	for _, event := range events {
+		counts[event.Type]++
+		assert.False(t, event.Time.IsZero())
+		if event.Type == EventPortState {
+			states[event.Port] = event
+		}
+	}
+	assert.Equal(t, 2, counts[EventProbeSent])
+	assert.Equal(t, 1, counts[EventHostUp])
+	assert.Equal(t, 0, counts[EventHostTimeout])
+	assert.Equal(t, PortOpen, states[port].State)
+	assert.Equal(t, ReasonSynAck, states[port].Reason)
+	assert.Equal(t, PortClosed, states[closedPort].State)
+	assert.Equal(t, ReasonConnRefused, states[closedPort].Reason)
+}
+
+func TestConnectScanCancelled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	scanner, err := New(Options{Type: "connect", Timeout: time.Second, Parallelism: 16})
+	require.NoError(t, err)
+	defer scanner.Stop()
+
+	// nothing is known about hosts which were not scanned, so they are not reported as down
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := Collect(ctx, scanner, NewTargetIterator("127.0.0.0/24"), []int{port})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	drain, stop := afterCancel(ctx, 50*time.Millisecond)
+	defer stop()
+
+	cancel()
+	assert.NoError(t, drain.Err())
+	select {
+	case <-drain.Done():
+	case <-time.After(time.Second):
+		t.Fatal("drain context was not cancelled after the grace period")
+	}
+}
@@ -14,15 +14,33 @@ import (
 )
 
 type DeviceScanner struct {
-	timeout time.Duration
-	ti      *TargetIterator
+	hostGroupSize int
+	retries       int
+	eventEmitter
+	controllable
+	timing
+	defaultTargets
 }
 
+// NewDeviceScanner creates a device scanner of the targets in ti.
+//
+// Deprecated: use New, which takes Options and creates a scanner which can be reused for any targets. Scanners
+// created with NewDeviceScanner scan ti when Scan is passed nil targets.
 func NewDeviceScanner(ti *TargetIterator, timeout time.Duration) *DeviceScanner {
-	return &DeviceScanner{
-		timeout: timeout,
-		ti:      ti,
+	s := newDeviceScanner(Options{Type: "device", Timeout: timeout})
+	s.setDefaultTargets(ti)
+	return s
+}
+
+func newDeviceScanner(options Options) *DeviceScanner {
+	options = options.withDefaults()
+	s := &DeviceScanner{
+		hostGroupSize: options.HostGroupSize,
+		retries:       options.Retries,
 	}
+	options.configure(&s.eventEmitter, &s.controllable)
+	s.setTiming(options)
+	return s
 }
 
 func (s *DeviceScanner) Start() error {
@@ -34,13 +52,18 @@ func (s *DeviceScanner) Stop() {
 
 }
 
-func (s *DeviceScanner) Scan(ctx context.Context, ports []int) ([]Result, error) {
+func (s *DeviceScanner) Scan(ctx context.Context, targets Targets, ports []int, handler ResultHandler) error {
+
+	targets, err := s.targetsOr(targets)
+	if err != nil {
+		return err
+	}
 
 	wg := &sync.WaitGroup{}
 
 	resultChan := make(chan *Result)
-	results := []Result{}
 	doneChan := make(chan struct{})
+	hostSlots := make(chan struct{}, s.hostGroupSize)
 
 	go func() {
 		for {
@@ -49,19 +72,27 @@ func (s *DeviceScanner) Scan(ctx context.Context, ports []int) ([]Result, error)
 				close(doneChan)
 				break
 			}
-			results = append(results, *result)
+			handler(*result)
 		}
 	}()
 
+	var targetErr error
+
 	for {
-		ip, err := s.ti.Next()
+		// stop starting hosts as soon as the scan is cancelled
+		if ctx.Err() != nil {
+			break
+		}
+
+		ip, err := targets.Next()
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				targetErr = err
 			}
-			return nil, err
+			break
 		}
 
+		hostSlots <- struct{}{}
 		wg.Add(1)
 		tIP := make([]byte, len(ip))
 		copy(tIP, ip)
@@ -70,11 +101,19 @@ func (s *DeviceScanner) Scan(ctx context.Context, ports []int) ([]Result, error)
 
 			select {
 			case <-ctx.Done():
+				<-hostSlots
 				wg.Done()
 				return
 			default:
 			}
 
+			// block while the scan is paused or throttled, rather than starting another host
+			if err := s.acquire(ctx); err != nil {
+				<-hostSlots
+				wg.Done()
+				return
+			}
+
 			macStr := arp.Search(ip.String())
 
 			if macStr != "00:00:00:00:00:00" {
@@ -82,17 +121,7 @@ func (s *DeviceScanner) Scan(ctx context.Context, ports []int) ([]Result, error)
 				if mac, err := net.ParseMAC(macStr); err == nil {
 
 					r.MAC = mac.String()
-
-					prefix := [3]byte{
-						mac[0],
-						mac[1],
-						mac[2],
-					}
-
-					manufacturer, ok := macs.ValidMACPrefixMap[prefix]
-					if ok {
-						r.Manufacturer = manufacturer
-					}
+					r.Manufacturer = lookupManufacturer(mac)
 
 					// only bother looking up hostname for local devices
 					if addr, err := net.LookupAddr(ip.String()); err == nil && len(addr) > 0 {
@@ -102,22 +131,99 @@ func (s *DeviceScanner) Scan(ctx context.Context, ports []int) ([]Result, error)
 				}
 			}
 
-			start := time.Now()
-			conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:1", ip.String()), s.timeout)
-			if err != nil {
-				if !strings.Contains(err.Error(), "timeout") {
+			if s.wait(ctx) != nil || s.pace(ctx) != nil {
+				s.release()
+				<-hostSlots
+				wg.Done()
+				return
+			}
+
+			// a dial in flight when the scan is cancelled gets a short grace period to complete
+			timeout := s.currentTimeout(s.probeTimeout())
+			drain, stopDrain := afterCancel(ctx, gracePeriod(timeout))
+			defer stopDrain()
+
+			// any reply, even a refusal, shows the host is up. A dial which times out is retried.
+			eventType := EventProbeSent
+			for attempt := 0; ; attempt++ {
+				start := time.Now()
+				s.emit(Event{Type: eventType, Host: ip, Port: 1, Protocol: "tcp"})
+				dialer := &net.Dialer{Timeout: timeout}
+				conn, err := dialer.DialContext(drain, "tcp", fmt.Sprintf("%s:1", ip.String()))
+				if err != nil {
+					if drain.Err() == nil && !strings.Contains(err.Error(), "timeout") {
+						r.Latency = time.Since(start)
+					}
+				} else {
 					r.Latency = time.Since(start)
+					conn.Close()
 				}
-			} else {
-				r.Latency = time.Since(start)
-				conn.Close()
+				if r.IsHostUp() || attempt >= s.retries || ctx.Err() != nil {
+					break
+				}
+				if s.wait(ctx) != nil || s.pace(ctx) != nil {
+					break
+				}
+				timeout = s.currentTimeout(s.probeTimeout())
+				eventType = EventRetransmit
+			}
+			if r.IsHostUp() {
+				s.observeRTT(r.Latency)
+			}
+			if r.IsHostUp() {
+				s.emit(Event{Type: EventHostUp, Host: ip})
+			} else if ctx.Err() == nil {
+				s.emit(Event{Type: EventHostTimeout, Host: ip})
 			}
 
-			select {
-			case <-ctx.Done():
-			case resultChan <- &r:
+			// once cancelled, report what is known about the host without asking it anything more
+			if ctx.Err() != nil {
+				r.Incomplete = true
+				s.release()
+				if reportable(r) {
+					resultChan <- &r
+				}
+				<-hostSlots
+				wg.Done()
+				return
+			}
+
+			// most LAN devices have no PTR record, so ask the device itself
+			if r.IsHostUp() || r.MAC != "" {
+				names := ResolveLocalNames(ip, s.currentTimeout(s.probeTimeout()))
+				if r.Name == "" {
+					r.Name = names.Name()
+				}
+				if r.MAC == "" && names.NetBIOSMAC != "" {
+					if mac, err := net.ParseMAC(names.NetBIOSMAC); err == nil {
+						r.MAC = mac.String()
+						r.Manufacturer = lookupManufacturer(mac)
+					}
+				}
+				r.Advertised = names.Services
+			}
+
+			// SMB over NetBIOS (139) is tried when direct SMB (445) is unavailable, e.g. on older devices
+			if r.IsHostUp() {
+				for _, port := range []int{445, 139} {
+					info, err := QuerySMB(ctx, ip, port, s.currentTimeout(s.probeTimeout()))
+					if err != nil {
+						continue
+					}
+					r.Services = append(r.Services, Service{Port: port, Name: "smb", SMB: info})
+					if r.Name == "" {
+						r.Name = info.Name()
+					}
+					break
+				}
 			}
 
+			s.release()
+
+			r.Incomplete = ctx.Err() != nil
+			resultChan <- &r
+
+			<-hostSlots
 			wg.Done()
 		}(tIP, wg)
 
@@ -128,56 +234,17 @@ func (s *DeviceScanner) Scan(ctx context.Context, ports []int) ([]Result, error)
 	close(resultChan)
 	<-doneChan
 
-	return results, nil
+	return targetErr
 }
 
-func (s *DeviceScanner) OutputResult(result Result) {
-
-	fmt.Printf("Scan results for host %s\n", result.Host.String())
-
-	status := "DOWN"
-
-	if result.IsHostUp() {
-		status = "UP"
+func lookupManufacturer(mac net.HardwareAddr) string {
+	if len(mac) < 3 {
+		return ""
 	}
-
-	fmt.Printf(
-		"\t%s %s\n",
-		pad("Status:", 24),
-		status,
-	)
-
-	if result.IsHostUp() {
-		fmt.Printf(
-			"\t%s %s\n",
-			pad("Latency:", 24),
-			result.Latency.String(),
-		)
+	prefix := [3]byte{
+		mac[0],
+		mac[1],
+		mac[2],
 	}
-
-	if result.MAC != "" {
-		fmt.Printf(
-			"\t%s %s\n",
-			pad("MAC:", 24),
-			result.MAC,
-		)
-	}
-
-	if result.Manufacturer != "" {
-		fmt.Printf(
-			"\t%s %s\n",
-			pad("Manufacturer:", 24),
-			result.Manufacturer,
-		)
-	}
-
-	if result.Name != "" {
-		fmt.Printf(
-			"\t%s %s\n",
-			pad("Name:", 24),
-			result.Name,
-		)
-	}
-
-	fmt.Println("")
+	return macs.ValidMACPrefixMap[prefix]
 }
@@ -24,8 +24,11 @@ type portJob struct {
 	open     chan int
 	closed   chan int
 	filtered chan int
+	failed   chan error
 	done     chan struct{}
 	ctx      context.Context
+	// drain is cancelled a short grace period after ctx, ending probes which are in flight
+	drain context.Context
 }
 
 type hostJob struct {
@@ -37,52 +40,101 @@ type hostJob struct {
 }
 
 type SynScanner struct {
-	timeout          time.Duration
 	maxRoutines      int
+	hostGroupSize    int
+	retries          int
+	iface            string
+	sourcePort       int
 	jobChan          chan hostJob
-	ti               *TargetIterator
 	serializeOptions gopacket.SerializeOptions
+	osDetection      bool
+	startOnce        sync.Once
+	stopOnce         sync.Once
+	eventEmitter
+	controllable
+	timing
+	defaultTargets
 }
 
+// NewSynScanner creates a syn scanner of the targets in ti.
+//
+// Deprecated: use New, which takes Options and creates a scanner which can be reused for any targets. Scanners
+// created with NewSynScanner scan ti when Scan is passed nil targets.
 func NewSynScanner(ti *TargetIterator, timeout time.Duration, paralellism int) *SynScanner {
+	s := newSynScanner(Options{Type: "syn", Timeout: timeout, Parallelism: paralellism})
+	s.setDefaultTargets(ti)
+	return s
+}
+
+func newSynScanner(options Options) *SynScanner {
 
-	return &SynScanner{
+	options = options.withDefaults()
+	s := &SynScanner{
 		serializeOptions: gopacket.SerializeOptions{
 			FixLengths:       true,
 			ComputeChecksums: true,
 		},
-		timeout:     timeout,
-		maxRoutines: paralellism,
-		jobChan:     make(chan hostJob, paralellism),
-		ti:          ti,
+		maxRoutines:   options.Parallelism,
+		hostGroupSize: options.HostGroupSize,
+		retries:       options.Retries,
+		iface:         options.Interface,
+		sourcePort:    options.SourcePort,
+		osDetection:   options.OSDetection,
+		jobChan:       make(chan hostJob, options.Parallelism),
 	}
+	options.configure(&s.eventEmitter, &s.controllable)
+	s.setTiming(options)
+	return s
 }
 
-func (s *SynScanner) Stop() {
+// SetOSDetection enables or disables active OS fingerprinting of hosts with at least one open port.
+func (s *SynScanner) SetOSDetection(enabled bool) {
+	s.osDetection = enabled
+}
 
+func (s *SynScanner) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.jobChan)
+	})
 }
 
 func (s *SynScanner) Start() error {
+	s.startOnce.Do(s.startWorkers)
+	return nil
+}
+
+func (s *SynScanner) startWorkers() {
 
 	for i := 0; i < s.maxRoutines; i++ {
 		go func() {
 			for {
-				job := <-s.jobChan
-				if job.ports == nil || len(job.ports) == 0 {
+				job, ok := <-s.jobChan
+				if !ok {
 					break
 				}
+				// hosts which haven't started when the scan is cancelled are skipped. Blocking while the scan is
+				// paused or throttled happens here too, rather than starting another host.
+				if job.ctx.Err() != nil || s.acquire(job.ctx) != nil {
+					close(job.done)
+					continue
+				}
 				result, err := s.scanHost(job)
+				s.release()
 				if err != nil {
 					logrus.Debugf("Error scanning host %s: %s", job.ip, err)
-					// @todo handle this?
+					s.emitError(job.ip, 0, err)
+					// errors after the host has responded (e.g. during OS detection) don't stop it being reported
+					if !result.IsHostUp() {
+						result.Error = newHostError(HostLocalError, err)
+					}
+				}
+				if reportable(result) {
+					job.resultChan <- &result
 				}
-				job.resultChan <- &result
 				close(job.done)
 			}
 		}()
 	}
-
-	return nil
 }
 
 func (s *SynScanner) getHwAddr(ip net.IP, gateway net.IP, srcIP net.IP, networkInterface *net.Interface) (net.HardwareAddr, error) {
@@ -102,7 +154,7 @@ func (s *SynScanner) getHwAddr(ip net.IP, gateway net.IP, srcIP net.IP, networkI
 
 	handle, err := pcap.OpenLive(networkInterface.Name, 65536, true, pcap.BlockForever)
 	if err != nil {
-		return nil, err
+		return nil, newHostError(HostLocalError, err)
 	}
 	defer handle.Close()
 
@@ -130,15 +182,15 @@ func (s *SynScanner) getHwAddr(ip net.IP, gateway net.IP, srcIP net.IP, networkI
 
 	// Send a single ARP request packet (we never retry a send, since this
 	if err := gopacket.SerializeLayers(buf, s.serializeOptions, &eth, &arp); err != nil {
-		return nil, err
+		return nil, newHostError(HostLocalError, err)
 	}
 	if err := handle.WritePacketData(buf.Bytes()); err != nil {
-		return nil, err
+		return nil, newHostError(HostLocalError, err)
 	}
 
 	// Wait 3 seconds for an ARP reply.
 	for {
-		if time.Since(start) > s.timeout {
+		if time.Since(start) > s.currentTimeout(s.probeTimeout()) {
 			return nil, errors.New("timeout getting ARP reply")
 		}
 		data, _, err := handle.ReadPacketData()
@@ -166,12 +218,20 @@ func (s *SynScanner) send(handle *pcap.Handle, l ...gopacket.SerializableLayer)
 	return handle.WritePacketData(buf.Bytes())
 }
 
-func (s *SynScanner) Scan(ctx context.Context, ports []int) ([]Result, error) {
+func (s *SynScanner) Scan(ctx context.Context, targets Targets, ports []int, handler ResultHandler) error {
+
+	if len(ports) == 0 {
+		return fmt.Errorf("no ports to scan")
+	}
+	targets, err := s.targetsOr(targets)
+	if err != nil {
+		return err
+	}
 
 	wg := &sync.WaitGroup{}
 	resultChan := make(chan *Result)
-	results := []Result{}
 	doneChan := make(chan struct{})
+	hostSlots := make(chan struct{}, s.hostGroupSize)
 
 	go func() {
 		for {
@@ -180,70 +240,61 @@ func (s *SynScanner) Scan(ctx context.Context, ports []int) ([]Result, error) {
 				close(doneChan)
 				break
 			}
-			results = append(results, *result)
+			handler(*result)
 		}
 	}()
 
+	var targetErr error
+
 	for {
-		ip, err := s.ti.Next()
+		ip, err := targets.Next()
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				targetErr = err
 			}
-			return nil, err
+			break
 		}
 
-		select {
-		case <-ctx.Done():
+		// stop queueing hosts as soon as the scan is cancelled
+		if ctx.Err() != nil {
 			break
-		default:
 		}
 
+		// at most a host group of hosts are scanned at once
+		hostSlots <- struct{}{}
 		wg.Add(1)
 		tIP := make([]byte, len(ip))
 		copy(tIP, ip)
-		go func(host net.IP, ports []int, wg *sync.WaitGroup) {
-
-			done := make(chan struct{})
-
-			s.jobChan <- hostJob{
-				resultChan: resultChan,
-				ip:         host,
-				ports:      ports,
-				done:       done,
-				ctx:        ctx,
-			}
 
+		// the job channel is buffered by the number of workers, so this blocks until a worker is free rather than
+		// queueing every target in memory
+		done := make(chan struct{})
+		s.jobChan <- hostJob{
+			resultChan: resultChan,
+			ip:         tIP,
+			ports:      ports,
+			done:       done,
+			ctx:        ctx,
+		}
+		go func(done chan struct{}, wg *sync.WaitGroup) {
 			<-done
+			<-hostSlots
 			wg.Done()
-		}(tIP, ports, wg)
+		}(done, wg)
 	}
 
 	wg.Wait()
-	close(s.jobChan)
 	close(resultChan)
 	<-doneChan
 
-	s.Stop()
-
-	return results, nil
+	return targetErr
 }
 
 func (s *SynScanner) scanHost(job hostJob) (Result, error) {
 
 	result := NewResult(job.ip)
 
-	select {
-	case <-job.ctx.Done():
-		return result, nil
-	default:
-	}
-
-	router, err := routing.New()
-	if err != nil {
-		return result, err
-	}
-	networkInterface, gateway, srcIP, err := router.Route(job.ip)
+	networkInterface, srcIP, hwaddr, err := s.route(job.ip)
 	if err != nil {
 		return result, err
 	}
@@ -261,56 +312,20 @@ func (s *SynScanner) scanHost(job hostJob) (Result, error) {
 
 	startTime := time.Now()
 
-	go func() {
-		for {
-			select {
-			case open := <-openChan:
-				if open == 0 {
-					close(doneChan)
-					return
-				}
-				if result.Latency < 0 {
-					result.Latency = time.Since(startTime)
-				}
-				for _, existing := range result.Open {
-					if existing == open {
-						continue
-					}
-				}
-				result.Open = append(result.Open, open)
-			case closed := <-closedChan:
-				if result.Latency < 0 {
-					result.Latency = time.Since(startTime)
-				}
-				for _, existing := range result.Closed {
-					if existing == closed {
-						continue
-					}
-				}
-				result.Closed = append(result.Closed, closed)
-			case filtered := <-filteredChan:
-				if result.Latency < 0 {
-					result.Latency = time.Since(startTime)
-				}
-				for _, existing := range result.Filtered {
-					if existing == filtered {
-						continue
-					}
-				}
-				result.Filtered = append(result.Filtered, filtered)
-			}
+	markUp := func() {
+		if result.Latency < 0 {
+			result.Latency = time.Since(startTime)
+			s.emit(Event{Type: EventHostUp, Host: job.ip})
 		}
-	}()
-
-	rawPort, err := freeport.GetFreePort()
-	if err != nil {
-		return result, err
 	}
 
-	// First off, get the MAC address we should be sending packets to.
-	hwaddr, err := s.getHwAddr(job.ip, gateway, srcIP, networkInterface)
-	if err != nil {
-		return result, err
+	go collectPortStates(&result, openChan, closedChan, filteredChan, doneChan, markUp)
+
+	rawPort := s.sourcePort
+	if rawPort == 0 {
+		if rawPort, err = freeport.GetFreePort(); err != nil {
+			return result, err
+		}
 	}
 
 	// Construct all the network layers we need.
@@ -335,24 +350,28 @@ func (s *SynScanner) scanHost(job hostJob) (Result, error) {
 
 	listenChan := make(chan struct{})
 
-	ipFlow := gopacket.NewFlow(layers.EndpointIPv4, job.ip, srcIP)
+	// ports which have replied, so that only unanswered probes are retried, and when each probe was last sent, to
+	// measure round trip times
+	answered := map[int]bool{}
+	sent := map[int]time.Time{}
+	answeredMutex := sync.Mutex{}
+	answer := func(port int) {
+		answeredMutex.Lock()
+		defer answeredMutex.Unlock()
+		if at, ok := sent[port]; ok && !answered[port] {
+			s.observeRTT(time.Since(at))
+		}
+		answered[port] = true
+	}
 
 	go func() {
 
-		eth := &layers.Ethernet{}
-		ip4 := &layers.IPv4{}
-		tcp := &layers.TCP{}
-
-		parser := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, eth, ip4, tcp)
+		replies := newSynReplyDecoder(job.ip, srcIP, rawPort)
 
+		// this reads until the handle is closed: once the timeout has passed after the last probe is sent, or
+		// shortly after the scan is cancelled
 		for {
 
-			select {
-			case <-job.ctx.Done():
-				break
-			default:
-			}
-
 			// Read in the next packet.
 			data, _, err := handle.ReadPacketData()
 			if err == pcap.NextErrorTimeoutExpired {
@@ -361,29 +380,22 @@ func (s *SynScanner) scanHost(job hostJob) (Result, error) {
 				break
 			} else if err != nil {
 				// connection closed
-				fmt.Printf("Packet read error: %s\n", err)
+				logrus.Debugf("Packet read error: %s", err)
+				s.emitError(job.ip, 0, err)
 				continue
 			}
 
-			decoded := []gopacket.LayerType{}
-			if err := parser.DecodeLayers(data, &decoded); err != nil {
+			port, state, ok := replies.decode(data)
+			if !ok {
 				continue
 			}
-			for _, layerType := range decoded {
-				switch layerType {
-				case layers.LayerTypeIPv4:
-					if ip4.NetworkFlow() != ipFlow {
-						continue
-					}
-				case layers.LayerTypeTCP:
-					if tcp.DstPort != layers.TCPPort(rawPort) {
-						continue
-					} else if tcp.SYN && tcp.ACK {
-						openChan <- int(tcp.SrcPort)
-					} else if tcp.RST {
-						closedChan <- int(tcp.SrcPort)
-					}
-				}
+			answer(port)
+			if state == PortOpen {
+				s.emitPortState(job.ip, port, PortOpen, ReasonSynAck, replies.ip4.TTL)
+				openChan <- port
+			} else {
+				s.emitPortState(job.ip, port, PortClosed, ReasonReset, replies.ip4.TTL)
+				closedChan <- port
 			}
 
 		}
@@ -392,12 +404,58 @@ func (s *SynScanner) scanHost(job hostJob) (Result, error) {
 
 	}()
 
-	for _, port := range job.ports {
-		tcp.DstPort = layers.TCPPort(port)
-		_ = s.send(handle, &eth, &ip4, &tcp)
+	// replies to probes which are in flight when the scan is cancelled are read for a short grace period
+	timeout := s.currentTimeout(s.probeTimeout())
+	drain, stopDrain := afterCancel(job.ctx, gracePeriod(timeout))
+	defer stopDrain()
+	go func() {
+		select {
+		case <-drain.Done():
+			handle.Close()
+		case <-listenChan:
+		}
+	}()
+
+	pending := job.ports
+	for attempt := 0; attempt <= s.retries && len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			// give the previous probes time to be answered before sending them again
+			select {
+			case <-time.After(s.currentTimeout(s.probeTimeout())):
+			case <-job.ctx.Done():
+			}
+			answeredMutex.Lock()
+			unanswered := []int{}
+			for _, port := range pending {
+				if !answered[port] {
+					unanswered = append(unanswered, port)
+				}
+			}
+			answeredMutex.Unlock()
+			pending = unanswered
+		}
+
+		eventType := EventProbeSent
+		if attempt > 0 {
+			eventType = EventRetransmit
+		}
+		for _, port := range pending {
+			if job.ctx.Err() != nil || s.wait(job.ctx) != nil || s.pace(job.ctx) != nil {
+				break
+			}
+			tcp.DstPort = layers.TCPPort(port)
+			answeredMutex.Lock()
+			sent[port] = time.Now()
+			answeredMutex.Unlock()
+			if err := s.send(handle, &eth, &ip4, &tcp); err != nil {
+				s.emitError(job.ip, port, err)
+				continue
+			}
+			s.emit(Event{Type: eventType, Host: job.ip, Port: port, Protocol: "tcp"})
+		}
 	}
 
-	timer := time.AfterFunc(s.timeout, func() { handle.Close() })
+	timer := time.AfterFunc(s.currentTimeout(s.probeTimeout()), func() { handle.Close() })
 	defer timer.Stop()
 
 	<-listenChan
@@ -405,9 +463,171 @@ func (s *SynScanner) scanHost(job hostJob) (Result, error) {
 	close(openChan)
 	<-doneChan
 
+	if job.ctx.Err() != nil {
+		result.Incomplete = true
+		return result, nil
+	}
+
+	if !result.IsHostUp() {
+		s.emit(Event{Type: EventHostTimeout, Host: job.ip})
+	}
+
+	if s.osDetection && len(result.Open) > 0 {
+		closedPort := 0
+		if len(result.Closed) > 0 {
+			closedPort = result.Closed[0]
+		}
+		fingerprint, err := s.fingerprintOS(job.ip, networkInterface, srcIP, hwaddr, result.Open[0], closedPort)
+		if err != nil {
+			return result, err
+		}
+		result.OS = fingerprint
+	}
+
 	return result, nil
 }
 
-func (s *SynScanner) OutputResult(result Result) {
-	fmt.Println(result.String())
+// collectPortStates adds ports from each channel to the result until a zero is sent on open, then closes done. A port
+// can be answered more than once, e.g. by the first probe and a retransmission, but is only added once for each state.
+func collectPortStates(result *Result, open, closed, filtered <-chan int, done chan<- struct{}, markUp func()) {
+	seenOpen := map[int]bool{}
+	seenClosed := map[int]bool{}
+	seenFiltered := map[int]bool{}
+	for {
+		select {
+		case port := <-open:
+			if port == 0 {
+				close(done)
+				return
+			}
+			markUp()
+			if !seenOpen[port] {
+				seenOpen[port] = true
+				result.Open = append(result.Open, port)
+			}
+		case port := <-closed:
+			markUp()
+			if !seenClosed[port] {
+				seenClosed[port] = true
+				result.Closed = append(result.Closed, port)
+			}
+		case port := <-filtered:
+			markUp()
+			if !seenFiltered[port] {
+				seenFiltered[port] = true
+				result.Filtered = append(result.Filtered, port)
+			}
+		}
+	}
+}
+
+// route determines the interface and source address to use when sending packets to ip, along with the MAC address
+// of the next hop. Errors are returned as a *HostError describing which step failed.
+func (s *SynScanner) route(ip net.IP) (*net.Interface, net.IP, net.HardwareAddr, error) {
+
+	router, err := routing.New()
+	if err != nil {
+		return nil, nil, nil, newHostError(HostLocalError, err)
+	}
+
+	var networkInterface *net.Interface
+	var gateway, srcIP net.IP
+	if s.iface != "" {
+		networkInterface, gateway, srcIP, err = routeVia(router, s.iface, ip)
+	} else {
+		networkInterface, gateway, srcIP, err = router.Route(ip)
+	}
+	if err != nil {
+		return nil, nil, nil, newHostError(HostUnreachable, err)
+	}
+
+	hwaddr, err := s.getHwAddr(ip, gateway, srcIP, networkInterface)
+	if err != nil {
+		return nil, nil, nil, newHostError(HostARPFailed, err)
+	}
+
+	return networkInterface, srcIP, hwaddr, nil
+}
+
+// routeVia determines how to reach ip from the named interface, rather than the one the routing table would choose.
+// Addresses on one of the interface's networks are reached directly, and anything else through the interface's
+// gateway.
+func routeVia(router routing.Router, name string, ip net.IP) (*net.Interface, net.IP, net.IP, error) {
+
+	networkInterface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	addrs, err := networkInterface.Addrs()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var srcIP net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		if ipnet.Contains(ip) {
+			return networkInterface, nil, ipnet.IP.To4(), nil
+		}
+		if srcIP == nil {
+			srcIP = ipnet.IP.To4()
+		}
+	}
+	if srcIP == nil {
+		return nil, nil, nil, fmt.Errorf("interface %s has no IPv4 address", name)
+	}
+
+	routed, gateway, _, err := router.RouteWithSrc(nil, srcIP, ip)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if routed.Index != networkInterface.Index || gateway == nil {
+		return nil, nil, nil, fmt.Errorf("no route to %s via %s", ip, name)
+	}
+	return networkInterface, gateway, srcIP, nil
+}
+
+// synReplyDecoder picks out the replies to SYN probes from the packets captured on an interface. Other hosts may be
+// scanned at the same time from the same source port, so replies must come from the host being scanned.
+type synReplyDecoder struct {
+	parser  *gopacket.DecodingLayerParser
+	eth     layers.Ethernet
+	ip4     layers.IPv4
+	tcp     layers.TCP
+	decoded []gopacket.LayerType
+	flow    gopacket.Flow
+	port    layers.TCPPort
+}
+
+func newSynReplyDecoder(host net.IP, source net.IP, port int) *synReplyDecoder {
+	d := &synReplyDecoder{
+		flow: gopacket.NewFlow(layers.EndpointIPv4, host.To4(), source.To4()),
+		port: layers.TCPPort(port),
+	}
+	d.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &d.eth, &d.ip4, &d.tcp)
+	return d
+}
+
+// decode returns the port a captured packet replies from and the state it shows (PortOpen for a SYN-ACK, PortClosed
+// for a RST), or false if the packet is not a reply from the host to the probes' source port.
+func (d *synReplyDecoder) decode(data []byte) (int, PortState, bool) {
+	if err := d.parser.DecodeLayers(data, &d.decoded); err != nil {
+		return 0, PortUnknown, false
+	}
+	if len(d.decoded) < 3 || d.decoded[2] != layers.LayerTypeTCP {
+		return 0, PortUnknown, false
+	}
+	if d.ip4.NetworkFlow() != d.flow || d.tcp.DstPort != d.port {
+		return 0, PortUnknown, false
+	}
+	switch {
+	case d.tcp.SYN && d.tcp.ACK:
+		return int(d.tcp.SrcPort), PortOpen, true
+	case d.tcp.RST:
+		return int(d.tcp.SrcPort), PortClosed, true
+	}
+	return 0, PortUnknown, false
 }
@@ -0,0 +1,85 @@
+package scan
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectPortStatesIgnoresRepeatedReplies(t *testing.T) {
+	result := Result{}
+	open := make(chan int)
+	closed := make(chan int)
+	filtered := make(chan int)
+	done := make(chan struct{})
+	ups := 0
+
+	go collectPortStates(&result, open, closed, filtered, done, func() { ups++ })
+
+	// a late reply to the first probe, then a reply to the retransmission
+	open <- 80
+	open <- 80
+	closed <- 81
+	closed <- 81
+	filtered <- 82
+	open <- 443
+	open <- 0
+	<-done
+
+	assert.Equal(t, []int{80, 443}, result.Open)
+	assert.Equal(t, []int{81}, result.Closed)
+	assert.Equal(t, []int{82}, result.Filtered)
+	assert.Equal(t, 6, ups)
+}
+
+func synReply(t *testing.T, from string, to string, srcPort int, dstPort int, synAck bool) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{6, 7, 8, 9, 10, 11},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip4 := &layers.IPv4{
+		SrcIP:    net.ParseIP(from).To4(),
+		DstIP:    net.ParseIP(to).To4(),
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		SYN:     synAck,
+		ACK:     synAck,
+		RST:     !synAck,
+	}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip4))
+	buffer := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, eth, ip4, tcp))
+	return buffer.Bytes()
+}
+
+func TestSynReplyDecoderIgnoresOtherHosts(t *testing.T) {
+	// both hosts are scanned at once from the same source port
+	replies := newSynReplyDecoder(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.100"), 40000)
+
+	port, state, ok := replies.decode(synReply(t, "10.0.0.1", "10.0.0.100", 22, 40000, true))
+	assert.True(t, ok)
+	assert.Equal(t, 22, port)
+	assert.Equal(t, PortOpen, state)
+
+	port, state, ok = replies.decode(synReply(t, "10.0.0.1", "10.0.0.100", 23, 40000, false))
+	assert.True(t, ok)
+	assert.Equal(t, 23, port)
+	assert.Equal(t, PortClosed, state)
+
+	_, _, ok = replies.decode(synReply(t, "10.0.0.2", "10.0.0.100", 80, 40000, true))
+	assert.False(t, ok, "reply from another host")
+	_, _, ok = replies.decode(synReply(t, "10.0.0.2", "10.0.0.100", 81, 40000, false))
+	assert.False(t, ok, "reset from another host")
+	_, _, ok = replies.decode(synReply(t, "10.0.0.1", "10.0.0.100", 443, 40001, true))
+	assert.False(t, ok, "reply to another source port")
+}
@@ -0,0 +1,354 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mostlygeek/arp"
+)
+
+// UPnPInfo describes a UPnP responder and the devices and services it exposes.
+type UPnPInfo struct {
+	Server      string
+	Location    string
+	Devices     []UPnPDevice
+	PortMapping bool
+}
+
+// UPnPDevice is a device (or embedded device) from a UPnP device description document.
+type UPnPDevice struct {
+	DeviceType       string        `xml:"deviceType"`
+	FriendlyName     string        `xml:"friendlyName"`
+	Manufacturer     string        `xml:"manufacturer"`
+	ModelName        string        `xml:"modelName"`
+	ModelNumber      string        `xml:"modelNumber"`
+	ModelDescription string        `xml:"modelDescription"`
+	SerialNumber     string        `xml:"serialNumber"`
+	FirmwareVersion  string        `xml:"firmwareVersion"`
+	SoftwareVersion  string        `xml:"softwareVersion"`
+	UDN              string        `xml:"UDN"`
+	PresentationURL  string        `xml:"presentationURL"`
+	Services         []UPnPService `xml:"serviceList>service"`
+	Devices          []UPnPDevice  `xml:"deviceList>device"`
+}
+
+// UPnPService is a service exposed by a UPnP device.
+type UPnPService struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+const (
+	ssdpMulticastAddress = "239.255.255.250"
+	ssdpDefaultPort      = 1900
+	ssdpMaxDescription   = 1 << 20
+)
+
+// UPnPScanner discovers UPnP devices with SSDP, both via multicast on the local segment and unicast to each target,
+// and then fetches each responder's device description.
+type UPnPScanner struct {
+	ssdpPort int
+	retries  int
+	eventEmitter
+	controllable
+	timing
+	defaultTargets
+}
+
+// NewUPnPScanner creates a UPnP scanner of the targets in ti.
+//
+// Deprecated: use New, which takes Options and creates a scanner which can be reused for any targets. Scanners
+// created with NewUPnPScanner scan ti when Scan is passed nil targets.
+func NewUPnPScanner(ti *TargetIterator, timeout time.Duration) *UPnPScanner {
+	s := newUPnPScanner(Options{Type: "upnp", Timeout: timeout})
+	s.setDefaultTargets(ti)
+	return s
+}
+
+func newUPnPScanner(options Options) *UPnPScanner {
+	options = options.withDefaults()
+	s := &UPnPScanner{
+		ssdpPort: ssdpDefaultPort,
+		retries:  options.Retries,
+	}
+	options.configure(&s.eventEmitter, &s.controllable)
+	s.setTiming(options)
+	return s
+}
+
+func (s *UPnPScanner) Start() error {
+
+	return nil
+}
+
+func (s *UPnPScanner) Stop() {
+
+}
+
+type ssdpResponse struct {
+	host     net.IP
+	latency  time.Duration
+	location string
+	server   string
+}
+
+func (s *UPnPScanner) Scan(ctx context.Context, targets Targets, ports []int, handler ResultHandler) error {
+
+	targets, err := s.targetsOr(targets)
+	if err != nil {
+		return err
+	}
+
+	addresses := []net.IP{}
+	targetSet := map[string]bool{}
+	for {
+		ip, err := targets.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		tIP := make([]byte, len(ip))
+		copy(tIP, ip)
+		addresses = append(addresses, tIP)
+		targetSet[net.IP(tIP).String()] = true
+	}
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// replies which are in flight when the scan is cancelled are read for a short grace period
+	timeout := s.currentTimeout(s.probeTimeout())
+	drain, stopDrain := afterCancel(ctx, gracePeriod(timeout))
+	defer stopDrain()
+	go func() {
+		<-drain.Done()
+		_ = conn.SetReadDeadline(time.Now())
+	}()
+
+	// targets which haven't replied are searched again, once for each retry
+	responses := map[string]*ssdpResponse{}
+	eventType := EventProbeSent
+	for attempt := 0; attempt <= s.retries && ctx.Err() == nil; attempt++ {
+		start := time.Now()
+
+		// multicast reaches devices which ignore unicast discovery, but can fail on hosts without a multicast route
+		_ = s.search(conn, net.ParseIP(ssdpMulticastAddress))
+		for _, target := range addresses {
+			if _, ok := responses[target.String()]; ok {
+				continue
+			}
+			// stop searching as soon as the scan is cancelled, but still report the devices which replied
+			if ctx.Err() != nil || s.wait(ctx) != nil || s.pace(ctx) != nil {
+				break
+			}
+			if err := s.search(conn, target); err != nil {
+				s.emitError(target, 0, err)
+				continue
+			}
+			s.emit(Event{Type: eventType, Host: target, Port: s.ssdpPort, Protocol: "udp"})
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(timeout + time.Second))
+		if drain.Err() != nil {
+			_ = conn.SetReadDeadline(time.Now())
+		}
+		s.readResponses(conn, targetSet, responses, start)
+		if len(responses) == len(targetSet) {
+			break
+		}
+		timeout = s.currentTimeout(s.probeTimeout())
+		eventType = EventRetransmit
+	}
+
+	resultChan := make(chan *Result)
+	doneChan := make(chan struct{})
+
+	go func() {
+		for result := range resultChan {
+			handler(*result)
+		}
+		close(doneChan)
+	}()
+
+	cancelled := ctx.Err() != nil
+
+	wg := &sync.WaitGroup{}
+	for _, target := range addresses {
+		result := NewResult(target)
+		result.Incomplete = cancelled
+		response, ok := responses[target.String()]
+		if !ok {
+			if reportable(result) {
+				s.emit(Event{Type: EventHostTimeout, Host: target})
+				resultChan <- &result
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(result *Result, response *ssdpResponse) {
+			defer wg.Done()
+			result.Latency = response.latency
+			info := &UPnPInfo{
+				Server:   response.server,
+				Location: response.location,
+			}
+			if device, err := s.describe(ctx, response.host, response.location); err == nil {
+				info.Devices = []UPnPDevice{*device}
+				info.PortMapping = device.exposesPortMapping()
+				result.Name = device.FriendlyName
+			} else if ctx.Err() == nil {
+				s.emitError(result.Host, 0, err)
+			} else {
+				result.Incomplete = true
+			}
+			result.UPnP = info
+
+			if mac, err := net.ParseMAC(arp.Search(result.Host.String())); err == nil && mac.String() != "00:00:00:00:00:00" {
+				result.MAC = mac.String()
+				result.Manufacturer = lookupManufacturer(mac)
+			}
+			if result.Manufacturer == "" && len(info.Devices) > 0 {
+				result.Manufacturer = info.Devices[0].Manufacturer
+			}
+			resultChan <- result
+		}(&result, response)
+	}
+	wg.Wait()
+	close(resultChan)
+	<-doneChan
+
+	return nil
+}
+
+// readResponses reads SSDP responses from targets until the connection's read deadline, adding the first from each
+// target to responses.
+func (s *UPnPScanner) readResponses(conn net.PacketConn, targetSet map[string]bool, responses map[string]*ssdpResponse, start time.Time) {
+	buffer := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || !targetSet[udpAddr.IP.String()] {
+			continue
+		}
+		if _, ok := responses[udpAddr.IP.String()]; ok {
+			continue
+		}
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buffer[:n])), nil)
+		if err != nil {
+			continue
+		}
+		response.Body.Close()
+		s.emit(Event{Type: EventHostUp, Host: udpAddr.IP})
+		responses[udpAddr.IP.String()] = &ssdpResponse{
+			host:     udpAddr.IP,
+			latency:  time.Since(start),
+			location: response.Header.Get("Location"),
+			server:   response.Header.Get("Server"),
+		}
+	}
+}
+
+// search sends an SSDP M-SEARCH for all devices and services to the given address
+func (s *UPnPScanner) search(conn net.PacketConn, target net.IP) error {
+	request := strings.Join([]string{
+		"M-SEARCH * HTTP/1.1",
+		fmt.Sprintf("HOST: %s:%d", ssdpMulticastAddress, ssdpDefaultPort),
+		`MAN: "ssdp:discover"`,
+		"MX: 1",
+		"ST: ssdp:all",
+		"", "",
+	}, "\r\n")
+	_, err := conn.WriteTo([]byte(request), &net.UDPAddr{IP: target, Port: s.ssdpPort})
+	return err
+}
+
+// describe fetches and parses the UPnP device description document at location. Responses to M-SEARCH can come
+// from anyone, so the document is only fetched over HTTP(S) from the host which responded, and redirects are not
+// followed, to keep responders from making the scanner request arbitrary URLs.
+func (s *UPnPScanner) describe(ctx context.Context, host net.IP, location string) (*UPnPDevice, error) {
+	if location == "" {
+		return nil, fmt.Errorf("no device description location")
+	}
+
+	descriptionURL, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if descriptionURL.Scheme != "http" && descriptionURL.Scheme != "https" {
+		return nil, fmt.Errorf("device description location %s is not an HTTP URL", location)
+	}
+	if ip := net.ParseIP(descriptionURL.Hostname()); ip == nil || !ip.Equal(host) {
+		return nil, fmt.Errorf("device description location %s is not on the responding host %s", location, host)
+	}
+
+	request, err := http.NewRequest(http.MethodGet, descriptionURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout: s.currentTimeout(s.probeTimeout()),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching device description: %s", response.Status)
+	}
+
+	root := struct {
+		Device UPnPDevice `xml:"device"`
+	}{}
+	if err := xml.NewDecoder(io.LimitReader(response.Body, ssdpMaxDescription)).Decode(&root); err != nil {
+		return nil, err
+	}
+	return &root.Device, nil
+}
+
+// Firmware returns the firmware or software version of the device, if it reports one.
+func (d UPnPDevice) Firmware() string {
+	if d.FirmwareVersion != "" {
+		return d.FirmwareVersion
+	}
+	return d.SoftwareVersion
+}
+
+// exposesPortMapping reports whether the device or any embedded device offers a WAN connection service, which
+// allows port mappings to be added to the router
+func (d UPnPDevice) exposesPortMapping() bool {
+	for _, service := range d.Services {
+		if strings.Contains(service.ServiceType, ":WANIPConnection:") || strings.Contains(service.ServiceType, ":WANPPPConnection:") {
+			return true
+		}
+	}
+	for _, embedded := range d.Devices {
+		if embedded.exposesPortMapping() {
+			return true
+		}
+	}
+	return false
+}
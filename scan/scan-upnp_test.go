@@ -0,0 +1,131 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDeviceDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+		<friendlyName>Home Router</friendlyName>
+		<manufacturer>Acme</manufacturer>
+		<modelName>RT-1000</modelName>
+		<modelNumber>v2</modelNumber>
+		<firmwareVersion>1.2.3</firmwareVersion>
+		<deviceList>
+			<device>
+				<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+				<serviceList>
+					<service>
+						<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+						<serviceId>urn:upnp-org:serviceId:WANIPConn1</serviceId>
+						<controlURL>/ctl/IPConn</controlURL>
+					</service>
+				</serviceList>
+			</device>
+		</deviceList>
+	</device>
+</root>`
+
+func TestUPnPScan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testDeviceDescription))
+	}))
+	defer server.Close()
+
+	conn := serveUDP(t, func(request []byte) []byte {
+		return []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nLOCATION: %s/rootDesc.xml\r\nSERVER: Linux/4.4 UPnP/1.1 MiniUPnPd/2.1\r\nST: upnp:rootdevice\r\n\r\n", server.URL))
+	})
+	defer conn.Close()
+
+	scanner := newUPnPScanner(Options{Timeout: 500 * time.Millisecond})
+	scanner.ssdpPort = conn.LocalAddr().(*net.UDPAddr).Port
+
+	results, err := Collect(context.Background(), scanner, NewTargetIterator("127.0.0.1"), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	result := results[0]
+	assert.True(t, result.IsHostUp())
+	assert.Equal(t, "Home Router", result.Name)
+	require.NotNil(t, result.UPnP)
+	assert.Equal(t, "Linux/4.4 UPnP/1.1 MiniUPnPd/2.1", result.UPnP.Server)
+	assert.True(t, result.UPnP.PortMapping)
+	require.Len(t, result.UPnP.Devices, 1)
+	assert.Equal(t, "Acme", result.UPnP.Devices[0].Manufacturer)
+	assert.Equal(t, "1.2.3", result.UPnP.Devices[0].Firmware())
+	assert.Equal(t, "urn:schemas-upnp-org:service:WANIPConnection:1", result.UPnP.Devices[0].Devices[0].Services[0].ServiceType)
+}
+
+func TestUPnPScanRetries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testDeviceDescription))
+	}))
+	defer server.Close()
+
+	// the first search is lost
+	searches := 0
+	conn := serveUDP(t, func(request []byte) []byte {
+		searches++
+		if searches == 1 {
+			return nil
+		}
+		return []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nLOCATION: %s/rootDesc.xml\r\nST: upnp:rootdevice\r\n\r\n", server.URL))
+	})
+	defer conn.Close()
+
+	retransmits := 0
+	scanner := newUPnPScanner(Options{Timeout: 200 * time.Millisecond, Retries: 1, EventSink: func(event Event) {
+		if event.Type == EventRetransmit {
+			retransmits++
+		}
+	}})
+	scanner.ssdpPort = conn.LocalAddr().(*net.UDPAddr).Port
+
+	results, err := Collect(context.Background(), scanner, NewTargetIterator("127.0.0.1"), nil)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].IsHostUp())
+	assert.Equal(t, "Home Router", results[0].Name)
+	assert.Equal(t, 1, retransmits)
+}
+
+func TestUPnPDescribeOnlyFetchesFromResponder(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/rootDesc.xml", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte(testDeviceDescription))
+	}))
+	defer server.Close()
+
+	scanner := newUPnPScanner(Options{Timeout: 500 * time.Millisecond})
+	responder := net.ParseIP("127.0.0.1")
+
+	device, err := scanner.describe(context.Background(), responder, server.URL+"/rootDesc.xml")
+	require.NoError(t, err)
+	assert.Equal(t, "Home Router", device.FriendlyName)
+	assert.Equal(t, 1, requests)
+
+	// another host, a scheme other than HTTP(S) and redirects are all refused
+	_, err = scanner.describe(context.Background(), net.ParseIP("192.0.2.1"), server.URL+"/rootDesc.xml")
+	assert.Error(t, err)
+	_, err = scanner.describe(context.Background(), responder, "file:///etc/passwd")
+	assert.Error(t, err)
+	_, err = scanner.describe(context.Background(), responder, server.URL+"/redirect")
+	assert.Error(t, err)
+	assert.Equal(t, 2, requests, "only the redirect itself is requested")
+}
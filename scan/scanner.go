@@ -1,10 +1,101 @@
 package scan
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
+// ResultHandler is called with the result for each host as soon as the host is complete. Scanners never call the
+// handler concurrently, so it does not need to be safe for concurrent use.
+type ResultHandler func(result Result)
+
+// Scanner scans hosts for open ports. Scanners are created with New, and can run Scan any number of times until Stop
+// is called.
 type Scanner interface {
+	// Stop releases the scanner's workers. The scanner can't be used after it is stopped.
 	Stop()
+	// Start starts the scanner's workers. New starts scanners, and calling Start again has no effect.
 	Start() error
-	Scan(ctx context.Context, ports []int) ([]Result, error)
-	OutputResult(result Result)
+	// Scan scans the given ports on every target, passing each result to handler as soon as its host is complete.
+	Scan(ctx context.Context, targets Targets, ports []int, handler ResultHandler) error
+	// SetEventSink sets an optional sink which receives fine-grained events as the scan progresses.
+	SetEventSink(sink EventSink)
+	// SetControl sets an optional control, used to pause, resume and throttle the scan while it is running.
+	SetControl(control *Control)
+}
+
+// defaultHostGroupSize is the number of hosts which are scanned at once, which keeps goroutines and memory bounded
+// on large sweeps.
+const defaultHostGroupSize = 256
+
+// maxGracePeriod is the longest scanners keep waiting for replies to probes which are in flight when a scan is
+// cancelled. Scanners with a shorter timeout wait for that instead.
+const maxGracePeriod = time.Second
+
+// gracePeriod returns how long to wait for replies to probes in flight when a scan is cancelled.
+func gracePeriod(timeout time.Duration) time.Duration {
+	if timeout < maxGracePeriod {
+		return timeout
+	}
+	return maxGracePeriod
+}
+
+// afterCancel returns a context which is cancelled grace after ctx is, giving work which is in flight when a scan is
+// cancelled a short time to finish. The returned function releases the context once it is no longer needed.
+func afterCancel(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	drain, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+			timer := time.NewTimer(grace)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-drain.Done():
+			}
+			cancel()
+		case <-drain.Done():
+		}
+	}()
+	return drain, cancel
+}
+
+// reportable reports whether a result should be passed to the result handler. A host which was interrupted by
+// cancellation before it replied is left out, since there is no way to tell whether it is down.
+func reportable(result Result) bool {
+	return !result.Incomplete || result.IsHostUp() || result.Error != nil
+}
+
+// defaultTargets is embedded by scanners to hold the targets given to their deprecated constructors, which are scanned
+// when Scan is passed nil targets.
+type defaultTargets struct {
+	targets Targets
+}
+
+// setDefaultTargets sets the targets to scan when Scan is passed nil targets.
+func (d *defaultTargets) setDefaultTargets(ti *TargetIterator) {
+	if ti != nil {
+		d.targets = ti
+	}
+}
+
+// targetsOr returns targets, or the default targets if targets is nil. It returns an error if neither are set.
+func (d *defaultTargets) targetsOr(targets Targets) (Targets, error) {
+	if targets != nil {
+		return targets, nil
+	}
+	if d.targets == nil {
+		return nil, fmt.Errorf("no targets to scan")
+	}
+	return d.targets, nil
+}
+
+// Collect runs a scan and returns every result once it is complete.
+func Collect(ctx context.Context, scanner Scanner, targets Targets, ports []int) ([]Result, error) {
+	results := []Result{}
+	err := scanner.Scan(ctx, targets, ports, func(result Result) {
+		results = append(results, result)
+	})
+	return results, err
 }
@@ -0,0 +1,703 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DatastoreInfo describes a database or cache service, and whether it can be accessed without credentials.
+type DatastoreInfo struct {
+	Product         string
+	Version         string
+	Unauthenticated bool
+	AuthMethod      string
+	SSL             bool
+	Notes           []string
+}
+
+// Details summarises the information for display.
+func (i *DatastoreInfo) Details() []string {
+	access := "authentication required"
+	if i.Unauthenticated {
+		access = "UNAUTHENTICATED ACCESS POSSIBLE"
+	}
+	if i.AuthMethod != "" {
+		access = fmt.Sprintf("%s (%s)", access, i.AuthMethod)
+	}
+	details := []string{fmt.Sprintf("%s: %s", strings.ToLower(i.Product), access)}
+	if i.SSL {
+		details = append(details, fmt.Sprintf("%s: ssl supported", strings.ToLower(i.Product)))
+	}
+	for _, note := range i.Notes {
+		details = append(details, fmt.Sprintf("%s: %s", strings.ToLower(i.Product), note))
+	}
+	return details
+}
+
+func init() {
+	registerServiceModule(mysqlModule{})
+	registerServiceModule(mysqlModule{emptyPassword: true})
+	registerServiceModule(postgresModule{})
+	registerServiceModule(redisModule{})
+	registerServiceModule(mongoModule{})
+	registerServiceModule(memcachedModule{})
+	registerServiceModule(elasticsearchModule{})
+}
+
+func dialService(host net.IP, port int, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host.String(), fmt.Sprintf("%d", port)), timeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout * 2))
+	return conn, nil
+}
+
+func setDatastore(service *Service, name string, info *DatastoreInfo) {
+	service.Name = name
+	service.Version = info.Version
+	service.Datastore = info
+}
+
+// MySQL / MariaDB
+
+// mysqlModule reads the server greeting. With emptyPassword set, it is the mysql-empty-password module, which also
+// tries to log in as root with an empty password. That is credential guessing, so it only runs when named.
+type mysqlModule struct {
+	emptyPassword bool
+}
+
+const (
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSecureConnection = 0x00008000
+	mysqlClientPluginAuth       = 0x00080000
+	mysqlClientSSL              = 0x00000800
+)
+
+func (m mysqlModule) Name() string {
+	if m.emptyPassword {
+		return "mysql-empty-password"
+	}
+	return "mysql"
+}
+
+func (m mysqlModule) Intrusive() bool {
+	return m.emptyPassword
+}
+
+func (mysqlModule) Match(port int, banner string) bool {
+	return port == 3306 || strings.Contains(banner, "mysql_native_password") || strings.Contains(banner, "caching_sha2_password")
+}
+
+func readMySQLPacket(reader io.Reader) ([]byte, byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, 0, err
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, err
+	}
+	return payload, header[3], nil
+}
+
+// Identify reads the server greeting, then for the mysql-empty-password module, attempts to log in as root with an
+// empty password.
+func (m mysqlModule) Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error {
+	conn, err := dialService(host, port, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	greeting, _, err := readMySQLPacket(conn)
+	if err != nil {
+		return err
+	}
+	if len(greeting) == 0 {
+		return errors.New("empty MySQL greeting")
+	}
+
+	info := &DatastoreInfo{Product: "MySQL"}
+
+	if greeting[0] == 0xff {
+		if len(greeting) > 3 {
+			info.Notes = append(info.Notes, fmt.Sprintf("connection refused: %s", string(greeting[3:])))
+		}
+		setDatastore(service, "mysql", info)
+		return nil
+	}
+	if greeting[0] != 0x0a {
+		return errors.New("unexpected MySQL protocol version")
+	}
+
+	end := bytes.IndexByte(greeting[1:], 0)
+	if end < 0 {
+		return errors.New("malformed MySQL greeting")
+	}
+	info.Version = string(greeting[1 : 1+end])
+	if strings.Contains(strings.ToLower(info.Version), "mariadb") {
+		info.Product = "MariaDB"
+	}
+
+	// skip thread id, first auth data part and filler to reach the lower capability flags
+	rest := greeting[1+end+1:]
+	if len(rest) >= 15 {
+		capabilities := binary.LittleEndian.Uint16(rest[13:])
+		info.SSL = capabilities&mysqlClientSSL > 0
+	}
+	if index := bytes.Index(greeting, []byte("_password")); index > 0 {
+		start := bytes.LastIndexByte(greeting[:index], 0) + 1
+		info.AuthMethod = string(greeting[start : index+len("_password")])
+	}
+
+	if !m.emptyPassword {
+		setDatastore(service, strings.ToLower(info.Product), info)
+		return nil
+	}
+
+	response := make([]byte, 4)
+	binary.LittleEndian.PutUint32(response, mysqlClientLongPassword|mysqlClientProtocol41|mysqlClientSecureConnection|mysqlClientPluginAuth)
+	response = append(response, 0, 0, 0, 1, 33)
+	response = append(response, make([]byte, 23)...)
+	response = append(response, []byte("root\x00")...)
+	response = append(response, 0)
+	response = append(response, []byte("mysql_native_password\x00")...)
+
+	packet := []byte{byte(len(response)), byte(len(response) >> 8), byte(len(response) >> 16), 1}
+	if _, err := conn.Write(append(packet, response...)); err != nil {
+		return err
+	}
+
+	reply, _, err := readMySQLPacket(conn)
+	if err == nil && len(reply) > 0 && reply[0] == 0x00 {
+		info.Unauthenticated = true
+		info.Notes = append(info.Notes, "root login with empty password accepted")
+	}
+
+	setDatastore(service, strings.ToLower(info.Product), info)
+	return nil
+}
+
+// PostgreSQL
+
+type postgresModule struct{}
+
+const (
+	postgresSSLRequestCode = 80877103
+	postgresProtocol3      = 196608
+)
+
+var postgresAuthMethods = map[uint32]string{
+	0:  "trust",
+	2:  "kerberos",
+	3:  "password",
+	5:  "md5",
+	7:  "gss",
+	9:  "sspi",
+	10: "sasl",
+}
+
+func (postgresModule) Name() string {
+	return "postgres"
+}
+
+func (postgresModule) Match(port int, banner string) bool {
+	return port == 5432
+}
+
+// Identify checks whether SSL is supported, then sends a startup message for the postgres user to discover which
+// authentication method the server requires.
+func (postgresModule) Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error {
+	info := &DatastoreInfo{Product: "PostgreSQL"}
+
+	conn, err := dialService(host, port, timeout)
+	if err != nil {
+		return err
+	}
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request, 8)
+	binary.BigEndian.PutUint32(request[4:], postgresSSLRequestCode)
+	if _, err := conn.Write(request); err != nil {
+		conn.Close()
+		return err
+	}
+	response := make([]byte, 1)
+	_, err = io.ReadFull(conn, response)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	if response[0] != 'S' && response[0] != 'N' {
+		return errors.New("unexpected response to PostgreSQL SSLRequest")
+	}
+	info.SSL = response[0] == 'S'
+
+	conn, err = dialService(host, port, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	startup := make([]byte, 8)
+	binary.BigEndian.PutUint32(startup[4:], postgresProtocol3)
+	startup = append(startup, []byte("user\x00postgres\x00database\x00postgres\x00\x00")...)
+	binary.BigEndian.PutUint32(startup, uint32(len(startup)))
+	if _, err := conn.Write(startup); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break
+		}
+		length := int(binary.BigEndian.Uint32(header[1:])) - 4
+		if length < 0 || length > 65536 {
+			break
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			break
+		}
+
+		switch header[0] {
+		case 'R':
+			if len(body) < 4 {
+				break
+			}
+			method := binary.BigEndian.Uint32(body)
+			info.AuthMethod = postgresAuthMethods[method]
+			if method == 10 {
+				info.AuthMethod = strings.ToLower(strings.Trim(strings.Replace(string(body[4:]), "\x00", ",", -1), ","))
+			}
+			if method != 0 {
+				setDatastore(service, "postgresql", info)
+				return nil
+			}
+			info.Unauthenticated = true
+		case 'S':
+			parts := bytes.Split(body, []byte{0})
+			if len(parts) >= 2 && string(parts[0]) == "server_version" {
+				info.Version = string(parts[1])
+			}
+		case 'E':
+			for _, field := range bytes.Split(body, []byte{0}) {
+				if len(field) > 1 && field[0] == 'M' {
+					info.Notes = append(info.Notes, string(field[1:]))
+				}
+			}
+			setDatastore(service, "postgresql", info)
+			return nil
+		case 'Z':
+			setDatastore(service, "postgresql", info)
+			return nil
+		}
+	}
+
+	setDatastore(service, "postgresql", info)
+	return nil
+}
+
+// Redis
+
+type redisModule struct{}
+
+func (redisModule) Name() string {
+	return "redis"
+}
+
+func (redisModule) Match(port int, banner string) bool {
+	return port == 6379 || port == 6380
+}
+
+// Identify sends an unauthenticated INFO server command.
+func (redisModule) Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error {
+	conn, err := dialService(host, port, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("INFO server\r\n")); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimSpace(line)
+
+	info := &DatastoreInfo{Product: "Redis"}
+
+	switch {
+	case strings.HasPrefix(line, "-NOAUTH"), strings.HasPrefix(line, "-NOPERM"), strings.HasPrefix(line, "-ERR operation not permitted"):
+		info.AuthMethod = "password"
+	case strings.HasPrefix(line, "-DENIED"):
+		info.Notes = append(info.Notes, "protected mode enabled")
+	case strings.HasPrefix(line, "$"):
+		info.Unauthenticated = true
+		var length int
+		if _, err := fmt.Sscanf(line, "$%d", &length); err != nil || length < 0 || length > 65536 {
+			return errors.New("malformed Redis response")
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return err
+		}
+		for _, field := range strings.Split(string(body), "\n") {
+			if strings.HasPrefix(field, "redis_version:") {
+				info.Version = strings.TrimSpace(strings.TrimPrefix(field, "redis_version:"))
+			}
+		}
+	default:
+		return errors.New("unexpected Redis response")
+	}
+
+	setDatastore(service, "redis", info)
+	return nil
+}
+
+// MongoDB
+
+type mongoModule struct{}
+
+const mongoOpMsg = 2013
+
+func (mongoModule) Name() string {
+	return "mongodb"
+}
+
+func (mongoModule) Match(port int, banner string) bool {
+	return port >= 27017 && port <= 27019
+}
+
+// Identify sends hello and buildInfo, and then attempts to list databases without authenticating.
+func (m mongoModule) Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error {
+	conn, err := dialService(host, port, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	hello, err := m.command(conn, 1, "hello")
+	if err != nil {
+		return err
+	}
+
+	info := &DatastoreInfo{Product: "MongoDB"}
+	if wire, ok := hello["maxWireVersion"].(float64); ok {
+		info.Notes = append(info.Notes, fmt.Sprintf("max wire version %d", int(wire)))
+	}
+
+	if buildInfo, err := m.command(conn, 2, "buildInfo"); err == nil {
+		if version, ok := buildInfo["version"].(string); ok {
+			info.Version = version
+		}
+	}
+
+	if databases, err := m.command(conn, 3, "listDatabases"); err == nil {
+		if ok, _ := databases["ok"].(float64); ok == 1 {
+			info.Unauthenticated = true
+		}
+	}
+
+	setDatastore(service, "mongodb", info)
+	return nil
+}
+
+// command runs a simple admin command (e.g. {hello: 1}) with OP_MSG and returns the top level fields of the reply
+func (mongoModule) command(conn net.Conn, requestID int32, name string) (map[string]interface{}, error) {
+	document := encodeBSON([]bsonField{{name, int32(1)}, {"$db", "admin"}})
+
+	message := make([]byte, 16, 21+len(document))
+	binary.LittleEndian.PutUint32(message[4:], uint32(requestID))
+	binary.LittleEndian.PutUint32(message[12:], mongoOpMsg)
+	message = append(message, 0, 0, 0, 0, 0)
+	message = append(message, document...)
+	binary.LittleEndian.PutUint32(message, uint32(len(message)))
+
+	if _, err := conn.Write(message); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(header))
+	if length < 21 || length > 16*1024*1024 || binary.LittleEndian.Uint32(header[12:]) != mongoOpMsg {
+		return nil, errors.New("unexpected MongoDB response")
+	}
+	body := make([]byte, length-16)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	if body[4] != 0 {
+		return nil, errors.New("unexpected MongoDB message section")
+	}
+	return decodeBSON(body[5:])
+}
+
+type bsonField struct {
+	name  string
+	value interface{}
+}
+
+func encodeBSON(fields []bsonField) []byte {
+	document := []byte{0, 0, 0, 0}
+	for _, field := range fields {
+		switch value := field.value.(type) {
+		case int32:
+			document = append(document, 0x10)
+			document = append(document, field.name...)
+			document = append(document, 0, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(document[len(document)-4:], uint32(value))
+		case float64:
+			document = append(document, 0x01)
+			document = append(document, field.name...)
+			document = append(document, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint64(document[len(document)-8:], math.Float64bits(value))
+		case string:
+			document = append(document, 0x02)
+			document = append(document, field.name...)
+			document = append(document, 0, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(document[len(document)-4:], uint32(len(value)+1))
+			document = append(document, value...)
+			document = append(document, 0)
+		}
+	}
+	document = append(document, 0)
+	binary.LittleEndian.PutUint32(document, uint32(len(document)))
+	return document
+}
+
+// decodeBSON decodes the top level fields of a BSON document. Strings are returned as strings and all numeric types
+// as float64 - nested documents and other types are skipped.
+func decodeBSON(data []byte) (map[string]interface{}, error) {
+	malformed := errors.New("malformed BSON document")
+	if len(data) < 5 {
+		return nil, malformed
+	}
+	length := int(binary.LittleEndian.Uint32(data))
+	if length > len(data) || length < 5 {
+		return nil, malformed
+	}
+	data = data[4 : length-1]
+
+	fields := map[string]interface{}{}
+	for len(data) > 0 {
+		kind := data[0]
+		end := bytes.IndexByte(data[1:], 0)
+		if end < 0 {
+			return nil, malformed
+		}
+		name := string(data[1 : 1+end])
+		data = data[2+end:]
+
+		size := 0
+		switch kind {
+		case 0x01, 0x09, 0x11, 0x12:
+			size = 8
+		case 0x02, 0x0d, 0x0e:
+			if len(data) < 4 {
+				return nil, malformed
+			}
+			size = 4 + int(binary.LittleEndian.Uint32(data))
+		case 0x03, 0x04:
+			if len(data) < 4 {
+				return nil, malformed
+			}
+			size = int(binary.LittleEndian.Uint32(data))
+		case 0x05:
+			if len(data) < 4 {
+				return nil, malformed
+			}
+			size = 5 + int(binary.LittleEndian.Uint32(data))
+		case 0x07:
+			size = 12
+		case 0x08:
+			size = 1
+		case 0x0a, 0x06, 0xff, 0x7f:
+			size = 0
+		case 0x10:
+			size = 4
+		case 0x13:
+			size = 16
+		default:
+			return nil, malformed
+		}
+		if size < 0 || size > len(data) {
+			return nil, malformed
+		}
+		value := data[:size]
+
+		switch kind {
+		case 0x01:
+			fields[name] = math.Float64frombits(binary.LittleEndian.Uint64(value))
+		case 0x02:
+			fields[name] = strings.TrimRight(string(value[4:]), "\x00")
+		case 0x08:
+			fields[name] = value[0] == 1
+		case 0x10:
+			fields[name] = float64(int32(binary.LittleEndian.Uint32(value)))
+		case 0x12:
+			fields[name] = float64(int64(binary.LittleEndian.Uint64(value)))
+		}
+		data = data[size:]
+	}
+	return fields, nil
+}
+
+// Memcached
+
+type memcachedModule struct{}
+
+func (memcachedModule) Name() string {
+	return "memcached"
+}
+
+func (memcachedModule) Match(port int, banner string) bool {
+	return port == 11211
+}
+
+// Identify sends the text protocol stats command, which is only answered if no SASL authentication is required.
+func (memcachedModule) Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error {
+	conn, err := dialService(host, port, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("stats\r\n")); err != nil {
+		return err
+	}
+
+	info := &DatastoreInfo{Product: "Memcached"}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "STAT version "):
+			info.Version = strings.TrimPrefix(line, "STAT version ")
+			info.Unauthenticated = true
+		case strings.HasPrefix(line, "STAT "):
+			info.Unauthenticated = true
+		case line == "END":
+			setDatastore(service, "memcached", info)
+			return nil
+		case strings.HasPrefix(line, "ERROR"), strings.HasPrefix(line, "CLIENT_ERROR"):
+			info.AuthMethod = "sasl"
+			setDatastore(service, "memcached", info)
+			return nil
+		default:
+			return errors.New("unexpected Memcached response")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("no response from Memcached")
+}
+
+// Elasticsearch
+
+// elasticsearchMaxResponse is the most that is read of a response from Elasticsearch
+const elasticsearchMaxResponse = 1 << 20
+
+type elasticsearchModule struct{}
+
+func (elasticsearchModule) Name() string {
+	return "elasticsearch"
+}
+
+func (elasticsearchModule) Match(port int, banner string) bool {
+	return port == 9200 || port == 9201
+}
+
+// Identify requests the root endpoint over HTTP, falling back to HTTPS.
+func (elasticsearchModule) Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error {
+	// each host gets its own transport, so connections are not kept alive once the request is done
+	transport := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+
+	var response *http.Response
+	var err error
+	ssl := false
+	for _, scheme := range []string{"http", "https"} {
+		request, requestErr := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(host.String(), fmt.Sprintf("%d", port))), nil)
+		if requestErr != nil {
+			return requestErr
+		}
+		response, err = client.Do(request.WithContext(ctx))
+		if err == nil {
+			ssl = scheme == "https"
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body := io.LimitReader(response.Body, elasticsearchMaxResponse)
+
+	info := &DatastoreInfo{Product: "Elasticsearch", SSL: ssl}
+
+	switch response.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		info.AuthMethod = response.Header.Get("WWW-Authenticate")
+	case http.StatusOK:
+		root := struct {
+			Name        string `json:"name"`
+			ClusterName string `json:"cluster_name"`
+			Tagline     string `json:"tagline"`
+			Version     struct {
+				Number       string `json:"number"`
+				Distribution string `json:"distribution"`
+			} `json:"version"`
+		}{}
+		if err := json.NewDecoder(body).Decode(&root); err != nil {
+			return err
+		}
+		if root.Version.Number == "" && root.Tagline == "" {
+			return errors.New("not an Elasticsearch service")
+		}
+		if root.Version.Distribution == "opensearch" {
+			info.Product = "OpenSearch"
+		}
+		info.Version = root.Version.Number
+		info.Unauthenticated = true
+		if root.ClusterName != "" {
+			info.Notes = append(info.Notes, fmt.Sprintf("cluster %s", root.ClusterName))
+		}
+	default:
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	setDatastore(service, strings.ToLower(info.Product), info)
+	return nil
+}
@@ -0,0 +1,225 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveTCP runs handle for each connection accepted, returning the port listened on
+func serveTCP(t *testing.T, handle func(conn net.Conn)) (net.Listener, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_ = conn.SetDeadline(time.Now().Add(time.Second))
+				handle(conn)
+			}(conn)
+		}
+	}()
+
+	return listener, listener.Addr().(*net.TCPAddr).Port
+}
+
+func identify(t *testing.T, module ServiceModule, port int) *Service {
+	service := &Service{Port: port}
+	require.NoError(t, module.Identify(context.Background(), net.ParseIP("127.0.0.1"), port, time.Second, service))
+	return service
+}
+
+func writeMySQLPacket(conn net.Conn, sequence byte, payload []byte) {
+	_, _ = conn.Write(append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), sequence}, payload...))
+}
+
+func TestMySQLModule(t *testing.T) {
+	listener, port := serveTCP(t, func(conn net.Conn) {
+		greeting := []byte{0x0a}
+		greeting = append(greeting, "10.5.12-MariaDB\x00"...)
+		greeting = append(greeting, 1, 0, 0, 0)
+		greeting = append(greeting, "abcdefgh\x00"...)
+		greeting = append(greeting, 0xff, 0xff, 33, 2, 0, 0xff, 0x81, 21)
+		greeting = append(greeting, make([]byte, 10)...)
+		greeting = append(greeting, "ijklmnopqrst\x00"...)
+		greeting = append(greeting, "mysql_native_password\x00"...)
+		writeMySQLPacket(conn, 0, greeting)
+
+		if _, _, err := readMySQLPacket(conn); err != nil {
+			return
+		}
+		writeMySQLPacket(conn, 2, []byte{0x00, 0, 0, 2, 0, 0, 0})
+	})
+	defer listener.Close()
+
+	service := identify(t, mysqlModule{}, port)
+	assert.Equal(t, "mariadb", service.Name)
+	assert.Equal(t, "10.5.12-MariaDB", service.Version)
+	require.NotNil(t, service.Datastore)
+	assert.Equal(t, "mysql_native_password", service.Datastore.AuthMethod)
+	assert.True(t, service.Datastore.SSL)
+	// logging in is left to the mysql-empty-password module
+	assert.False(t, service.Datastore.Unauthenticated)
+
+	service = identify(t, mysqlModule{emptyPassword: true}, port)
+	require.NotNil(t, service.Datastore)
+	assert.True(t, service.Datastore.Unauthenticated)
+}
+
+func TestIntrusiveModulesNotDefault(t *testing.T) {
+	assert.Contains(t, ServiceModuleNames(), "mysql-empty-password")
+	assert.NotContains(t, DefaultServiceModuleNames(), "mysql-empty-password")
+	assert.Contains(t, DefaultServiceModuleNames(), "mysql")
+
+	modules, err := GetServiceModules("mysql-empty-password")
+	require.NoError(t, err)
+	require.Len(t, modules, 1)
+}
+
+func TestPostgresModule(t *testing.T) {
+	listener, port := serveTCP(t, func(conn net.Conn) {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		if binary.BigEndian.Uint32(header[4:]) == postgresSSLRequestCode {
+			_, _ = conn.Write([]byte{'N'})
+			return
+		}
+		_, _ = io.CopyN(ioutil.Discard, conn, int64(binary.BigEndian.Uint32(header)-8))
+
+		mechanisms := []byte("SCRAM-SHA-256\x00\x00")
+		message := []byte{'R', 0, 0, 0, 0, 0, 0, 0, 10}
+		message = append(message, mechanisms...)
+		binary.BigEndian.PutUint32(message[1:], uint32(len(message)-1))
+		_, _ = conn.Write(message)
+	})
+	defer listener.Close()
+
+	service := identify(t, postgresModule{}, port)
+	assert.Equal(t, "postgresql", service.Name)
+	require.NotNil(t, service.Datastore)
+	assert.False(t, service.Datastore.SSL)
+	assert.False(t, service.Datastore.Unauthenticated)
+	assert.Equal(t, "scram-sha-256", service.Datastore.AuthMethod)
+}
+
+func TestRedisModule(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		response        string
+		version         string
+		unauthenticated bool
+	}{
+		{"open", "$40\r\n# Server\r\nredis_version:7.0.11\r\nmode:x\r\n\r\n", "7.0.11", true},
+		{"password", "-NOAUTH Authentication required.\r\n", "", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			listener, port := serveTCP(t, func(conn net.Conn) {
+				if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte(test.response))
+			})
+			defer listener.Close()
+
+			service := identify(t, redisModule{}, port)
+			assert.Equal(t, "redis", service.Name)
+			assert.Equal(t, test.version, service.Version)
+			assert.Equal(t, test.unauthenticated, service.Datastore.Unauthenticated)
+		})
+	}
+}
+
+func TestMongoModule(t *testing.T) {
+	listener, port := serveTCP(t, func(conn net.Conn) {
+		for {
+			header := make([]byte, 16)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			body := make([]byte, binary.LittleEndian.Uint32(header)-16)
+			if _, err := io.ReadFull(conn, body); err != nil {
+				return
+			}
+			request, err := decodeBSON(body[5:])
+			if err != nil {
+				return
+			}
+
+			var reply []bsonField
+			switch {
+			case request["hello"] != nil:
+				reply = []bsonField{{"isWritablePrimary", int32(1)}, {"maxWireVersion", int32(17)}, {"ok", float64(1)}}
+			case request["buildInfo"] != nil:
+				reply = []bsonField{{"version", "6.0.5"}, {"ok", float64(1)}}
+			default:
+				reply = []bsonField{{"ok", float64(0)}, {"errmsg", "command listDatabases requires authentication"}, {"code", int32(13)}}
+			}
+
+			document := encodeBSON(reply)
+			message := make([]byte, 16)
+			binary.LittleEndian.PutUint32(message[8:], binary.LittleEndian.Uint32(header[4:]))
+			binary.LittleEndian.PutUint32(message[12:], mongoOpMsg)
+			message = append(message, 0, 0, 0, 0, 0)
+			message = append(message, document...)
+			binary.LittleEndian.PutUint32(message, uint32(len(message)))
+			_, _ = conn.Write(message)
+		}
+	})
+	defer listener.Close()
+
+	service := identify(t, mongoModule{}, port)
+	assert.Equal(t, "mongodb", service.Name)
+	assert.Equal(t, "6.0.5", service.Version)
+	assert.False(t, service.Datastore.Unauthenticated)
+	assert.Equal(t, []string{"max wire version 17"}, service.Datastore.Notes)
+}
+
+func TestMemcachedModule(t *testing.T) {
+	listener, port := serveTCP(t, func(conn net.Conn) {
+		if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("STAT pid 1\r\nSTAT version 1.6.9\r\nSTAT uptime 100\r\nEND\r\n"))
+	})
+	defer listener.Close()
+
+	service := identify(t, memcachedModule{}, port)
+	assert.Equal(t, "memcached", service.Name)
+	assert.Equal(t, "1.6.9", service.Version)
+	assert.True(t, service.Datastore.Unauthenticated)
+}
+
+func TestElasticsearchModule(t *testing.T) {
+	keepAlive := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keepAlive = keepAlive || !r.Close
+		_, _ = w.Write([]byte(`{"name":"node-1","cluster_name":"logs","version":{"number":"7.17.9"},"tagline":"You Know, for Search"}`))
+	}))
+	defer server.Close()
+
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	service := identify(t, elasticsearchModule{}, port)
+	assert.Equal(t, "elasticsearch", service.Name)
+	assert.Equal(t, "7.17.9", service.Version)
+	assert.True(t, service.Datastore.Unauthenticated)
+	assert.Contains(t, strings.Join(service.Details(), "\n"), "cluster logs")
+	assert.False(t, keepAlive, "connections are closed after each request")
+}
@@ -0,0 +1,445 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// SMBInfo describes the dialects and identity of an SMB server.
+type SMBInfo struct {
+	Dialects []string
+	SMB1     bool
+	// SMB1SigningRequired and SMB2SigningRequired are reported separately, since servers often require signing for
+	// one but not the other
+	SMB1SigningRequired bool
+	SMB2SigningRequired bool
+	NetBIOSComputerName string
+	NetBIOSDomainName   string
+	DNSComputerName     string
+	DNSDomainName       string
+	DNSTreeName         string
+	OSVersion           string
+}
+
+var smb2Dialects = []struct {
+	revision uint16
+	name     string
+}{
+	{0x0202, "SMB 2.0.2"},
+	{0x0210, "SMB 2.1"},
+	{0x0300, "SMB 3.0"},
+	{0x0302, "SMB 3.0.2"},
+	{0x0311, "SMB 3.1.1"},
+}
+
+const (
+	smb1DialectName = "NT LM 0.12"
+
+	smb2CommandNegotiate    = 0x0000
+	smb2CommandSessionSetup = 0x0001
+
+	smb2SigningRequired = 0x0002
+	smb1SigningRequired = 0x08
+
+	smbStatusMoreProcessing = 0xc0000016
+
+	ntlmNegotiateFlags   = 0xe2088205
+	ntlmNegotiateVersion = 0x02000000
+
+	smbMaxMessage = 1 << 20
+)
+
+var errSMBNotSupported = errors.New("dialect not supported")
+
+type smbModule struct{}
+
+func init() {
+	registerServiceModule(smbModule{})
+}
+
+func (smbModule) Name() string {
+	return "smb"
+}
+
+func (smbModule) Match(port int, banner string) bool {
+	return port == 139 || port == 445
+}
+
+func (smbModule) Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error {
+	info, err := QuerySMB(ctx, host, port, timeout)
+	if err != nil {
+		return err
+	}
+	service.Name = "smb"
+	if len(info.Dialects) > 0 {
+		service.Version = info.Dialects[len(info.Dialects)-1]
+	}
+	service.SMB = info
+	return nil
+}
+
+// QuerySMB negotiates each SMB dialect in turn with the server on the given port (139 or 445), and then begins an
+// NTLM authentication in order to read the computer and domain names and OS version from the server challenge.
+func QuerySMB(ctx context.Context, host net.IP, port int, timeout time.Duration) (*SMBInfo, error) {
+
+	// each dialect is negotiated on a new connection, so a closed or filtered port is given up on after one attempt
+	conn, err := dialSMB(host, port, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+
+	info := &SMBInfo{}
+
+	signing, err := negotiateSMB1(host, port, timeout)
+	if err == nil {
+		info.SMB1 = true
+		info.SMB1SigningRequired = signing
+		info.Dialects = append(info.Dialects, "SMB 1.0")
+	} else if isTimeout(err) {
+		// the server accepts connections, but does not respond
+		return nil, err
+	}
+
+	highest := uint16(0)
+	responding := true
+	for _, dialect := range smb2Dialects {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		conn, securityMode, err := negotiateSMB2(host, port, timeout, dialect.revision)
+		if err != nil {
+			// a timeout means the server has stopped responding, rather than rejecting the dialect
+			if isTimeout(err) {
+				responding = false
+				break
+			}
+			continue
+		}
+		conn.Close()
+		info.Dialects = append(info.Dialects, dialect.name)
+		info.SMB2SigningRequired = info.SMB2SigningRequired || securityMode&smb2SigningRequired > 0
+		highest = dialect.revision
+	}
+
+	if len(info.Dialects) == 0 {
+		return nil, errors.New("no SMB dialects negotiated")
+	}
+
+	if highest > 0 && responding {
+		if challenge, err := smb2NTLMChallenge(host, port, timeout, highest); err == nil {
+			parseNTLMChallenge(challenge, info)
+		}
+	}
+
+	return info, nil
+}
+
+// Name returns the most specific computer name the server reported.
+func (i *SMBInfo) Name() string {
+	if i.DNSComputerName != "" {
+		return i.DNSComputerName
+	}
+	return i.NetBIOSComputerName
+}
+
+// SMB2 reports whether the server negotiated any SMB2 or SMB3 dialect.
+func (i *SMBInfo) SMB2() bool {
+	return len(i.Dialects) > 0 && (!i.SMB1 || len(i.Dialects) > 1)
+}
+
+// Details summarises the information for display.
+func (i *SMBInfo) Details() []string {
+	details := []string{
+		fmt.Sprintf("smb dialects: %s", strings.Join(i.Dialects, ", ")),
+	}
+	if i.SMB1 {
+		details = append(details, fmt.Sprintf("smb1 signing required: %t", i.SMB1SigningRequired))
+	}
+	if i.SMB2() {
+		details = append(details, fmt.Sprintf("smb2 signing required: %t", i.SMB2SigningRequired))
+	}
+	if name := i.Name(); name != "" {
+		details = append(details, fmt.Sprintf("smb computer: %s", name))
+	}
+	if i.DNSDomainName != "" || i.NetBIOSDomainName != "" {
+		details = append(details, fmt.Sprintf("smb domain: %s (%s)", i.DNSDomainName, i.NetBIOSDomainName))
+	}
+	if i.OSVersion != "" {
+		details = append(details, fmt.Sprintf("smb os: %s", i.OSVersion))
+	}
+	return details
+}
+
+func dialSMB(host net.IP, port int, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host.String(), fmt.Sprintf("%d", port)), timeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout * 2))
+
+	// port 139 requires a NetBIOS session to be established first
+	if port == 139 {
+		request := []byte{0x81, 0, 0, 68}
+		request = append(request, encodeNetBIOSName("*SMBSERVER", 0x20)...)
+		request = append(request, encodeNetBIOSName("FURIOUS", 0x00)...)
+		if _, err := conn.Write(request); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		response := make([]byte, 4)
+		if _, err := io.ReadFull(conn, response); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if response[0] != 0x82 {
+			conn.Close()
+			return nil, errors.New("NetBIOS session rejected")
+		}
+	}
+
+	return conn, nil
+}
+
+// encodeNetBIOSName returns the first-level encoding of a NetBIOS name, as used in session requests and name queries
+func encodeNetBIOSName(name string, suffix byte) []byte {
+	raw := []byte(strings.ToUpper(name))
+	if name == "*" {
+		raw = append(raw, bytes.Repeat([]byte{0}, 15)...)
+	} else {
+		for len(raw) < 15 {
+			raw = append(raw, ' ')
+		}
+	}
+	raw = append(raw[:15], suffix)
+
+	encoded := []byte{32}
+	for _, b := range raw {
+		encoded = append(encoded, 'A'+(b>>4), 'A'+(b&0x0f))
+	}
+	return append(encoded, 0)
+}
+
+func writeSMBMessage(conn net.Conn, message []byte) error {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(message)))
+	_, err := conn.Write(append(header, message...))
+	return err
+}
+
+func readSMBMessage(conn net.Conn) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header) & 0x00ffffff
+	if length > smbMaxMessage {
+		return nil, errors.New("SMB message too large")
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(conn, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// negotiateSMB1 offers only the NT LM 0.12 dialect, and reports whether the server requires signing
+func negotiateSMB1(host net.IP, port int, timeout time.Duration) (bool, error) {
+	conn, err := dialSMB(host, port, timeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	message := []byte{0xff, 'S', 'M', 'B', 0x72, 0, 0, 0, 0, 0x18, 0x53, 0xc8}
+	message = append(message, make([]byte, 20)...)
+	dialects := append([]byte{0x02}, append([]byte(smb1DialectName), 0)...)
+	message = append(message, 0, byte(len(dialects)), byte(len(dialects)>>8))
+	message = append(message, dialects...)
+
+	if err := writeSMBMessage(conn, message); err != nil {
+		return false, err
+	}
+	response, err := readSMBMessage(conn)
+	if err != nil {
+		return false, err
+	}
+	if len(response) < 37 || !bytes.Equal(response[:4], []byte{0xff, 'S', 'M', 'B'}) {
+		return false, errSMBNotSupported
+	}
+	if binary.LittleEndian.Uint32(response[5:]) != 0 || binary.LittleEndian.Uint16(response[33:]) == 0xffff {
+		return false, errSMBNotSupported
+	}
+	return response[35]&smb1SigningRequired > 0, nil
+}
+
+func smb2Header(command uint16, messageID uint64) []byte {
+	header := make([]byte, 64)
+	copy(header, []byte{0xfe, 'S', 'M', 'B'})
+	binary.LittleEndian.PutUint16(header[4:], 64)
+	binary.LittleEndian.PutUint16(header[12:], command)
+	binary.LittleEndian.PutUint16(header[14:], 1)
+	binary.LittleEndian.PutUint64(header[24:], messageID)
+	return header
+}
+
+// negotiateSMB2 offers a single SMB2/3 dialect, returning the open connection and server security mode on success
+func negotiateSMB2(host net.IP, port int, timeout time.Duration, dialect uint16) (net.Conn, uint16, error) {
+	conn, err := dialSMB(host, port, timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	message := smb2Header(smb2CommandNegotiate, 0)
+	body := make([]byte, 36)
+	binary.LittleEndian.PutUint16(body, 36)
+	binary.LittleEndian.PutUint16(body[2:], 1)
+	binary.LittleEndian.PutUint16(body[4:], 1)
+	_, _ = rand.Read(body[12:28])
+	body = append(body, byte(dialect), byte(dialect>>8))
+
+	if dialect == 0x0311 {
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+		binary.LittleEndian.PutUint32(body[28:], uint32(64+len(body)))
+		binary.LittleEndian.PutUint16(body[32:], 2)
+
+		salt := make([]byte, 32)
+		_, _ = rand.Read(salt)
+		preauth := append([]byte{1, 0, 32, 0, 1, 0}, salt...)
+		body = append(body, smb2NegotiateContext(1, preauth)...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+		body = append(body, smb2NegotiateContext(2, []byte{2, 0, 2, 0, 1, 0})...)
+	}
+
+	if err := writeSMBMessage(conn, append(message, body...)); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	response, err := readSMBMessage(conn)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	if len(response) < 64+6 || !bytes.Equal(response[:4], []byte{0xfe, 'S', 'M', 'B'}) || binary.LittleEndian.Uint32(response[8:]) != 0 {
+		conn.Close()
+		return nil, 0, errSMBNotSupported
+	}
+	if binary.LittleEndian.Uint16(response[64+4:]) != dialect {
+		conn.Close()
+		return nil, 0, errSMBNotSupported
+	}
+
+	return conn, binary.LittleEndian.Uint16(response[64+2:]), nil
+}
+
+func smb2NegotiateContext(contextType uint16, data []byte) []byte {
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint16(header, contextType)
+	binary.LittleEndian.PutUint16(header[2:], uint16(len(data)))
+	return append(header, data...)
+}
+
+// smb2NTLMChallenge negotiates the given dialect and then starts an NTLM session setup, returning the raw NTLM
+// CHALLENGE message
+func smb2NTLMChallenge(host net.IP, port int, timeout time.Duration, dialect uint16) ([]byte, error) {
+	conn, _, err := negotiateSMB2(host, port, timeout, dialect)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	negotiate := []byte("NTLMSSP\x00")
+	negotiate = append(negotiate, 1, 0, 0, 0)
+	flags := make([]byte, 4)
+	binary.LittleEndian.PutUint32(flags, ntlmNegotiateFlags)
+	negotiate = append(negotiate, flags...)
+	negotiate = append(negotiate, make([]byte, 16)...)
+	negotiate = append(negotiate, 6, 1, 0xb1, 0x1d, 0, 0, 0, 0x0f)
+
+	message := smb2Header(smb2CommandSessionSetup, 1)
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint16(body, 25)
+	body[3] = 1
+	binary.LittleEndian.PutUint16(body[12:], 64+24)
+	binary.LittleEndian.PutUint16(body[14:], uint16(len(negotiate)))
+	body = append(body, negotiate...)
+
+	if err := writeSMBMessage(conn, append(message, body...)); err != nil {
+		return nil, err
+	}
+	response, err := readSMBMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 64+8 || binary.LittleEndian.Uint32(response[8:]) != smbStatusMoreProcessing {
+		return nil, errors.New("unexpected SMB session setup response")
+	}
+
+	index := bytes.Index(response[64:], []byte("NTLMSSP\x00"))
+	if index < 0 {
+		return nil, errors.New("no NTLM challenge in SMB session setup response")
+	}
+	return response[64+index:], nil
+}
+
+// parseNTLMChallenge reads the target information and OS version from an NTLM CHALLENGE message
+func parseNTLMChallenge(challenge []byte, info *SMBInfo) {
+	if len(challenge) < 48 || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return
+	}
+
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	if flags&ntlmNegotiateVersion > 0 && len(challenge) >= 56 {
+		info.OSVersion = fmt.Sprintf("%d.%d build %d", challenge[48], challenge[49], binary.LittleEndian.Uint16(challenge[50:]))
+	}
+
+	length := int(binary.LittleEndian.Uint16(challenge[40:]))
+	offset := int(binary.LittleEndian.Uint32(challenge[44:]))
+	if offset+length > len(challenge) {
+		return
+	}
+	pairs := challenge[offset : offset+length]
+
+	for len(pairs) >= 4 {
+		id := binary.LittleEndian.Uint16(pairs)
+		size := int(binary.LittleEndian.Uint16(pairs[2:]))
+		if id == 0 || len(pairs) < 4+size {
+			return
+		}
+		value := decodeUTF16(pairs[4 : 4+size])
+		switch id {
+		case 1:
+			info.NetBIOSComputerName = value
+		case 2:
+			info.NetBIOSDomainName = value
+		case 3:
+			info.DNSComputerName = value
+		case 4:
+			info.DNSDomainName = value
+		case 5:
+			info.DNSTreeName = value
+		}
+		pairs = pairs[4+size:]
+	}
+}
+
+func decodeUTF16(data []byte) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
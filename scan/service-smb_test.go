@@ -0,0 +1,166 @@
+package scan
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ntlmAVPair(id uint16, value string) []byte {
+	encoded := []byte{}
+	for _, unit := range utf16.Encode([]rune(value)) {
+		encoded = append(encoded, byte(unit), byte(unit>>8))
+	}
+	pair := make([]byte, 4)
+	binary.LittleEndian.PutUint16(pair, id)
+	binary.LittleEndian.PutUint16(pair[2:], uint16(len(encoded)))
+	return append(pair, encoded...)
+}
+
+// serveFakeSMB supports SMB 2.1 and 3.0 with signing required. SMB1 is rejected, unless smb1 is set, in which case it
+// is supported without signing.
+func serveFakeSMB(listener net.Listener, smb1 bool) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				request, err := readSMBMessage(conn)
+				if err != nil {
+					return
+				}
+				if request[0] == 0xff && smb1 {
+					response := append([]byte{0xff, 'S', 'M', 'B', 0x72}, make([]byte, 32)...)
+					_ = writeSMBMessage(conn, response)
+					return
+				}
+				if request[0] != 0xfe {
+					return
+				}
+
+				response := smb2Header(binary.LittleEndian.Uint16(request[12:]), 0)
+				switch binary.LittleEndian.Uint16(request[12:]) {
+				case smb2CommandNegotiate:
+					dialect := binary.LittleEndian.Uint16(request[64+36:])
+					if dialect != 0x0210 && dialect != 0x0300 {
+						return
+					}
+					body := make([]byte, 65)
+					binary.LittleEndian.PutUint16(body, 65)
+					binary.LittleEndian.PutUint16(body[2:], 3)
+					binary.LittleEndian.PutUint16(body[4:], dialect)
+					response = append(response, body...)
+				case smb2CommandSessionSetup:
+					binary.LittleEndian.PutUint32(response[8:], smbStatusMoreProcessing)
+					pairs := append(ntlmAVPair(2, "CORP"), ntlmAVPair(1, "FILESERVER")...)
+					pairs = append(pairs, ntlmAVPair(4, "corp.example.com")...)
+					pairs = append(pairs, ntlmAVPair(3, "fileserver.corp.example.com")...)
+					pairs = append(pairs, 0, 0, 0, 0)
+					challenge := make([]byte, 56)
+					copy(challenge, "NTLMSSP\x00")
+					binary.LittleEndian.PutUint32(challenge[8:], 2)
+					binary.LittleEndian.PutUint32(challenge[20:], ntlmNegotiateFlags)
+					binary.LittleEndian.PutUint16(challenge[40:], uint16(len(pairs)))
+					binary.LittleEndian.PutUint32(challenge[44:], 56)
+					copy(challenge[48:], []byte{10, 0, 0x63, 0x45})
+					challenge = append(challenge, pairs...)
+					body := make([]byte, 8)
+					binary.LittleEndian.PutUint16(body, 9)
+					binary.LittleEndian.PutUint16(body[4:], 64+8)
+					binary.LittleEndian.PutUint16(body[6:], uint16(len(challenge)))
+					response = append(append(response, body...), challenge...)
+				}
+				if err := writeSMBMessage(conn, response); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func TestQuerySMB(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveFakeSMB(listener, false)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	info, err := QuerySMB(context.Background(), net.ParseIP("127.0.0.1"), port, time.Second)
+	require.NoError(t, err)
+
+	assert.False(t, info.SMB1)
+	assert.Equal(t, []string{"SMB 2.1", "SMB 3.0"}, info.Dialects)
+	assert.True(t, info.SMB2SigningRequired)
+	assert.Equal(t, []string{"smb dialects: SMB 2.1, SMB 3.0", "smb2 signing required: true"}, info.Details()[:2])
+	assert.Equal(t, "FILESERVER", info.NetBIOSComputerName)
+	assert.Equal(t, "CORP", info.NetBIOSDomainName)
+	assert.Equal(t, "corp.example.com", info.DNSDomainName)
+	assert.Equal(t, "fileserver.corp.example.com", info.Name())
+	assert.Equal(t, "10.0 build 17763", info.OSVersion)
+}
+
+func TestQuerySMBSigningPerDialect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveFakeSMB(listener, true)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	info, err := QuerySMB(context.Background(), net.ParseIP("127.0.0.1"), port, time.Second)
+	require.NoError(t, err)
+
+	// signing is required for SMB2, but not for SMB1
+	assert.True(t, info.SMB1)
+	assert.True(t, info.SMB2())
+	assert.Equal(t, []string{"SMB 1.0", "SMB 2.1", "SMB 3.0"}, info.Dialects)
+	assert.False(t, info.SMB1SigningRequired)
+	assert.True(t, info.SMB2SigningRequired)
+	assert.Contains(t, info.Details(), "smb1 signing required: false")
+	assert.Contains(t, info.Details(), "smb2 signing required: true")
+}
+
+func TestQuerySMBGivesUpOnSilentServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// connections are accepted, but nothing is ever sent back
+	connections := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections <- conn
+		}
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	_, err = QuerySMB(context.Background(), net.ParseIP("127.0.0.1"), port, 50*time.Millisecond)
+	assert.Error(t, err)
+	assert.Len(t, connections, 2, "one connection to check the port, and one SMB1 negotiation which timed out")
+	close(connections)
+	for conn := range connections {
+		conn.Close()
+	}
+}
+
+func TestQuerySMBClosedPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+
+	_, err = QuerySMB(context.Background(), net.ParseIP("127.0.0.1"), port, time.Second)
+	assert.Error(t, err)
+}
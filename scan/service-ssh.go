@@ -0,0 +1,378 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// SSHInfo describes an SSH server and the algorithms it offers.
+type SSHInfo struct {
+	ProtocolVersion   string
+	SoftwareVersion   string
+	Comments          string
+	KexAlgorithms     []string
+	HostKeyAlgorithms []string
+	Ciphers           []string
+	MACs              []string
+	Compression       []string
+	HostKeys          []SSHHostKey
+	WeakAlgorithms    []string
+}
+
+// SSHHostKey is a public host key presented by an SSH server.
+type SSHHostKey struct {
+	Algorithm   string
+	Fingerprint string
+}
+
+const (
+	sshMsgKexInit     = 20
+	sshMsgKexECDHInit = 30
+	sshMsgKexECDHRepl = 31
+
+	sshClientVersion = "SSH-2.0-furious"
+	sshMaxPacket     = 256 * 1024
+)
+
+// weak SSH algorithms, based on current OpenSSH defaults and common audit guidance
+var sshWeakAlgorithms = map[string]bool{
+	"diffie-hellman-group1-sha1":         true,
+	"diffie-hellman-group14-sha1":        true,
+	"diffie-hellman-group-exchange-sha1": true,
+	"ssh-dss":                            true,
+	"ssh-rsa":                            true,
+	"3des-cbc":                           true,
+	"aes128-cbc":                         true,
+	"aes192-cbc":                         true,
+	"aes256-cbc":                         true,
+	"blowfish-cbc":                       true,
+	"cast128-cbc":                        true,
+	"rijndael-cbc@lysator.liu.se":        true,
+	"arcfour":                            true,
+	"arcfour128":                         true,
+	"arcfour256":                         true,
+	"none":                               true,
+	"hmac-md5":                           true,
+	"hmac-md5-96":                        true,
+	"hmac-md5-etm@openssh.com":           true,
+	"hmac-md5-96-etm@openssh.com":        true,
+	"hmac-sha1-96":                       true,
+	"hmac-sha1-96-etm@openssh.com":       true,
+	"umac-64@openssh.com":                true,
+	"umac-64-etm@openssh.com":            true,
+}
+
+// key exchange methods we can perform far enough to receive the server host key, in order of preference. The
+// exchange is never completed, so only a public value which the server will accept is needed.
+var sshKexMethods = []struct {
+	name      string
+	publicKey func() ([]byte, error)
+}{
+	{"curve25519-sha256", x25519PublicValue},
+	{"curve25519-sha256@libssh.org", x25519PublicValue},
+	{"ecdh-sha2-nistp256", ecdhPublicValue(elliptic.P256)},
+	{"ecdh-sha2-nistp384", ecdhPublicValue(elliptic.P384)},
+	{"ecdh-sha2-nistp521", ecdhPublicValue(elliptic.P521)},
+}
+
+// x25519PublicValue returns a random X25519 public value. Every 32 byte string is a valid X25519 public value, and
+// since the shared secret is never computed, there's no need for the private key it would be derived from.
+func x25519PublicValue() ([]byte, error) {
+	public := make([]byte, 32)
+	if _, err := rand.Read(public); err != nil {
+		return nil, err
+	}
+	// the top bit is ignored by receivers (RFC 7748)
+	public[31] &= 0x7f
+	return public, nil
+}
+
+// ecdhPublicValue returns a function which generates an uncompressed public key on a NIST curve.
+func ecdhPublicValue(curve func() elliptic.Curve) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		c := curve()
+		_, x, y, err := elliptic.GenerateKey(c, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return elliptic.Marshal(c, x, y), nil
+	}
+}
+
+type sshModule struct{}
+
+func init() {
+	registerServiceModule(sshModule{})
+}
+
+func (sshModule) Name() string {
+	return "ssh"
+}
+
+func (sshModule) Match(port int, banner string) bool {
+	return strings.HasPrefix(banner, "SSH-") || strings.Contains(banner, "\nSSH-")
+}
+
+func (m sshModule) Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error {
+
+	info, _, err := m.handshake(host, port, timeout, "")
+	if err != nil {
+		return err
+	}
+
+	service.Name = "ssh"
+	service.Version = info.SoftwareVersion
+	service.SSH = info
+
+	if strings.HasPrefix(info.ProtocolVersion, "1.") {
+		info.WeakAlgorithms = append(info.WeakAlgorithms, "protocol-"+info.ProtocolVersion)
+	}
+	for _, list := range [][]string{info.KexAlgorithms, info.HostKeyAlgorithms, info.Ciphers, info.MACs} {
+		for _, algorithm := range list {
+			if sshWeakAlgorithms[algorithm] {
+				info.WeakAlgorithms = append(info.WeakAlgorithms, algorithm)
+			}
+		}
+	}
+
+	// each host key type needs a separate key exchange
+	seen := map[string]bool{}
+	for _, algorithm := range info.HostKeyAlgorithms {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		_, key, err := m.handshake(host, port, timeout, algorithm)
+		if err != nil {
+			continue
+		}
+		if key != nil && !seen[key.Fingerprint] {
+			seen[key.Fingerprint] = true
+			info.HostKeys = append(info.HostKeys, *key)
+		}
+	}
+
+	return nil
+}
+
+// handshake connects to the server and reads its identification and KEXINIT. If hostKeyAlgorithm is not empty, a
+// key exchange is then performed to retrieve the host key of that type.
+func (m sshModule) handshake(host net.IP, port int, timeout time.Duration, hostKeyAlgorithm string) (*SSHInfo, *SSHHostKey, error) {
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host.String(), fmt.Sprintf("%d", port)), timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout * 2))
+
+	reader := bufio.NewReader(conn)
+
+	info := &SSHInfo{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "SSH-") {
+			parseSSHIdentification(line, info)
+			break
+		}
+	}
+
+	if strings.HasPrefix(info.ProtocolVersion, "1.") && info.ProtocolVersion != "1.99" {
+		return info, nil, nil
+	}
+
+	if _, err := conn.Write([]byte(sshClientVersion + "\r\n")); err != nil {
+		return nil, nil, err
+	}
+
+	payload, err := readSSHPacket(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(payload) == 0 || payload[0] != sshMsgKexInit {
+		return nil, nil, errors.New("expected SSH KEXINIT")
+	}
+	if err := parseSSHKexInit(payload, info); err != nil {
+		return nil, nil, err
+	}
+
+	if hostKeyAlgorithm == "" {
+		return info, nil, nil
+	}
+
+	kexName := ""
+	var publicKey func() ([]byte, error)
+	for _, kex := range sshKexMethods {
+		if containsString(info.KexAlgorithms, kex.name) {
+			kexName, publicKey = kex.name, kex.publicKey
+			break
+		}
+	}
+	if publicKey == nil {
+		return info, nil, nil
+	}
+
+	public, err := publicKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kexInit := []byte{sshMsgKexInit}
+	cookie := make([]byte, 16)
+	_, _ = rand.Read(cookie)
+	kexInit = append(kexInit, cookie...)
+	for _, list := range [][]string{
+		{kexName},
+		{hostKeyAlgorithm},
+		info.Ciphers, info.Ciphers,
+		info.MACs, info.MACs,
+		info.Compression, info.Compression,
+		{}, {},
+	} {
+		kexInit = appendSSHString(kexInit, []byte(strings.Join(list, ",")))
+	}
+	kexInit = append(kexInit, 0, 0, 0, 0, 0)
+
+	if err := writeSSHPacket(conn, kexInit); err != nil {
+		return nil, nil, err
+	}
+	if err := writeSSHPacket(conn, appendSSHString([]byte{sshMsgKexECDHInit}, public)); err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		payload, err := readSSHPacket(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(payload) == 0 || payload[0] != sshMsgKexECDHRepl {
+			continue
+		}
+		blob, _, ok := readSSHString(payload[1:])
+		if !ok {
+			return nil, nil, errors.New("malformed SSH KEX reply")
+		}
+		algorithm, _, ok := readSSHString(blob)
+		if !ok {
+			return nil, nil, errors.New("malformed SSH host key")
+		}
+		sum := sha256.Sum256(blob)
+		return info, &SSHHostKey{
+			Algorithm:   string(algorithm),
+			Fingerprint: "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]),
+		}, nil
+	}
+}
+
+// Details summarises the information for display.
+func (i *SSHInfo) Details() []string {
+	details := []string{}
+	for _, key := range i.HostKeys {
+		details = append(details, fmt.Sprintf("host key %s %s", key.Algorithm, key.Fingerprint))
+	}
+	if len(i.WeakAlgorithms) > 0 {
+		details = append(details, fmt.Sprintf("weak algorithms: %s", strings.Join(i.WeakAlgorithms, ", ")))
+	}
+	return details
+}
+
+// parseSSHIdentification parses an identification string such as "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.1"
+func parseSSHIdentification(line string, info *SSHInfo) {
+	parts := strings.SplitN(line, "-", 3)
+	if len(parts) > 1 {
+		info.ProtocolVersion = parts[1]
+	}
+	if len(parts) > 2 {
+		software := strings.SplitN(parts[2], " ", 2)
+		info.SoftwareVersion = software[0]
+		if len(software) > 1 {
+			info.Comments = software[1]
+		}
+	}
+}
+
+func parseSSHKexInit(payload []byte, info *SSHInfo) error {
+	if len(payload) < 17 {
+		return errors.New("malformed SSH KEXINIT")
+	}
+	data := payload[17:]
+	lists := make([][]string, 10)
+	for i := range lists {
+		value, rest, ok := readSSHString(data)
+		if !ok {
+			return errors.New("malformed SSH KEXINIT")
+		}
+		if len(value) > 0 {
+			lists[i] = strings.Split(string(value), ",")
+		}
+		data = rest
+	}
+	info.KexAlgorithms = lists[0]
+	info.HostKeyAlgorithms = lists[1]
+	info.Ciphers = lists[2]
+	info.MACs = lists[4]
+	info.Compression = lists[6]
+	return nil
+}
+
+func readSSHPacket(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	padding := uint32(header[4])
+	if length > sshMaxPacket || length < padding+1 {
+		return nil, errors.New("invalid SSH packet length")
+	}
+	body := make([]byte, length-1)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	return body[:len(body)-int(padding)], nil
+}
+
+func writeSSHPacket(writer io.Writer, payload []byte) error {
+	padding := 8 - (len(payload)+5)%8
+	if padding < 4 {
+		padding += 8
+	}
+	packet := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	_, err := writer.Write(packet)
+	return err
+}
+
+func readSSHString(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < length {
+		return nil, nil, false
+	}
+	return data[4 : 4+length], data[4+length:], true
+}
+
+func appendSSHString(data []byte, value []byte) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(value)))
+	return append(append(data, length...), value...)
+}
@@ -0,0 +1,115 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveFakeSSH accepts connections and performs just enough of an SSH key exchange to present a host key. Problems
+// with what the client sends are reported on errs, since the test can only fail from its own goroutine.
+func serveFakeSSH(listener net.Listener, errs chan<- error) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+
+			_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_9.0 FakeOS-1\r\n"))
+			if _, err := reader.ReadString('\n'); err != nil {
+				return
+			}
+
+			kexInit := append([]byte{sshMsgKexInit}, make([]byte, 16)...)
+			for _, list := range []string{
+				"curve25519-sha256,diffie-hellman-group1-sha1",
+				"ssh-ed25519,rsa-sha2-512",
+				"aes128-ctr,3des-cbc", "aes128-ctr,3des-cbc",
+				"hmac-sha2-256,hmac-md5", "hmac-sha2-256,hmac-md5",
+				"none", "none", "", "",
+			} {
+				kexInit = appendSSHString(kexInit, []byte(list))
+			}
+			kexInit = append(kexInit, 0, 0, 0, 0, 0)
+			_ = writeSSHPacket(conn, kexInit)
+
+			payload, err := readSSHPacket(reader)
+			if err != nil {
+				return
+			}
+			client := &SSHInfo{}
+			if err := parseSSHKexInit(payload, client); err != nil {
+				errs <- err
+				return
+			}
+
+			payload, err = readSSHPacket(reader)
+			if err != nil {
+				return
+			}
+			if len(payload) == 0 || payload[0] != sshMsgKexECDHInit {
+				errs <- fmt.Errorf("expected an ECDH init, got %x", payload)
+				return
+			}
+			if public, _, ok := readSSHString(payload[1:]); !ok || len(public) != 32 {
+				errs <- fmt.Errorf("expected a curve25519 ECDH init, got %x", payload)
+				return
+			}
+
+			algorithm := client.HostKeyAlgorithms[0]
+			blob := appendSSHString(nil, []byte(algorithm))
+			blob = appendSSHString(blob, []byte(strings.Repeat(algorithm[:1], 32)))
+			reply := appendSSHString([]byte{sshMsgKexECDHRepl}, blob)
+			reply = appendSSHString(reply, make([]byte, 32))
+			reply = appendSSHString(reply, []byte{})
+			_ = writeSSHPacket(conn, reply)
+		}(conn)
+	}
+}
+
+func TestSSHModule(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	errs := make(chan error, 2)
+	go serveFakeSSH(listener, errs)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	result := NewResult(net.ParseIP("127.0.0.1"))
+	result.Open = []int{port}
+
+	modules, err := GetServiceModules("ssh")
+	require.NoError(t, err)
+
+	IdentifyServices(context.Background(), &result, modules, time.Second)
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	default:
+	}
+
+	service := result.Service(port)
+	require.NotNil(t, service)
+	require.NotNil(t, service.SSH)
+
+	assert.Equal(t, "ssh", service.Name)
+	assert.Equal(t, "OpenSSH_9.0", service.Version)
+	assert.Equal(t, "2.0", service.SSH.ProtocolVersion)
+	assert.Equal(t, "FakeOS-1", service.SSH.Comments)
+	assert.Equal(t, []string{"aes128-ctr", "3des-cbc"}, service.SSH.Ciphers)
+	assert.Equal(t, []string{"diffie-hellman-group1-sha1", "3des-cbc", "hmac-md5"}, service.SSH.WeakAlgorithms)
+	require.Len(t, service.SSH.HostKeys, 2)
+	assert.Equal(t, "ssh-ed25519", service.SSH.HostKeys[0].Algorithm)
+	assert.Equal(t, "rsa-sha2-512", service.SSH.HostKeys[1].Algorithm)
+	assert.True(t, strings.HasPrefix(service.SSH.HostKeys[0].Fingerprint, "SHA256:"))
+}
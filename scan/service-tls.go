@@ -0,0 +1,468 @@
+package scan
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// TLSInfo holds an active fingerprint of a TLS server, built from its responses to a fixed set of ClientHellos.
+//
+// The fingerprint follows the JARM layout - 30 characters encoding the cipher and version chosen for each probe,
+// followed by a truncated SHA256 of the ALPN and extensions returned - but the probes themselves are furious' own,
+// so values are only comparable with other furious scans.
+type TLSInfo struct {
+	Fingerprint string
+	Version     string
+	Cipher      string
+	ALPN        string
+	Responses   []string
+}
+
+type tlsCipherOrder int
+
+const (
+	tlsOrderForward tlsCipherOrder = iota
+	tlsOrderReverse
+	tlsOrderTopHalf
+	tlsOrderBottomHalf
+	tlsOrderMiddleOut
+)
+
+type tlsProbe struct {
+	version        uint16
+	tls13Ciphers   bool
+	order          tlsCipherOrder
+	grease         bool
+	rareALPN       bool
+	maxVersion     uint16
+	reverseExtList bool
+}
+
+const (
+	tlsVersion10 uint16 = 0x0301
+	tlsVersion11 uint16 = 0x0302
+	tlsVersion12 uint16 = 0x0303
+	tlsVersion13 uint16 = 0x0304
+
+	tlsHandshakeClientHello = 1
+	tlsHandshakeServerHello = 2
+
+	tlsMaxRecord = 16384 + 2048
+)
+
+var tlsProbes = []tlsProbe{
+	{version: tlsVersion12, tls13Ciphers: true, order: tlsOrderForward, maxVersion: tlsVersion12, reverseExtList: true},
+	{version: tlsVersion12, tls13Ciphers: true, order: tlsOrderReverse, maxVersion: tlsVersion12},
+	{version: tlsVersion12, tls13Ciphers: true, order: tlsOrderTopHalf},
+	{version: tlsVersion12, tls13Ciphers: true, order: tlsOrderBottomHalf, rareALPN: true},
+	{version: tlsVersion12, tls13Ciphers: true, order: tlsOrderMiddleOut, grease: true, rareALPN: true, reverseExtList: true},
+	{version: tlsVersion11, tls13Ciphers: true, order: tlsOrderForward},
+	{version: tlsVersion13, tls13Ciphers: true, order: tlsOrderForward, maxVersion: tlsVersion13, reverseExtList: true},
+	{version: tlsVersion13, tls13Ciphers: true, order: tlsOrderReverse, maxVersion: tlsVersion13},
+	{version: tlsVersion13, tls13Ciphers: false, order: tlsOrderForward, maxVersion: tlsVersion13},
+	{version: tlsVersion13, tls13Ciphers: true, order: tlsOrderMiddleOut, grease: true, maxVersion: tlsVersion13, reverseExtList: true},
+}
+
+// the position of the chosen cipher in this list forms part of the fingerprint, so it must never be reordered
+var tlsCiphers = []uint16{
+	0x0004, 0x0005, 0x0007, 0x000a, 0x0016, 0x002f, 0x0033, 0x0035, 0x0039, 0x003c, 0x003d, 0x0041, 0x0045, 0x0067,
+	0x006b, 0x0084, 0x0088, 0x009a, 0x009c, 0x009d, 0x009e, 0x009f, 0x00ba, 0x00be, 0x00c0, 0x00c4, 0xc007, 0xc008,
+	0xc009, 0xc00a, 0xc011, 0xc012, 0xc013, 0xc014, 0xc023, 0xc024, 0xc027, 0xc028, 0xc02b, 0xc02c, 0xc02f, 0xc030,
+	0xc060, 0xc061, 0xc072, 0xc073, 0xc076, 0xc077, 0xc09c, 0xc09d, 0xc09e, 0xc09f, 0xc0a0, 0xc0a1, 0xc0a2, 0xc0a3,
+	0xc0ac, 0xc0ad, 0xc0ae, 0xc0af, 0xcc13, 0xcc14, 0xcca8, 0xcca9, 0x1301, 0x1302, 0x1303, 0x1304, 0x1305,
+}
+
+var tlsALPN = []string{"h2", "http/1.1"}
+var tlsRareALPN = []string{"hq", "h2c", "spdy/3", "spdy/2", "spdy/1", "http/1.0", "http/0.9"}
+
+// tlsPorts are always probed, whereas other ports are only probed if they send no banner
+var tlsPorts = map[int]bool{443: true, 465: true, 636: true, 853: true, 989: true, 990: true, 992: true, 993: true, 995: true, 3269: true, 4443: true, 5061: true, 8443: true, 9443: true}
+
+type tlsModule struct{}
+
+func init() {
+	registerServiceModule(tlsModule{})
+}
+
+func (tlsModule) Name() string {
+	return "tls"
+}
+
+func (tlsModule) Match(port int, banner string) bool {
+	return tlsPorts[port] || banner == ""
+}
+
+func (m tlsModule) Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error {
+
+	info := &TLSInfo{}
+	raw := ""
+	codes := ""
+
+	for i, probe := range tlsProbes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		hello, err := m.probe(host, port, timeout, probe)
+		if err != nil {
+			// if the first probe doesn't get a TLS response, this isn't a TLS service
+			if i == 0 {
+				return err
+			}
+		}
+
+		if hello == nil {
+			info.Responses = append(info.Responses, "|||")
+			codes += "000"
+			continue
+		}
+
+		info.Responses = append(info.Responses, hello.String())
+		raw += hello.alpn + hello.extensions()
+		codes += fmt.Sprintf("%02x%c", tlsCipherIndex(hello.cipher), tlsVersionCode(hello.version))
+
+		if info.Version == "" || hello.version > tlsVersionNumber(info.Version) {
+			info.Version = tlsVersionName(hello.version)
+			info.Cipher = fmt.Sprintf("0x%04x", hello.cipher)
+			info.ALPN = hello.alpn
+		}
+	}
+
+	if strings.Trim(codes, "0") == "" {
+		info.Fingerprint = strings.Repeat("0", 62)
+	} else {
+		sum := sha256.Sum256([]byte(raw))
+		info.Fingerprint = codes + hex.EncodeToString(sum[:])[:32]
+	}
+
+	service.TLS = info
+	return nil
+}
+
+type tlsServerHello struct {
+	version       uint16
+	cipher        uint16
+	alpn          string
+	extensionList []uint16
+}
+
+func (h *tlsServerHello) extensions() string {
+	list := []string{}
+	for _, extension := range h.extensionList {
+		list = append(list, fmt.Sprintf("%04x", extension))
+	}
+	return strings.Join(list, "-")
+}
+
+func (h *tlsServerHello) String() string {
+	return fmt.Sprintf("%04x|%04x|%s|%s", h.cipher, h.version, h.alpn, h.extensions())
+}
+
+// probe sends a single ClientHello and returns the server's ServerHello. A nil hello with no error indicates the
+// server rejected the handshake, e.g. with an alert.
+func (m tlsModule) probe(host net.IP, port int, timeout time.Duration, probe tlsProbe) (*tlsServerHello, error) {
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host.String(), fmt.Sprintf("%d", port)), timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(buildClientHello(probe)); err != nil {
+		return nil, err
+	}
+
+	handshake := []byte{}
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(header[3:]))
+		if header[1] != 3 || length > tlsMaxRecord {
+			return nil, errors.New("not a TLS service")
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return nil, err
+		}
+
+		switch layers.TLSType(header[0]) {
+		case layers.TLSAlert:
+			return nil, nil
+		case layers.TLSHandshake:
+			handshake = append(handshake, body...)
+		default:
+			return nil, errors.New("unexpected TLS record")
+		}
+
+		if len(handshake) < 4 {
+			continue
+		}
+		if handshake[0] != tlsHandshakeServerHello {
+			return nil, errors.New("expected TLS ServerHello")
+		}
+		messageLength := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if len(handshake) >= 4+messageLength {
+			return parseServerHello(handshake[4 : 4+messageLength])
+		}
+	}
+}
+
+func parseServerHello(data []byte) (*tlsServerHello, error) {
+	malformed := errors.New("malformed TLS ServerHello")
+
+	if len(data) < 35 {
+		return nil, malformed
+	}
+	hello := &tlsServerHello{
+		version: binary.BigEndian.Uint16(data),
+	}
+	data = data[34:]
+	sessionLength := int(data[0])
+	if len(data) < 1+sessionLength+3 {
+		return nil, malformed
+	}
+	data = data[1+sessionLength:]
+	hello.cipher = binary.BigEndian.Uint16(data)
+	data = data[3:]
+
+	if len(data) < 2 {
+		return hello, nil
+	}
+	extensionsLength := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < extensionsLength {
+		return nil, malformed
+	}
+	data = data[:extensionsLength]
+
+	for len(data) >= 4 {
+		extension := binary.BigEndian.Uint16(data)
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		if len(data) < 4+length {
+			return nil, malformed
+		}
+		value := data[4 : 4+length]
+		hello.extensionList = append(hello.extensionList, extension)
+
+		switch extension {
+		case 0x0010:
+			if len(value) > 3 {
+				hello.alpn = string(value[3:])
+			}
+		case 0x002b:
+			if len(value) == 2 {
+				hello.version = binary.BigEndian.Uint16(value)
+			}
+		}
+		data = data[4+length:]
+	}
+
+	return hello, nil
+}
+
+func buildClientHello(probe tlsProbe) []byte {
+
+	ciphers := orderTLSCiphers(probe)
+	if probe.grease {
+		ciphers = append([]uint16{0x0a0a}, ciphers...)
+	}
+
+	alpn := tlsALPN
+	if probe.rareALPN {
+		alpn = tlsRareALPN
+	}
+
+	extensions := [][]byte{
+		tlsExtension(0x0017, nil),
+		tlsExtension(0xff01, []byte{0}),
+		tlsExtension(0x000a, tlsUint16List(2, []uint16{0x001d, 0x0017, 0x0018, 0x0019})),
+		tlsExtension(0x000b, []byte{1, 0}),
+		tlsExtension(0x0023, nil),
+		tlsExtension(0x0010, tlsALPNList(alpn)),
+		tlsExtension(0x000d, tlsUint16List(2, []uint16{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601, 0x0201})),
+	}
+
+	if probe.maxVersion == tlsVersion13 {
+		keyShare := make([]byte, 32)
+		_, _ = rand.Read(keyShare)
+		entry := append([]byte{0x00, 0x1d, 0x00, 0x20}, keyShare...)
+		extensions = append(extensions,
+			tlsExtension(0x0033, append([]byte{0, byte(len(entry))}, entry...)),
+			tlsExtension(0x002d, []byte{1, 1}),
+		)
+	}
+
+	if probe.maxVersion > 0 {
+		versions := []uint16{}
+		for v := probe.maxVersion; v >= tlsVersion10; v-- {
+			versions = append(versions, v)
+		}
+		if probe.grease {
+			versions = append([]uint16{0x1a1a}, versions...)
+		}
+		list := tlsUint16List(0, versions)
+		extensions = append(extensions, tlsExtension(0x002b, append([]byte{byte(len(list))}, list...)))
+	}
+
+	if probe.grease {
+		extensions = append([][]byte{tlsExtension(0x2a2a, nil)}, extensions...)
+	}
+
+	if probe.reverseExtList {
+		for i, j := 0, len(extensions)-1; i < j; i, j = i+1, j-1 {
+			extensions[i], extensions[j] = extensions[j], extensions[i]
+		}
+	}
+
+	clientVersion := probe.version
+	if clientVersion == tlsVersion13 {
+		clientVersion = tlsVersion12
+	}
+
+	body := []byte{byte(clientVersion >> 8), byte(clientVersion)}
+	random := make([]byte, 32+1+32)
+	_, _ = rand.Read(random)
+	random[32] = 32
+	body = append(body, random...)
+	body = append(body, tlsUint16List(2, ciphers)...)
+	body = append(body, 1, 0)
+
+	extensionData := []byte{}
+	for _, extension := range extensions {
+		extensionData = append(extensionData, extension...)
+	}
+	body = append(body, byte(len(extensionData)>>8), byte(len(extensionData)))
+	body = append(body, extensionData...)
+
+	handshake := append([]byte{tlsHandshakeClientHello, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+
+	recordVersion := tlsVersion10
+	if probe.version == tlsVersion11 {
+		recordVersion = tlsVersion11
+	}
+	record := []byte{byte(layers.TLSHandshake), byte(recordVersion >> 8), byte(recordVersion), byte(len(handshake) >> 8), byte(len(handshake))}
+	return append(record, handshake...)
+}
+
+func orderTLSCiphers(probe tlsProbe) []uint16 {
+	ciphers := []uint16{}
+	for _, cipher := range tlsCiphers {
+		if cipher>>8 == 0x13 && !probe.tls13Ciphers {
+			continue
+		}
+		ciphers = append(ciphers, cipher)
+	}
+
+	switch probe.order {
+	case tlsOrderReverse:
+		for i, j := 0, len(ciphers)-1; i < j; i, j = i+1, j-1 {
+			ciphers[i], ciphers[j] = ciphers[j], ciphers[i]
+		}
+	case tlsOrderTopHalf:
+		ciphers = ciphers[:len(ciphers)/2]
+	case tlsOrderBottomHalf:
+		ciphers = ciphers[len(ciphers)/2:]
+	case tlsOrderMiddleOut:
+		ordered := []uint16{}
+		middle := len(ciphers) / 2
+		for i := 0; len(ordered) < len(ciphers); i++ {
+			if middle+i < len(ciphers) {
+				ordered = append(ordered, ciphers[middle+i])
+			}
+			if i > 0 && middle-i >= 0 {
+				ordered = append(ordered, ciphers[middle-i])
+			}
+		}
+		ciphers = ordered
+	}
+
+	return ciphers
+}
+
+func tlsExtension(extension uint16, data []byte) []byte {
+	return append([]byte{byte(extension >> 8), byte(extension), byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// tlsUint16List encodes values with a length prefix of prefixSize bytes (or none if zero)
+func tlsUint16List(prefixSize int, values []uint16) []byte {
+	data := []byte{}
+	if prefixSize == 2 {
+		data = append(data, byte(len(values)*2>>8), byte(len(values)*2))
+	}
+	for _, value := range values {
+		data = append(data, byte(value>>8), byte(value))
+	}
+	return data
+}
+
+func tlsALPNList(protocols []string) []byte {
+	list := []byte{}
+	for _, protocol := range protocols {
+		list = append(list, byte(len(protocol)))
+		list = append(list, protocol...)
+	}
+	return append([]byte{byte(len(list) >> 8), byte(len(list))}, list...)
+}
+
+func tlsCipherIndex(cipher uint16) int {
+	for i, known := range tlsCiphers {
+		if known == cipher {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func tlsVersionCode(version uint16) byte {
+	if version < 0x0300 || version > tlsVersion13 {
+		return '0'
+	}
+	return byte('a' + version - 0x0300)
+}
+
+var tlsVersionNames = map[uint16]string{
+	0x0300:       "SSLv3",
+	tlsVersion10: "TLSv1.0",
+	tlsVersion11: "TLSv1.1",
+	tlsVersion12: "TLSv1.2",
+	tlsVersion13: "TLSv1.3",
+}
+
+func tlsVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+func tlsVersionNumber(name string) uint16 {
+	for version, known := range tlsVersionNames {
+		if known == name {
+			return version
+		}
+	}
+	return 0
+}
+
+// Details summarises the information for display.
+func (i *TLSInfo) Details() []string {
+	details := []string{fmt.Sprintf("tls fingerprint %s", i.Fingerprint)}
+	if i.Version != "" {
+		details = append(details, fmt.Sprintf("tls %s cipher %s", i.Version, i.Cipher))
+	}
+	return details
+}
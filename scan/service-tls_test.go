@@ -0,0 +1,56 @@
+package scan
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSModule(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	modules, err := GetServiceModules("tls")
+	require.NoError(t, err)
+
+	fingerprints := []string{}
+	for i := 0; i < 2; i++ {
+		result := NewResult(net.ParseIP("127.0.0.1"))
+		result.Open = []int{port}
+		IdentifyServices(context.Background(), &result, modules, time.Second)
+
+		service := result.Service(port)
+		require.NotNil(t, service)
+		require.NotNil(t, service.TLS)
+		assert.Len(t, service.TLS.Fingerprint, 62)
+		assert.Len(t, service.TLS.Responses, len(tlsProbes))
+		assert.Equal(t, "TLSv1.3", service.TLS.Version)
+		fingerprints = append(fingerprints, service.TLS.Fingerprint)
+	}
+
+	assert.Equal(t, fingerprints[0], fingerprints[1])
+}
+
+func TestTLSModuleIgnoresPlaintext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	modules, err := GetServiceModules("tls")
+	require.NoError(t, err)
+
+	result := NewResult(net.ParseIP("127.0.0.1"))
+	result.Open = []int{port}
+	IdentifyServices(context.Background(), &result, modules, 200*time.Millisecond)
+
+	service := result.Service(port)
+	require.NotNil(t, service)
+	assert.Nil(t, service.TLS)
+}
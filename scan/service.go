@@ -0,0 +1,200 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Service holds the details gathered by service modules about whatever is listening on an open port.
+type Service struct {
+	Port      int
+	Name      string
+	Version   string
+	Banner    string
+	SSH       *SSHInfo
+	TLS       *TLSInfo
+	SMB       *SMBInfo
+	Datastore *DatastoreInfo
+	// Recorded holds the details of a service read back from a report, where the fields above are not kept
+	Recorded []string
+}
+
+// ServiceModule identifies a particular type of service and collects further details about it.
+type ServiceModule interface {
+	// Name is the unique identifier of the module
+	Name() string
+	// Match reports whether the module should be run against the given port, given the banner (if any) the
+	// service sent upon connection.
+	Match(port int, banner string) bool
+	// Identify connects to the service and records whatever it learns in service.
+	Identify(ctx context.Context, host net.IP, port int, timeout time.Duration, service *Service) error
+}
+
+// intrusiveModule is implemented by service modules which do more than identify a service, such as trying
+// credentials. Intrusive modules only run when they are named.
+type intrusiveModule interface {
+	Intrusive() bool
+}
+
+func isIntrusive(module ServiceModule) bool {
+	intrusive, ok := module.(intrusiveModule)
+	return ok && intrusive.Intrusive()
+}
+
+const maxBannerLength = 1024
+
+// maxServiceRoutines limits the number of ports on a single host which are probed at once
+const maxServiceRoutines = 16
+
+var serviceModules = map[string]ServiceModule{}
+
+func registerServiceModule(module ServiceModule) {
+	serviceModules[module.Name()] = module
+}
+
+// ServiceModuleNames returns the names of all available service modules, sorted alphabetically.
+func ServiceModuleNames() []string {
+	names := []string{}
+	for name := range serviceModules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultServiceModuleNames returns the names of the service modules which run when none are named: every module
+// which isn't intrusive, sorted alphabetically.
+func DefaultServiceModuleNames() []string {
+	names := []string{}
+	for _, name := range ServiceModuleNames() {
+		if !isIntrusive(serviceModules[name]) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// GetServiceModules returns the service modules with the given names, or the default modules if no names are given.
+func GetServiceModules(names ...string) ([]ServiceModule, error) {
+	if len(names) == 0 {
+		names = DefaultServiceModuleNames()
+	}
+	modules := []ServiceModule{}
+	for _, name := range names {
+		module, ok := serviceModules[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("Unknown service module '%s'", name)
+		}
+		modules = append(modules, module)
+	}
+	return modules, nil
+}
+
+// IdentifyServices runs the given service modules against every open port in the result, regardless of which
+// scanner produced it.
+func IdentifyServices(ctx context.Context, result *Result, modules []ServiceModule, timeout time.Duration) {
+
+	if len(result.Open) == 0 || len(modules) == 0 {
+		return
+	}
+
+	services := make([]Service, len(result.Open))
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, maxServiceRoutines)
+
+	for i, port := range result.Open {
+		wg.Add(1)
+		go func(service *Service, port int) {
+			defer wg.Done()
+
+			select {
+			case <-ctx.Done():
+				return
+			case sem <- struct{}{}:
+			}
+			defer func() { <-sem }()
+
+			service.Port = port
+			service.Name = DescribePort(port)
+			service.Banner = grabBanner(result.Host, port, timeout)
+
+			for _, module := range modules {
+				if ctx.Err() != nil {
+					return
+				}
+				if !module.Match(port, service.Banner) {
+					continue
+				}
+				if err := module.Identify(ctx, result.Host, port, timeout, service); err != nil {
+					logrus.Debugf("Service module %s failed for %s:%d: %s", module.Name(), result.Host, port, err)
+				}
+			}
+		}(&services[i], port)
+	}
+
+	wg.Wait()
+
+	result.Services = services
+}
+
+// grabBanner returns whatever the service sends immediately upon connection, if anything.
+func grabBanner(host net.IP, port int, timeout time.Duration) string {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host.String(), fmt.Sprintf("%d", port)), timeout)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
+	buffer := make([]byte, maxBannerLength)
+	n, _ := conn.Read(buffer)
+	return strings.TrimSpace(string(buffer[:n]))
+}
+
+// Description returns the name and version of the service, falling back to the IANA name for the port.
+func (s Service) Description() string {
+	description := s.Name
+	if description == "" {
+		description = DescribePort(s.Port)
+	}
+	if s.Version != "" {
+		description = fmt.Sprintf("%s %s", description, s.Version)
+	}
+	return description
+}
+
+// Details returns additional lines of information gathered by service modules.
+func (s Service) Details() []string {
+	details := []string{}
+	if s.SSH != nil {
+		details = append(details, s.SSH.Details()...)
+	}
+	if s.TLS != nil {
+		details = append(details, s.TLS.Details()...)
+	}
+	if s.SMB != nil {
+		details = append(details, s.SMB.Details()...)
+	}
+	if s.Datastore != nil {
+		details = append(details, s.Datastore.Details()...)
+	}
+	return append(details, s.Recorded...)
+}
+
+// Service returns the details recorded for the given port, or nil if none were recorded.
+func (r Result) Service(port int) *Service {
+	for i := range r.Services {
+		if r.Services[i].Port == port {
+			return &r.Services[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package scan
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNMPInfo holds the system information reported by an SNMP agent, and the community strings it accepted.
+type SNMPInfo struct {
+	Communities []SNMPCommunity
+	SysDescr    string
+	SysObjectID string
+	SysName     string
+	SysUpTime   time.Duration
+	EngineID    string
+	EngineBoots int
+	EngineTime  int
+}
+
+// SNMPCommunity is a community string which was accepted by an agent, along with the protocol version used.
+type SNMPCommunity struct {
+	Community string
+	Version   string
+}
+
+// DefaultSNMPCommunities are tried when no communities are configured.
+var DefaultSNMPCommunities = []string{"public", "private"}
+
+const (
+	snmpPort = 161
+
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berSequence    = 0x30
+	berTimeTicks   = 0x43
+
+	snmpGetRequest  = 0xa0
+	snmpGetResponse = 0xa2
+	snmpReport      = 0xa8
+
+	snmpVersion1  = 0
+	snmpVersion2c = 1
+	snmpVersion3  = 3
+
+	oidSysDescr    = "1.3.6.1.2.1.1.1.0"
+	oidSysObjectID = "1.3.6.1.2.1.1.2.0"
+	oidSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	oidSysName     = "1.3.6.1.2.1.1.5.0"
+)
+
+var snmpSystemOIDs = []string{oidSysDescr, oidSysObjectID, oidSysUpTime, oidSysName}
+
+// CheckSNMP queries the SNMP agent on the host (if any) and records what it finds in the result. If the agent
+// responds, the host is considered up even if it did not respond to the port scan. Hosts which didn't respond to the
+// port scan are given up on if they don't answer the first community, so a sweep of unused addresses costs a single
+// timeout for each.
+func CheckSNMP(ctx context.Context, result *Result, communities []string, engineDiscovery bool, timeout time.Duration) {
+	start := time.Now()
+	info, err := querySNMP(ctx, hostPort(result.Host, snmpPort), communities, engineDiscovery, timeout, result.IsHostUp())
+	if err != nil {
+		return
+	}
+	if !result.IsHostUp() {
+		result.Latency = time.Since(start)
+	}
+	result.SNMP = info
+}
+
+// QuerySNMP tries each community with SNMPv2c and SNMPv1, reading sysDescr, sysObjectID, sysUpTime and sysName from
+// the first which is accepted. If engineDiscovery is set, SNMPv3 engine discovery is also performed.
+func QuerySNMP(ctx context.Context, addr string, communities []string, engineDiscovery bool, timeout time.Duration) (*SNMPInfo, error) {
+	return querySNMP(ctx, addr, communities, engineDiscovery, timeout, true)
+}
+
+// querySNMP queries an agent as QuerySNMP does. Queries are sent at once rather than one after another: first the
+// first community (along with engine discovery), then, if the agent answered or the host is known to be up, every
+// other community.
+func querySNMP(ctx context.Context, addr string, communities []string, engineDiscovery bool, timeout time.Duration, knownUp bool) (*SNMPInfo, error) {
+
+	if len(communities) == 0 {
+		communities = DefaultSNMPCommunities
+	}
+
+	engine := &SNMPInfo{}
+	engineErr := make(chan error, 1)
+	if engineDiscovery {
+		go func() {
+			engineErr <- discoverSNMPEngine(addr, timeout, engine)
+		}()
+	} else {
+		engineErr <- errors.New("engine discovery disabled")
+	}
+
+	attempts := trySNMPCommunities(addr, communities[:1], timeout)
+	engineFound := <-engineErr == nil
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if len(communities) > 1 && (knownUp || engineFound || attempts[0].values != nil) {
+		attempts = append(attempts, trySNMPCommunities(addr, communities[1:], timeout)...)
+	}
+
+	info := &SNMPInfo{}
+	if engineFound {
+		info.EngineID, info.EngineBoots, info.EngineTime = engine.EngineID, engine.EngineBoots, engine.EngineTime
+	}
+	for _, attempt := range attempts {
+		if attempt.values == nil {
+			continue
+		}
+		// system values are read from the first community which is accepted
+		if len(info.Communities) == 0 {
+			info.applySystemValues(attempt.values)
+		}
+		info.Communities = append(info.Communities, attempt.community)
+	}
+
+	if !engineFound && len(info.Communities) == 0 {
+		return nil, errors.New("no response from SNMP agent")
+	}
+	return info, nil
+}
+
+// snmpAttempt is the outcome of trying a community. Values is nil if it wasn't accepted.
+type snmpAttempt struct {
+	community SNMPCommunity
+	values    map[string]berElement
+}
+
+// trySNMPCommunities tries each community with SNMPv2c and SNMPv1 at once, and returns an attempt for each in the
+// same order. SNMPv2c is preferred when an agent accepts both.
+func trySNMPCommunities(addr string, communities []string, timeout time.Duration) []snmpAttempt {
+	versions := []int{snmpVersion2c, snmpVersion1}
+	replies := make([][]map[string]berElement, len(communities))
+	wg := sync.WaitGroup{}
+	for i, community := range communities {
+		replies[i] = make([]map[string]berElement, len(versions))
+		for j, version := range versions {
+			wg.Add(1)
+			go func(i int, j int, community string, version int) {
+				defer wg.Done()
+				if values, err := snmpGet(addr, version, community, snmpSystemOIDs, timeout); err == nil {
+					replies[i][j] = values
+				}
+			}(i, j, community, version)
+		}
+	}
+	wg.Wait()
+
+	attempts := []snmpAttempt{}
+	for i, community := range communities {
+		attempt := snmpAttempt{community: SNMPCommunity{Community: community}}
+		for j, version := range versions {
+			if replies[i][j] != nil {
+				attempt.values = replies[i][j]
+				attempt.community.Version = "v2c"
+				if version == snmpVersion1 {
+					attempt.community.Version = "v1"
+				}
+				break
+			}
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts
+}
+
+func (i *SNMPInfo) applySystemValues(values map[string]berElement) {
+	for oid, value := range values {
+		switch oid {
+		case oidSysDescr:
+			i.SysDescr = strings.TrimSpace(string(value.value))
+		case oidSysName:
+			i.SysName = string(value.value)
+		case oidSysObjectID:
+			i.SysObjectID = decodeOID(value.value)
+		case oidSysUpTime:
+			i.SysUpTime = time.Duration(decodeInteger(value.value)) * 10 * time.Millisecond
+		}
+	}
+}
+
+// Details summarises the information for display.
+func (i *SNMPInfo) Details() []string {
+	details := []string{}
+	for _, community := range i.Communities {
+		details = append(details, fmt.Sprintf("snmp community accepted: %s (%s)", community.Community, community.Version))
+	}
+	for _, field := range []struct {
+		label string
+		value string
+	}{
+		{"sysName", i.SysName},
+		{"sysDescr", i.SysDescr},
+		{"sysObjectID", i.SysObjectID},
+		{"engineID", i.EngineID},
+	} {
+		if field.value != "" {
+			details = append(details, fmt.Sprintf("snmp %s: %s", field.label, field.value))
+		}
+	}
+	if i.SysUpTime > 0 {
+		details = append(details, fmt.Sprintf("snmp sysUpTime: %s", i.SysUpTime))
+	}
+	return details
+}
+
+func snmpGet(addr string, version int, community string, oids []string, timeout time.Duration) (map[string]berElement, error) {
+
+	requestID := rand.Int31()
+
+	bindings := []byte{}
+	for _, oid := range oids {
+		bindings = append(bindings, encodeBER(berSequence, append(encodeBER(berOID, encodeOID(oid)), encodeBER(berNull, nil)...))...)
+	}
+	pdu := encodeBER(snmpGetRequest, concat(
+		encodeBER(berInteger, encodeInteger(int64(requestID))),
+		encodeBER(berInteger, encodeInteger(0)),
+		encodeBER(berInteger, encodeInteger(0)),
+		encodeBER(berSequence, bindings),
+	))
+	message := encodeBER(berSequence, concat(
+		encodeBER(berInteger, encodeInteger(int64(version))),
+		encodeBER(berOctetString, []byte(community)),
+		pdu,
+	))
+
+	data, err := exchangeUDP(addr, message, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parseBER(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(root.children) < 3 || root.children[2].tag != snmpGetResponse {
+		return nil, errors.New("unexpected SNMP response")
+	}
+	response := root.children[2]
+	if len(response.children) < 4 || decodeInteger(response.children[0].value) != int64(requestID) {
+		return nil, errors.New("unexpected SNMP response")
+	}
+	if status := decodeInteger(response.children[1].value); status != 0 {
+		return nil, fmt.Errorf("SNMP error status %d", status)
+	}
+
+	values := map[string]berElement{}
+	for _, binding := range response.children[3].children {
+		if len(binding.children) == 2 {
+			values[decodeOID(binding.children[0].value)] = binding.children[1]
+		}
+	}
+	return values, nil
+}
+
+// discoverSNMPEngine sends an unauthenticated SNMPv3 request, to which agents reply with a report containing their
+// authoritative engine ID, boots and time
+func discoverSNMPEngine(addr string, timeout time.Duration, info *SNMPInfo) error {
+
+	messageID := rand.Int31()
+
+	security := encodeBER(berSequence, concat(
+		encodeBER(berOctetString, nil),
+		encodeBER(berInteger, encodeInteger(0)),
+		encodeBER(berInteger, encodeInteger(0)),
+		encodeBER(berOctetString, nil),
+		encodeBER(berOctetString, nil),
+		encodeBER(berOctetString, nil),
+	))
+	pdu := encodeBER(snmpGetRequest, concat(
+		encodeBER(berInteger, encodeInteger(int64(rand.Int31()))),
+		encodeBER(berInteger, encodeInteger(0)),
+		encodeBER(berInteger, encodeInteger(0)),
+		encodeBER(berSequence, nil),
+	))
+	message := encodeBER(berSequence, concat(
+		encodeBER(berInteger, encodeInteger(snmpVersion3)),
+		encodeBER(berSequence, concat(
+			encodeBER(berInteger, encodeInteger(int64(messageID))),
+			encodeBER(berInteger, encodeInteger(65507)),
+			encodeBER(berOctetString, []byte{0x04}),
+			encodeBER(berInteger, encodeInteger(3)),
+		)),
+		encodeBER(berOctetString, security),
+		encodeBER(berSequence, concat(
+			encodeBER(berOctetString, nil),
+			encodeBER(berOctetString, nil),
+			pdu,
+		)),
+	))
+
+	data, err := exchangeUDP(addr, message, timeout)
+	if err != nil {
+		return err
+	}
+
+	root, err := parseBER(data)
+	if err != nil {
+		return err
+	}
+	if len(root.children) < 3 || decodeInteger(root.children[0].value) != snmpVersion3 {
+		return errors.New("unexpected SNMPv3 response")
+	}
+	params, err := parseBER(root.children[2].value)
+	if err != nil || len(params.children) < 3 {
+		return errors.New("malformed SNMPv3 security parameters")
+	}
+
+	info.EngineID = hex.EncodeToString(params.children[0].value)
+	info.EngineBoots = int(decodeInteger(params.children[1].value))
+	info.EngineTime = int(decodeInteger(params.children[2].value))
+	return nil
+}
+
+type berElement struct {
+	tag      byte
+	value    []byte
+	children []berElement
+}
+
+func parseBER(data []byte) (berElement, error) {
+	element, _, err := readBER(data)
+	return element, err
+}
+
+func readBER(data []byte) (berElement, []byte, error) {
+	malformed := errors.New("malformed BER data")
+
+	if len(data) < 2 {
+		return berElement{}, nil, malformed
+	}
+	element := berElement{tag: data[0]}
+	length := int(data[1])
+	offset := 2
+	if length&0x80 > 0 {
+		size := length & 0x7f
+		if size == 0 || size > 4 || len(data) < 2+size {
+			return berElement{}, nil, malformed
+		}
+		length = 0
+		for _, b := range data[2 : 2+size] {
+			length = length<<8 | int(b)
+		}
+		offset += size
+	}
+	if length < 0 || len(data) < offset+length {
+		return berElement{}, nil, malformed
+	}
+	element.value = data[offset : offset+length]
+
+	// constructed types (sequences and PDUs) contain further elements
+	if element.tag&0x20 > 0 {
+		remaining := element.value
+		for len(remaining) > 0 {
+			child, rest, err := readBER(remaining)
+			if err != nil {
+				return berElement{}, nil, err
+			}
+			element.children = append(element.children, child)
+			remaining = rest
+		}
+	}
+
+	return element, data[offset+length:], nil
+}
+
+func encodeBER(tag byte, value []byte) []byte {
+	length := len(value)
+	var header []byte
+	if length < 0x80 {
+		header = []byte{tag, byte(length)}
+	} else {
+		lengthBytes := []byte{}
+		for l := length; l > 0; l >>= 8 {
+			lengthBytes = append([]byte{byte(l)}, lengthBytes...)
+		}
+		header = append([]byte{tag, 0x80 | byte(len(lengthBytes))}, lengthBytes...)
+	}
+	return append(header, value...)
+}
+
+func encodeInteger(value int64) []byte {
+	encoded := []byte{byte(value)}
+	for value > 127 || value < -128 {
+		value >>= 8
+		encoded = append([]byte{byte(value)}, encoded...)
+	}
+	return encoded
+}
+
+func decodeInteger(data []byte) int64 {
+	var value int64
+	for i, b := range data {
+		if i == 0 && b&0x80 > 0 {
+			value = -1
+		}
+		value = value<<8 | int64(b)
+	}
+	return value
+}
+
+func encodeOID(oid string) []byte {
+	parts := strings.Split(oid, ".")
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		_, _ = fmt.Sscanf(part, "%d", &values[i])
+	}
+	if len(values) < 2 {
+		return nil
+	}
+	encoded := []byte{byte(values[0]*40 + values[1])}
+	for _, value := range values[2:] {
+		chunk := []byte{byte(value & 0x7f)}
+		for value >>= 7; value > 0; value >>= 7 {
+			chunk = append([]byte{byte(value&0x7f) | 0x80}, chunk...)
+		}
+		encoded = append(encoded, chunk...)
+	}
+	return encoded
+}
+
+func decodeOID(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	parts := []string{fmt.Sprintf("%d", data[0]/40), fmt.Sprintf("%d", data[0]%40)}
+	value := 0
+	for _, b := range data[1:] {
+		value = value<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			parts = append(parts, fmt.Sprintf("%d", value))
+			value = 0
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func concat(parts ...[]byte) []byte {
+	joined := []byte{}
+	for _, part := range parts {
+		joined = append(joined, part...)
+	}
+	return joined
+}
@@ -0,0 +1,121 @@
+package scan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// respondSNMP is a stub agent which only accepts the "public" community
+func respondSNMP(request []byte) []byte {
+	message, err := parseBER(request)
+	if err != nil || len(message.children) < 3 {
+		return nil
+	}
+
+	version := message.children[0]
+	if decodeInteger(version.value) == snmpVersion3 {
+		security := encodeBER(berSequence, concat(
+			encodeBER(berOctetString, []byte{0x80, 0x00, 0x1f, 0x88, 0x80, 0x01, 0x02}),
+			encodeBER(berInteger, encodeInteger(5)),
+			encodeBER(berInteger, encodeInteger(1234)),
+			encodeBER(berOctetString, nil),
+			encodeBER(berOctetString, nil),
+			encodeBER(berOctetString, nil),
+		))
+		return encodeBER(berSequence, concat(
+			encodeBER(berInteger, encodeInteger(snmpVersion3)),
+			encodeBER(berSequence, message.children[1].value),
+			encodeBER(berOctetString, security),
+			encodeBER(berSequence, concat(
+				encodeBER(berOctetString, nil),
+				encodeBER(berOctetString, nil),
+				encodeBER(snmpReport, nil),
+			)),
+		))
+	}
+
+	if string(message.children[1].value) != "public" {
+		return nil
+	}
+
+	request = message.children[2].children[0].value
+	values := map[string][]byte{
+		oidSysDescr:    encodeBER(berOctetString, []byte("Cisco IOS Software, C2960 Software")),
+		oidSysObjectID: encodeBER(berOID, encodeOID("1.3.6.1.4.1.9.1.716")),
+		oidSysUpTime:   encodeBER(berTimeTicks, encodeInteger(360000)),
+		oidSysName:     encodeBER(berOctetString, []byte("core-switch")),
+	}
+	bindings := []byte{}
+	for _, binding := range message.children[2].children[3].children {
+		oid := binding.children[0]
+		bindings = append(bindings, encodeBER(berSequence, append(encodeBER(berOID, oid.value), values[decodeOID(oid.value)]...))...)
+	}
+
+	return encodeBER(berSequence, concat(
+		encodeBER(berInteger, version.value),
+		encodeBER(berOctetString, []byte("public")),
+		encodeBER(snmpGetResponse, concat(
+			encodeBER(berInteger, request),
+			encodeBER(berInteger, encodeInteger(0)),
+			encodeBER(berInteger, encodeInteger(0)),
+			encodeBER(berSequence, bindings),
+		)),
+	))
+}
+
+func TestQuerySNMP(t *testing.T) {
+	conn := serveUDP(t, respondSNMP)
+	defer conn.Close()
+
+	info, err := QuerySNMP(context.Background(), conn.LocalAddr().String(), []string{"private", "public"}, true, 200*time.Millisecond)
+	require.NoError(t, err)
+
+	assert.Equal(t, []SNMPCommunity{{Community: "public", Version: "v2c"}}, info.Communities)
+	assert.Equal(t, "Cisco IOS Software, C2960 Software", info.SysDescr)
+	assert.Equal(t, "1.3.6.1.4.1.9.1.716", info.SysObjectID)
+	assert.Equal(t, "core-switch", info.SysName)
+	assert.Equal(t, time.Hour, info.SysUpTime)
+	assert.Equal(t, "80001f88800102", info.EngineID)
+	assert.Equal(t, 5, info.EngineBoots)
+	assert.Equal(t, 1234, info.EngineTime)
+}
+
+func TestQuerySNMPNoAgent(t *testing.T) {
+	conn := serveUDP(t, func(request []byte) []byte { return nil })
+	defer conn.Close()
+
+	_, err := QuerySNMP(context.Background(), conn.LocalAddr().String(), []string{"public"}, false, 100*time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestQuerySNMPCapsAttempts(t *testing.T) {
+	conn := serveUDP(t, respondSNMP)
+	defer conn.Close()
+
+	// a host which didn't respond to the port scan is given up on when the first community gets no reply
+	_, err := querySNMP(context.Background(), conn.LocalAddr().String(), []string{"private", "public"}, false, 100*time.Millisecond, false)
+	assert.Error(t, err)
+
+	// but every community is tried on a host which is up
+	info, err := querySNMP(context.Background(), conn.LocalAddr().String(), []string{"private", "public"}, false, 100*time.Millisecond, true)
+	require.NoError(t, err)
+	assert.Equal(t, []SNMPCommunity{{Community: "public", Version: "v2c"}}, info.Communities)
+
+	// queries are sent at once, so an agent which never answers costs a single timeout
+	silent := serveUDP(t, func(request []byte) []byte { return nil })
+	defer silent.Close()
+	start := time.Now()
+	_, err = querySNMP(context.Background(), silent.LocalAddr().String(), []string{"a", "b", "c"}, true, 100*time.Millisecond, false)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+}
+
+func TestBERIntegers(t *testing.T) {
+	for _, value := range []int64{0, 1, 127, 128, 255, 256, -1, -129, 2147483647} {
+		assert.Equal(t, value, decodeInteger(encodeInteger(value)))
+	}
+}
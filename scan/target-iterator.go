@@ -3,9 +3,18 @@ package scan
 import (
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"strings"
 )
 
+// Targets produces the addresses to scan. Next returns io.EOF once every address has been produced, or another error
+// if a target can't be resolved.
+type Targets interface {
+	Next() (net.IP, error)
+}
+
+// TargetIterator produces every address in a CIDR, or the single address of an IP or hostname.
 type TargetIterator struct {
 	target string
 	isCIDR bool
@@ -31,6 +40,19 @@ func NewTargetIterator(target string) *TargetIterator {
 	return ti
 }
 
+// Size returns the total number of addresses the iterator will produce: the number of addresses in the network for
+// a CIDR, or 1 for a single address or hostname. Sizes which overflow a uint64 (large IPv6 networks) are capped.
+func (ti *TargetIterator) Size() uint64 {
+	if !ti.isCIDR {
+		return 1
+	}
+	ones, bits := ti.ipnet.Mask.Size()
+	if bits-ones >= 64 {
+		return math.MaxUint64
+	}
+	return 1 << uint(bits-ones)
+}
+
 func (ti *TargetIterator) Next() (net.IP, error) {
 	ti.index++
 	ip, err := ti.get()
@@ -73,6 +95,125 @@ func (ti *TargetIterator) get() (net.IP, error) {
 	return nil, io.EOF
 }
 
+// TargetList produces the addresses of several targets, one after another.
+type TargetList struct {
+	iterators []*TargetIterator
+}
+
+// NewTargets creates a list of targets, each of which is an IP, CIDR or hostname.
+func NewTargets(targets ...string) *TargetList {
+	list := &TargetList{}
+	for _, target := range targets {
+		list.iterators = append(list.iterators, NewTargetIterator(target))
+	}
+	return list
+}
+
+// Size returns the total number of addresses the list will produce.
+func (l *TargetList) Size() uint64 {
+	var total uint64
+	for _, iterator := range l.iterators {
+		size := iterator.Size()
+		if total+size < total {
+			return math.MaxUint64
+		}
+		total += size
+	}
+	return total
+}
+
+func (l *TargetList) Next() (net.IP, error) {
+	for len(l.iterators) > 0 {
+		ip, err := l.iterators[0].Next()
+		if err == io.EOF {
+			l.iterators = l.iterators[1:]
+			continue
+		}
+		return ip, err
+	}
+	return nil, io.EOF
+}
+
+// IPList produces a fixed list of addresses.
+type IPList []net.IP
+
+func (l *IPList) Next() (net.IP, error) {
+	if len(*l) == 0 {
+		return nil, io.EOF
+	}
+	ip := (*l)[0]
+	*l = (*l)[1:]
+	return ip, nil
+}
+
+// Exclusions is a set of addresses which should not be scanned.
+type Exclusions struct {
+	networks []*net.IPNet
+}
+
+// NewExclusions creates a set of exclusions from IPs, CIDRs and hostnames. Hostnames exclude every address they
+// resolve to.
+func NewExclusions(excluded ...string) (*Exclusions, error) {
+	e := &Exclusions{}
+	for _, spec := range excluded {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if _, ipnet, err := net.ParseCIDR(spec); err == nil {
+			e.networks = append(e.networks, ipnet)
+			continue
+		}
+		ips := []net.IP{net.ParseIP(spec)}
+		if ips[0] == nil {
+			var err error
+			if ips, err = net.LookupIP(spec); err != nil {
+				return nil, fmt.Errorf("invalid exclusion '%s': %s", spec, err)
+			}
+		}
+		for _, ip := range ips {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			e.networks = append(e.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return e, nil
+}
+
+// Contains reports whether ip is excluded.
+func (e *Exclusions) Contains(ip net.IP) bool {
+	for _, network := range e.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns targets which skip every excluded address.
+func (e *Exclusions) Filter(targets Targets) Targets {
+	if len(e.networks) == 0 {
+		return targets
+	}
+	return &filteredTargets{targets: targets, exclusions: e}
+}
+
+type filteredTargets struct {
+	targets    Targets
+	exclusions *Exclusions
+}
+
+func (f *filteredTargets) Next() (net.IP, error) {
+	for {
+		ip, err := f.targets.Next()
+		if err != nil || !f.exclusions.Contains(ip) {
+			return ip, err
+		}
+	}
+}
+
 func (ti *TargetIterator) incrementIP() {
 	for j := len(ti.ip) - 1; j >= 0; j-- {
 		ti.ip[j]++
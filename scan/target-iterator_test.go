@@ -2,6 +2,8 @@ package scan
 
 import (
 	"fmt"
+	"io"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,3 +30,62 @@ func TestTestIteration(t *testing.T) {
 	}
 
 }
+
+func TestTargetIteratorSize(t *testing.T) {
+	assert.Equal(t, uint64(256), NewTargetIterator("192.168.1.1/24").Size())
+	assert.Equal(t, uint64(1), NewTargetIterator("192.168.1.1/32").Size())
+	assert.Equal(t, uint64(1), NewTargetIterator("192.168.1.1").Size())
+	assert.Equal(t, uint64(1), NewTargetIterator("example.com").Size())
+	assert.Equal(t, uint64(1<<16), NewTargetIterator("fe80::/112").Size())
+}
+
+func TestTargetList(t *testing.T) {
+	list := NewTargets("10.0.0.0/31", "192.168.1.5")
+	assert.Equal(t, uint64(3), list.Size())
+
+	addresses := []string{}
+	for {
+		ip, err := list.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		addresses = append(addresses, ip.String())
+	}
+	assert.Equal(t, []string{"10.0.0.0", "10.0.0.1", "192.168.1.5"}, addresses)
+}
+
+func TestIPList(t *testing.T) {
+	list := &IPList{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}
+	ip, err := list.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip.String())
+	ip, err = list.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", ip.String())
+	_, err = list.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestExclusions(t *testing.T) {
+	exclusions, err := NewExclusions("10.0.0.0/30", "10.0.0.6")
+	require.NoError(t, err)
+	assert.True(t, exclusions.Contains(net.ParseIP("10.0.0.2")))
+	assert.True(t, exclusions.Contains(net.ParseIP("10.0.0.6")))
+	assert.False(t, exclusions.Contains(net.ParseIP("10.0.0.5")))
+
+	targets := exclusions.Filter(NewTargetIterator("10.0.0.0/29"))
+	addresses := []string{}
+	for {
+		ip, err := targets.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		addresses = append(addresses, ip.String())
+	}
+	assert.Equal(t, []string{"10.0.0.4", "10.0.0.5", "10.0.0.7"}, addresses)
+
+	_, err = NewExclusions("not a valid host name!")
+	assert.Error(t, err)
+}
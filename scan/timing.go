@@ -0,0 +1,193 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Timing is a template of engine parameters which are tuned together, from slow and unobtrusive to fast and noisy,
+// in the style of nmap's -T0 to -T5.
+type Timing struct {
+	Name          string
+	Parallelism   int
+	HostGroupSize int
+	// InitialRTTTimeout is the probe timeout used until round trip times have been measured. The timeout then adapts
+	// to the measured times, within MinRTTTimeout and MaxRTTTimeout.
+	InitialRTTTimeout time.Duration
+	MinRTTTimeout     time.Duration
+	MaxRTTTimeout     time.Duration
+	Retries           int
+	// ScanDelay is the minimum time between probes
+	ScanDelay time.Duration
+}
+
+// Timings are the timing templates, indexed by level. Level 3 matches the defaults.
+var Timings = []Timing{
+	{
+		Name:              "paranoid",
+		Parallelism:       1,
+		HostGroupSize:     1,
+		InitialRTTTimeout: 5 * time.Minute,
+		MinRTTTimeout:     100 * time.Millisecond,
+		MaxRTTTimeout:     5 * time.Minute,
+		Retries:           2,
+		ScanDelay:         5 * time.Minute,
+	},
+	{
+		Name:              "sneaky",
+		Parallelism:       1,
+		HostGroupSize:     1,
+		InitialRTTTimeout: 15 * time.Second,
+		MinRTTTimeout:     100 * time.Millisecond,
+		MaxRTTTimeout:     15 * time.Second,
+		Retries:           2,
+		ScanDelay:         15 * time.Second,
+	},
+	{
+		Name:              "polite",
+		Parallelism:       10,
+		HostGroupSize:     16,
+		InitialRTTTimeout: time.Second,
+		MinRTTTimeout:     100 * time.Millisecond,
+		MaxRTTTimeout:     10 * time.Second,
+		Retries:           2,
+		ScanDelay:         400 * time.Millisecond,
+	},
+	{
+		Name:              "normal",
+		Parallelism:       DefaultParallelism,
+		HostGroupSize:     defaultHostGroupSize,
+		InitialRTTTimeout: DefaultTimeout,
+		MinRTTTimeout:     DefaultTimeout,
+		MaxRTTTimeout:     DefaultTimeout,
+	},
+	{
+		Name:              "aggressive",
+		Parallelism:       1000,
+		HostGroupSize:     512,
+		InitialRTTTimeout: time.Second,
+		MinRTTTimeout:     100 * time.Millisecond,
+		MaxRTTTimeout:     1250 * time.Millisecond,
+		Retries:           1,
+	},
+	{
+		Name:              "insane",
+		Parallelism:       2000,
+		HostGroupSize:     1024,
+		InitialRTTTimeout: 250 * time.Millisecond,
+		MinRTTTimeout:     50 * time.Millisecond,
+		MaxRTTTimeout:     300 * time.Millisecond,
+	},
+}
+
+// TimingTemplate returns the timing template for a level from 0 to 5.
+func TimingTemplate(level int) (Timing, error) {
+	if level < 0 || level >= len(Timings) {
+		return Timing{}, fmt.Errorf("invalid timing template %d: must be from 0 to %d", level, len(Timings)-1)
+	}
+	return Timings[level], nil
+}
+
+// Apply returns a copy of options with every parameter of the template set.
+func (t Timing) Apply(options Options) Options {
+	options.Parallelism = t.Parallelism
+	options.HostGroupSize = t.HostGroupSize
+	options.Timeout = t.InitialRTTTimeout
+	options.MinRTTTimeout = t.MinRTTTimeout
+	options.MaxRTTTimeout = t.MaxRTTTimeout
+	options.Retries = t.Retries
+	options.ScanDelay = t.ScanDelay
+	return options
+}
+
+// timing is embedded by scanners to adapt the probe timeout to the round trip times they observe, and to keep to the
+// minimum delay between probes. The timeout is estimated as in RFC 6298.
+type timing struct {
+	mutex   sync.Mutex
+	initial time.Duration
+	min     time.Duration
+	max     time.Duration
+	srtt    time.Duration
+	rttvar  time.Duration
+	delay   time.Duration
+	next    time.Time
+}
+
+// setTiming configures the timing from options, which must have defaults filled in.
+func (t *timing) setTiming(options Options) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.initial = options.Timeout
+	t.min = options.MinRTTTimeout
+	t.max = options.MaxRTTTimeout
+	t.delay = options.ScanDelay
+}
+
+// probeTimeout returns how long to wait for a reply to a probe.
+func (t *timing) probeTimeout() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.srtt == 0 {
+		return t.initial
+	}
+	timeout := t.srtt + 4*t.rttvar
+	if timeout < t.min {
+		return t.min
+	}
+	if timeout > t.max {
+		return t.max
+	}
+	return timeout
+}
+
+// observeRTT updates the timeout estimate with the round trip time of a probe which got a reply.
+func (t *timing) observeRTT(rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.srtt == 0 {
+		t.srtt = rtt
+		t.rttvar = rtt / 2
+		return
+	}
+	diff := t.srtt - rtt
+	if diff < 0 {
+		diff = -diff
+	}
+	t.rttvar = (3*t.rttvar + diff) / 4
+	t.srtt = (7*t.srtt + rtt) / 8
+}
+
+// pace blocks until the scan delay has passed since the previous probe. It returns early with an error if ctx is
+// cancelled.
+func (t *timing) pace(ctx context.Context) error {
+	t.mutex.Lock()
+	if t.delay <= 0 {
+		t.mutex.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	slot := t.next
+	t.next = t.next.Add(t.delay)
+	t.mutex.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
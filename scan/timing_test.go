@@ -0,0 +1,69 @@
+package scan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimingTemplate(t *testing.T) {
+	normal, err := TimingTemplate(3)
+	require.NoError(t, err)
+	options := normal.Apply(Options{Type: "connect"}).withDefaults()
+	assert.Equal(t, Options{Type: "connect"}.withDefaults(), options)
+
+	insane, err := TimingTemplate(5)
+	require.NoError(t, err)
+	options = insane.Apply(Options{Type: "connect", Rate: 10})
+	assert.Equal(t, 2000, options.Parallelism)
+	assert.Equal(t, 250*time.Millisecond, options.Timeout)
+	assert.Equal(t, 10, options.Rate)
+
+	_, err = TimingTemplate(6)
+	assert.Error(t, err)
+	_, err = TimingTemplate(-1)
+	assert.Error(t, err)
+}
+
+func TestTimingAdaptsTimeout(t *testing.T) {
+	timing := &timing{}
+	timing.setTiming(Options{Timeout: time.Second, MinRTTTimeout: 100 * time.Millisecond, MaxRTTTimeout: 2 * time.Second})
+	assert.Equal(t, time.Second, timing.probeTimeout())
+
+	// fast replies bring the timeout down, but not below the minimum
+	for i := 0; i < 20; i++ {
+		timing.observeRTT(5 * time.Millisecond)
+	}
+	assert.Equal(t, 100*time.Millisecond, timing.probeTimeout())
+
+	// slow replies push it up, but not above the maximum
+	for i := 0; i < 20; i++ {
+		timing.observeRTT(5 * time.Second)
+	}
+	assert.Equal(t, 2*time.Second, timing.probeTimeout())
+}
+
+func TestTimingFixedTimeout(t *testing.T) {
+	timing := &timing{}
+	timing.setTiming(Options{Timeout: time.Second}.withDefaults())
+	timing.observeRTT(5 * time.Millisecond)
+	assert.Equal(t, time.Second, timing.probeTimeout())
+}
+
+func TestTimingPace(t *testing.T) {
+	timing := &timing{}
+	timing.setTiming(Options{ScanDelay: 50 * time.Millisecond})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, timing.pace(context.Background()))
+	}
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, timing.pace(ctx))
+}
@@ -0,0 +1,435 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/liamg/furious/scan"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Schema is the SQLite schema used to store scan history. Each run of furious is a scan, each host in a scan has a
+// row in hosts (even if it is down), and each port reported for a host has a row in ports. JSON arrays are stored
+// as text.
+const Schema = `
+CREATE TABLE IF NOT EXISTS scans (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	version     TEXT NOT NULL,
+	arguments   TEXT NOT NULL,
+	scan_type   TEXT NOT NULL,
+	targets     TEXT NOT NULL,
+	ports       TEXT NOT NULL,
+	started_at  TIMESTAMP NOT NULL,
+	finished_at TIMESTAMP,
+	incomplete  INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS hosts (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	scan_id      INTEGER NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+	address      TEXT NOT NULL,
+	state        TEXT NOT NULL,
+	latency_ms   REAL,
+	mac          TEXT NOT NULL DEFAULT '',
+	manufacturer TEXT NOT NULL DEFAULT '',
+	name         TEXT NOT NULL DEFAULT '',
+	error        TEXT NOT NULL DEFAULT '',
+	os           TEXT NOT NULL DEFAULT '',
+	details      TEXT NOT NULL DEFAULT '[]',
+	scanned_at   TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS hosts_scan_id ON hosts(scan_id);
+CREATE INDEX IF NOT EXISTS hosts_address ON hosts(address);
+
+CREATE TABLE IF NOT EXISTS ports (
+	host_id  INTEGER NOT NULL REFERENCES hosts(id) ON DELETE CASCADE,
+	port     INTEGER NOT NULL,
+	protocol TEXT NOT NULL,
+	state    TEXT NOT NULL,
+	service  TEXT NOT NULL DEFAULT '',
+	version  TEXT NOT NULL DEFAULT '',
+	banner   TEXT NOT NULL DEFAULT '',
+	details  TEXT NOT NULL DEFAULT '[]',
+	PRIMARY KEY (host_id, protocol, port)
+);
+
+CREATE INDEX IF NOT EXISTS ports_port_state ON ports(port, state);
+`
+
+// columns lists columns which were added after the table was first created, so they can be added to existing
+// databases.
+var columns = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{"hosts", "error", "TEXT NOT NULL DEFAULT ''"},
+	{"scans", "incomplete", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// Store persists scans to a SQLite database.
+type Store struct {
+	db *sql.DB
+}
+
+// ScanRecord is a summary of a stored scan.
+type ScanRecord struct {
+	ID        int64
+	Info      scan.ScanInfo
+	Hosts     int
+	HostsUp   int
+	OpenPorts int
+}
+
+// Open opens (creating if necessary) the database at path, and ensures the schema exists.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", dataSource(path, "_foreign_keys=on&_busy_timeout=5000"))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(Schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create schema in %s: %s", path, err)
+	}
+	for _, column := range columns {
+		if err := addColumn(db, column.table, column.name, column.definition); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to update schema in %s: %s", path, err)
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+// OpenReadOnly opens the existing database at path without allowing any changes to it, e.g. for running queries
+// given by the user.
+func OpenReadOnly(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", dataSource(path, "mode=ro&_busy_timeout=5000"))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// dataSource returns the SQLite URI for the database at path with the given query parameters. The path is escaped,
+// since characters such as ? and # would otherwise be taken as part of the URI.
+func dataSource(path string, params string) string {
+	return fmt.Sprintf("file:%s?%s", (&url.URL{Path: path}).EscapedPath(), params)
+}
+
+// addColumn adds a column to a table, unless it already exists
+func addColumn(db *sql.DB, table string, name string, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var column, columnType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &column, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if column == name {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition))
+	return err
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// DB returns the underlying database, for running arbitrary queries.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// BeginScan records the start of a scan, returning its ID.
+func (s *Store) BeginScan(info scan.ScanInfo) (int64, error) {
+	result, err := s.db.Exec(
+		"INSERT INTO scans (version, arguments, scan_type, targets, ports, started_at) VALUES (?, ?, ?, ?, ?, ?)",
+		info.Version,
+		encodeJSON(info.Arguments),
+		info.ScanType,
+		encodeJSON(info.Targets),
+		encodeJSON(info.Ports),
+		info.Start.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// EndScan records the end of a scan, and whether it was cancelled before every target was scanned.
+func (s *Store) EndScan(id int64, end time.Time, incomplete bool) error {
+	_, err := s.db.Exec("UPDATE scans SET finished_at = ?, incomplete = ? WHERE id = ?", end.UTC(), incomplete, id)
+	return err
+}
+
+// AddHost records a host, and all of its ports, as part of a scan.
+func (s *Store) AddHost(scanID int64, host scan.HostReport) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	os := ""
+	if len(host.OS) > 0 {
+		os = host.OS[0].Name
+	}
+
+	var latency interface{}
+	if host.State == scan.HostUp {
+		latency = host.LatencyMS
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO hosts (scan_id, address, state, latency_ms, mac, manufacturer, name, error, os, details, scanned_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		scanID,
+		host.Host,
+		host.State,
+		latency,
+		host.MAC,
+		host.Manufacturer,
+		host.Name,
+		host.Error,
+		os,
+		encodeJSON(host.Details),
+		time.Now().UTC(),
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	hostID, err := result.LastInsertId()
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	for _, port := range host.Ports {
+		if _, err := tx.Exec(
+			"INSERT INTO ports (host_id, port, protocol, state, service, version, banner, details) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			hostID,
+			port.Port,
+			port.Protocol,
+			port.State,
+			port.Service,
+			port.Version,
+			port.Banner,
+			encodeJSON(port.Details),
+		); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Scans lists all stored scans, most recent first.
+func (s *Store) Scans() ([]ScanRecord, error) {
+	rows, err := s.db.Query(`
+		SELECT s.id, s.version, s.arguments, s.scan_type, s.targets, s.ports, s.started_at, s.finished_at, s.incomplete,
+			(SELECT COUNT(*) FROM hosts h WHERE h.scan_id = s.id),
+			(SELECT COUNT(*) FROM hosts h WHERE h.scan_id = s.id AND h.state = 'up'),
+			(SELECT COUNT(*) FROM ports p JOIN hosts h ON h.id = p.host_id WHERE h.scan_id = s.id AND p.state = 'open')
+		FROM scans s
+		ORDER BY s.started_at DESC, s.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []ScanRecord{}
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+	return records, rows.Err()
+}
+
+// Scan returns the stored scan with the given ID.
+func (s *Store) Scan(id int64) (*ScanRecord, error) {
+	records, err := s.Scans()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.ID == id {
+			return &record, nil
+		}
+	}
+	return nil, fmt.Errorf("scan %d not found", id)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRecord(row scanner) (*ScanRecord, error) {
+	record := &ScanRecord{}
+	var arguments, targets, ports string
+	var finished *time.Time
+	if err := row.Scan(
+		&record.ID,
+		&record.Info.Version,
+		&arguments,
+		&record.Info.ScanType,
+		&targets,
+		&ports,
+		&record.Info.Start,
+		&finished,
+		&record.Info.Incomplete,
+		&record.Hosts,
+		&record.HostsUp,
+		&record.OpenPorts,
+	); err != nil {
+		return nil, err
+	}
+	record.Info.End = finished
+	_ = json.Unmarshal([]byte(arguments), &record.Info.Arguments)
+	_ = json.Unmarshal([]byte(targets), &record.Info.Targets)
+	_ = json.Unmarshal([]byte(ports), &record.Info.Ports)
+	return record, nil
+}
+
+// Hosts returns the hosts recorded by a scan, including their ports, in the order they were scanned.
+func (s *Store) Hosts(scanID int64) ([]scan.HostReport, error) {
+	rows, err := s.db.Query(
+		"SELECT id, address, state, latency_ms, mac, manufacturer, name, error, os, details FROM hosts WHERE scan_id = ? ORDER BY id",
+		scanID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := []scan.HostReport{}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		var latency sql.NullFloat64
+		var os, details string
+		host := scan.HostReport{Ports: []scan.PortReport{}}
+		if err := rows.Scan(&id, &host.Host, &host.State, &latency, &host.MAC, &host.Manufacturer, &host.Name, &host.Error, &os, &details); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		host.LatencyMS = latency.Float64
+		if os != "" {
+			host.OS = []scan.OSMatch{{Name: os}}
+		}
+		_ = json.Unmarshal([]byte(details), &host.Details)
+		hosts = append(hosts, host)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		ports, err := s.ports(id)
+		if err != nil {
+			return nil, err
+		}
+		hosts[i].Ports = ports
+	}
+
+	return hosts, nil
+}
+
+func (s *Store) ports(hostID int64) ([]scan.PortReport, error) {
+	rows, err := s.db.Query(
+		"SELECT port, protocol, state, service, version, banner, details FROM ports WHERE host_id = ? ORDER BY port",
+		hostID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ports := []scan.PortReport{}
+	for rows.Next() {
+		var details string
+		port := scan.PortReport{}
+		if err := rows.Scan(&port.Port, &port.Protocol, &port.State, &port.Service, &port.Version, &port.Banner, &details); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal([]byte(details), &port.Details)
+		ports = append(ports, port)
+	}
+	return ports, rows.Err()
+}
+
+// Query runs arbitrary SQL, returning the column names and each row formatted as strings.
+func (s *Store) Query(query string, args ...interface{}) ([]string, [][]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := [][]string{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+		row := make([]string, len(columns))
+		for i, value := range values {
+			switch v := value.(type) {
+			case nil:
+				row[i] = "NULL"
+			case []byte:
+				row[i] = string(v)
+			case time.Time:
+				row[i] = v.Format(time.RFC3339)
+			default:
+				row[i] = strings.TrimSpace(fmt.Sprintf("%v", v))
+			}
+		}
+		results = append(results, row)
+	}
+	return columns, results, rows.Err()
+}
+
+func encodeJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return "[]"
+	}
+	return string(data)
+}
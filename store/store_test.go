@@ -0,0 +1,148 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/liamg/furious/scan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "furious")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := Open(filepath.Join(dir, "scans.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	info := scan.ScanInfo{
+		Version:   "1.0.0",
+		Arguments: []string{"-s", "connect", "10.0.0.5"},
+		ScanType:  "connect",
+		Targets:   []string{"10.0.0.5"},
+		Ports:     []int{22, 3389},
+		Start:     start,
+		End:       &end,
+	}
+
+	result := scan.NewResult(net.ParseIP("10.0.0.5"))
+	result.Latency = time.Millisecond
+	result.Open = []int{3389}
+	result.Closed = []int{22}
+	result.Services = []scan.Service{{Port: 3389, Name: "rdp", Banner: "hello"}}
+
+	writer := NewWriter(db)
+	require.NoError(t, writer.Begin(info))
+	require.NoError(t, writer.WriteResult(result))
+	failed := scan.NewResult(net.ParseIP("10.0.0.6"))
+	failed.Error = &scan.HostError{Status: scan.HostUnreachable, Err: errors.New("no route")}
+	require.NoError(t, writer.WriteResult(failed))
+	info.Incomplete = true
+	require.NoError(t, writer.End(info))
+
+	scans, err := db.Scans()
+	require.NoError(t, err)
+	require.Len(t, scans, 1)
+	assert.Equal(t, writer.ScanID(), scans[0].ID)
+	assert.Equal(t, info.Arguments, scans[0].Info.Arguments)
+	assert.Equal(t, info.Ports, scans[0].Info.Ports)
+	assert.True(t, start.Equal(scans[0].Info.Start))
+	require.NotNil(t, scans[0].Info.End)
+	assert.True(t, end.Equal(*scans[0].Info.End))
+	assert.True(t, scans[0].Info.Incomplete)
+	assert.Equal(t, 2, scans[0].Hosts)
+	assert.Equal(t, 1, scans[0].HostsUp)
+	assert.Equal(t, 1, scans[0].OpenPorts)
+
+	hosts, err := db.Hosts(writer.ScanID())
+	require.NoError(t, err)
+	require.Len(t, hosts, 2)
+	assert.Equal(t, "10.0.0.5", hosts[0].Host)
+	assert.Equal(t, scan.HostUp, hosts[0].State)
+	assert.Equal(t, 1.0, hosts[0].LatencyMS)
+	assert.Equal(t, []scan.PortReport{
+		{Port: 22, Protocol: "tcp", State: "closed", Details: []string{}},
+		{Port: 3389, Protocol: "tcp", State: "open", Service: "rdp", Banner: "hello", Details: []string{}},
+	}, hosts[0].Ports)
+	assert.Equal(t, scan.HostUnreachable, hosts[1].State)
+	assert.Equal(t, "no route", hosts[1].Error)
+
+	columns, rows, err := db.Query("SELECT h.address, p.port FROM ports p JOIN hosts h ON h.id = p.host_id WHERE p.state = ?", "open")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"address", "port"}, columns)
+	assert.Equal(t, [][]string{{"10.0.0.5", "3389"}}, rows)
+}
+
+func TestOpenAddsMissingColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "furious")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scans.db")
+
+	// a database created before the error column existed
+	db, err := Open(path)
+	require.NoError(t, err)
+	_, err = db.DB().Exec("DROP TABLE ports; DROP TABLE hosts; CREATE TABLE hosts (id INTEGER PRIMARY KEY AUTOINCREMENT, scan_id INTEGER NOT NULL, address TEXT NOT NULL, state TEXT NOT NULL, latency_ms REAL, mac TEXT NOT NULL DEFAULT '', manufacturer TEXT NOT NULL DEFAULT '', name TEXT NOT NULL DEFAULT '', os TEXT NOT NULL DEFAULT '', details TEXT NOT NULL DEFAULT '[]', scanned_at TIMESTAMP NOT NULL)")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	columns, _, err := db.Query("SELECT error FROM hosts")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"error"}, columns)
+}
+
+func TestOpenEscapesPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "furious")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scans?mode=ro#1 %20.db")
+	db, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "furious")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scans.db")
+	_, err = OpenReadOnly(path)
+	assert.Error(t, err, "a missing database is not created")
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, NewWriter(db).Begin(scan.ScanInfo{ScanType: "connect", Start: time.Now()}))
+	require.NoError(t, db.Close())
+
+	db, err = OpenReadOnly(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, rows, err := db.Query("SELECT COUNT(*) FROM scans")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"1"}}, rows)
+
+	_, _, err = db.Query("DELETE FROM scans")
+	assert.Error(t, err)
+	_, rows, err = db.Query("SELECT COUNT(*) FROM scans")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"1"}}, rows)
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"time"
+
+	"github.com/liamg/furious/scan"
+)
+
+// Writer records a scan in the store as it runs. It implements output.Writer.
+type Writer struct {
+	store  *Store
+	scanID int64
+}
+
+// NewWriter creates a Writer which records scans in store.
+func NewWriter(store *Store) *Writer {
+	return &Writer{store: store}
+}
+
+func (w *Writer) Begin(info scan.ScanInfo) error {
+	id, err := w.store.BeginScan(info)
+	if err != nil {
+		return err
+	}
+	w.scanID = id
+	return nil
+}
+
+func (w *Writer) WriteResult(result scan.Result) error {
+	return w.store.AddHost(w.scanID, result.Report())
+}
+
+func (w *Writer) End(info scan.ScanInfo) error {
+	end := time.Now()
+	if info.End != nil {
+		end = *info.End
+	}
+	return w.store.EndScan(w.scanID, end, info.Incomplete)
+}
+
+// ScanID returns the ID of the scan being recorded.
+func (w *Writer) ScanID() int64 {
+	return w.scanID
+}